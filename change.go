@@ -0,0 +1,154 @@
+package grainfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// Change interface implementation
+//
+// Changes are recorded in the encrypted per-entry metadata, which is what Stat and
+// ReadDir report. When the underlying filesystem implements billy.Change the change is
+// applied to the obfuscated object as well, so tools inspecting the backing store see
+// the same modes and timestamps.
+
+// Chmod changes the mode of the named file to mode
+func (fs *GrainFS) Chmod(name string, mode os.FileMode) error {
+	if err := fs.checkWritable("chmod", name); err != nil {
+		return err
+	}
+
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return err
+	}
+
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	if err := fs.changeUnderlying(name, func(change billy.Change, obfuscatedPath string) error {
+		return change.Chmod(obfuscatedPath, mode)
+	}); err != nil {
+		return err
+	}
+
+	return fs.updateMetadata(name, func(md *FileMetadata) {
+		const settable = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+		md.Mode = (md.Mode &^ settable) | (mode & settable)
+	})
+}
+
+// Lchown changes the numeric uid and gid of the named file without following symbolic
+// links. A value of -1 leaves the corresponding id unchanged.
+func (fs *GrainFS) Lchown(name string, uid, gid int) error {
+	if err := fs.checkWritable("lchown", name); err != nil {
+		return err
+	}
+
+	name, err := fs.resolveSymlinks(name, false)
+	if err != nil {
+		return err
+	}
+
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	if err := fs.changeUnderlying(name, func(change billy.Change, obfuscatedPath string) error {
+		return change.Lchown(obfuscatedPath, uid, gid)
+	}); err != nil {
+		return err
+	}
+
+	return fs.updateMetadata(name, chownFunc(uid, gid))
+}
+
+// Chown changes the numeric uid and gid of the named file. A value of -1 leaves the
+// corresponding id unchanged.
+func (fs *GrainFS) Chown(name string, uid, gid int) error {
+	if err := fs.checkWritable("chown", name); err != nil {
+		return err
+	}
+
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return err
+	}
+
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	if err := fs.changeUnderlying(name, func(change billy.Change, obfuscatedPath string) error {
+		return change.Chown(obfuscatedPath, uid, gid)
+	}); err != nil {
+		return err
+	}
+
+	return fs.updateMetadata(name, chownFunc(uid, gid))
+}
+
+// Chtimes changes the access and modification times of the named file. A zero
+// time.Time leaves the corresponding time unchanged.
+func (fs *GrainFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := fs.checkWritable("chtimes", name); err != nil {
+		return err
+	}
+
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return err
+	}
+
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	if err := fs.changeUnderlying(name, func(change billy.Change, obfuscatedPath string) error {
+		return change.Chtimes(obfuscatedPath, atime, mtime)
+	}); err != nil {
+		return err
+	}
+
+	return fs.updateMetadata(name, func(md *FileMetadata) {
+		if !atime.IsZero() {
+			md.AccessTime = atime
+		}
+		if !mtime.IsZero() {
+			md.ModTime = mtime
+		}
+	})
+}
+
+// chownFunc returns a metadata update setting the given ownership
+func chownFunc(uid, gid int) func(md *FileMetadata) {
+	return func(md *FileMetadata) {
+		if uid != -1 {
+			md.UID = uid
+		}
+		if gid != -1 {
+			md.GID = gid
+		}
+	}
+}
+
+// changeUnderlying maps name to the path holding its content and calls fn with it when
+// the filesystem storing it supports billy.Change. It is a no-op otherwise.
+func (fs *GrainFS) changeUnderlying(name string, fn func(change billy.Change, obfuscatedPath string) error) error {
+	if name == "" {
+		return fmt.Errorf("filename cannot be empty")
+	}
+
+	storage, path, err := fs.contentLocation(name)
+	if err != nil {
+		return err
+	}
+
+	change, ok := storage.(billy.Change)
+	if !ok {
+		return nil
+	}
+
+	return fn(change, path)
+}
@@ -0,0 +1,136 @@
+package grainfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// changeRecorder is a billy.Filesystem that supports billy.Change by recording calls
+type changeRecorder struct {
+	billy.Filesystem
+	modes  map[string]os.FileMode
+	owners map[string][2]int
+	mtimes map[string]time.Time
+}
+
+func newChangeRecorder() *changeRecorder {
+	return &changeRecorder{
+		Filesystem: memfs.New(),
+		modes:      make(map[string]os.FileMode),
+		owners:     make(map[string][2]int),
+		mtimes:     make(map[string]time.Time),
+	}
+}
+
+func (c *changeRecorder) Chmod(name string, mode os.FileMode) error {
+	if _, err := c.Stat(name); err != nil {
+		return err
+	}
+	c.modes[name] = mode
+	return nil
+}
+
+func (c *changeRecorder) Lchown(name string, uid, gid int) error {
+	c.owners[name] = [2]int{uid, gid}
+	return nil
+}
+
+func (c *changeRecorder) Chown(name string, uid, gid int) error {
+	c.owners[name] = [2]int{uid, gid}
+	return nil
+}
+
+func (c *changeRecorder) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	c.mtimes[name] = mtime
+	return nil
+}
+
+func TestGrainFSChangeDelegatesToUnderlying(t *testing.T) {
+	underlying := newChangeRecorder()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("bin/tool", []byte("#!/bin/sh\n")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPath("bin/tool")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+
+	if err := fs.Chmod("bin/tool", 0755); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.Chown("bin/tool", 10, 20); err != nil {
+		t.Fatalf("Chown failed: %v", err)
+	}
+	mtime := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := fs.Chtimes("bin/tool", time.Time{}, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	// The underlying filesystem only ever sees the obfuscated path
+	if underlying.modes[obfuscatedPath] != 0755 {
+		t.Fatalf("Expected underlying Chmod on %s, got %v", obfuscatedPath, underlying.modes)
+	}
+	if underlying.owners[obfuscatedPath] != [2]int{10, 20} {
+		t.Fatalf("Expected underlying Chown on %s, got %v", obfuscatedPath, underlying.owners)
+	}
+	if !underlying.mtimes[obfuscatedPath].Equal(mtime) {
+		t.Fatalf("Expected underlying Chtimes on %s, got %v", obfuscatedPath, underlying.mtimes)
+	}
+
+	// And the change is reported through Stat
+	info, err := fs.Stat("bin/tool")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode() != 0755 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mode 0755 and mtime %v, got %v and %v", mtime, info.Mode(), info.ModTime())
+	}
+	md := info.Sys().(*FileMetadata)
+	if md.UID != 10 || md.GID != 20 {
+		t.Fatalf("Expected ownership 10:20, got %d:%d", md.UID, md.GID)
+	}
+
+	// Errors from the underlying filesystem are returned as-is
+	if err := fs.Chmod("bin/missing", 0644); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestGrainFSChangeFallback(t *testing.T) {
+	underlying := memfs.New()
+	if _, ok := underlying.(billy.Change); ok {
+		t.Skip("memfs supports billy.Change, fallback not exercised")
+	}
+
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	var change billy.Change = fs
+	if _, err := fs.Write("file.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := change.Chown("file.txt", 7, 8); err != nil {
+		t.Fatalf("Chown failed: %v", err)
+	}
+
+	info, err := fs.Stat("file.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	md := info.Sys().(*FileMetadata)
+	if md.UID != 7 || md.GID != 8 {
+		t.Fatalf("Expected ownership 7:8, got %d:%d", md.UID, md.GID)
+	}
+}
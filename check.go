@@ -0,0 +1,443 @@
+package grainfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// LostAndFoundDir is the directory Check moves orphaned objects into when repairing
+const LostAndFoundDir = "lost+found"
+
+// CheckKind classifies the problems found by Check
+type CheckKind int
+
+const (
+	// CheckUndecryptable is a filemap, metadata file or link table that cannot be
+	// decrypted or decoded
+	CheckUndecryptable CheckKind = iota
+	// CheckOrphan is an object on disk missing from its directory's filemap, or a
+	// shared content object missing from the link table
+	CheckOrphan
+	// CheckDangling is a filemap entry without an object on disk, or a link table
+	// entry without its content object
+	CheckDangling
+	// CheckDuplicate is a name mapped to by several objects of a directory
+	CheckDuplicate
+	// CheckCollision is a name stored under a collision-suffixed obfuscated name. It
+	// is not an error.
+	CheckCollision
+	// CheckCorrupt is file content that fails authentication or is missing
+	CheckCorrupt
+)
+
+// String returns a short description of the kind
+func (k CheckKind) String() string {
+	switch k {
+	case CheckUndecryptable:
+		return "undecryptable"
+	case CheckOrphan:
+		return "orphan"
+	case CheckDangling:
+		return "dangling"
+	case CheckDuplicate:
+		return "duplicate"
+	case CheckCollision:
+		return "collision"
+	case CheckCorrupt:
+		return "corrupt"
+	default:
+		return fmt.Sprintf("CheckKind(%d)", int(k))
+	}
+}
+
+// IsError reports whether problems of the kind indicate an inconsistent volume
+func (k CheckKind) IsError() bool {
+	return k != CheckCollision
+}
+
+// CheckOptions configures Check
+type CheckOptions struct {
+	// Repair moves orphaned objects into lost+found and removes dangling filemap
+	// entries
+	Repair bool
+}
+
+// CheckProblem is a problem found by Check
+type CheckProblem struct {
+	Kind CheckKind
+	// Path is the user path of the affected entry, empty if it is unknown
+	Path string
+	// ObfuscatedPath is the path of the affected object on the underlying filesystem
+	ObfuscatedPath string
+	// Err describes the problem
+	Err error
+	// Repaired reports whether the problem was repaired, and RepairedPath where a
+	// recovered object can now be found
+	Repaired     bool
+	RepairedPath string
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	Directories int
+	Files       int
+	Problems    []CheckProblem
+}
+
+// OK reports whether the volume is consistent, ignoring repaired problems
+func (r *CheckReport) OK() bool {
+	for _, problem := range r.Problems {
+		if problem.Kind.IsError() && !problem.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Check verifies the consistency of the filesystem: every filemap and metadata file
+// decrypts, every filemap entry points at an existing object, every object is named by
+// the filemap, and all file content authenticates. With opts.Repair it moves orphaned
+// objects into lost+found, naming them after the original name when it can be
+// recovered from the obfuscated one, and removes dangling entries. Other problems are
+// only reported.
+//
+// Check locks the whole filesystem while it runs. It returns an error only if the
+// check itself could not be carried out.
+func (fs *GrainFS) Check(opts CheckOptions) (*CheckReport, error) {
+	if opts.Repair {
+		if err := fs.checkWritable("check", "."); err != nil {
+			return nil, err
+		}
+	}
+
+	unlock := fs.lockPaths(nil, []string{"."})
+	defer unlock()
+
+	c := &checker{fs: fs, opts: opts, report: &CheckReport{}, objects: make(map[string]bool)}
+	if err := c.checkDir(".", "."); err != nil {
+		return nil, err
+	}
+	if fs.rootPath == "." {
+		if err := c.checkObjects(); err != nil {
+			return nil, err
+		}
+	}
+	return c.report, nil
+}
+
+// checker holds the state of a running Check
+type checker struct {
+	fs     *GrainFS
+	opts   CheckOptions
+	report *CheckReport
+	// objects records the shared content objects already verified
+	objects map[string]bool
+}
+
+// problem records a problem
+func (c *checker) problem(kind CheckKind, path, obfuscatedPath string, err error) *CheckProblem {
+	c.report.Problems = append(c.report.Problems, CheckProblem{
+		Kind:           kind,
+		Path:           path,
+		ObfuscatedPath: obfuscatedPath,
+		Err:            err,
+	})
+	return &c.report.Problems[len(c.report.Problems)-1]
+}
+
+// checkDir checks the user directory dir, stored at obfuscatedDir, and everything
+// below it
+func (c *checker) checkDir(dir, obfuscatedDir string) error {
+	fs := c.fs
+	c.report.Directories++
+
+	infos, err := fs.readUnderlyingDir(obfuscatedDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	filemap, _, err := fs.readFilemap(obfuscatedDir)
+	if err != nil {
+		// Without the filemap, nothing in the directory can be named
+		c.problem(CheckUndecryptable, dir, filepath.Join(obfuscatedDir, GrainFSDir, FilemapFile), err)
+		return nil
+	}
+	metadata, _, err := fs.readMetadata(obfuscatedDir)
+	repairable := c.opts.Repair
+	if err != nil {
+		c.problem(CheckUndecryptable, dir, filepath.Join(obfuscatedDir, GrainFSDir, MetadataFile), err)
+		repairable = false
+	}
+
+	// Names mapped to by several objects, and names stored under another name than
+	// their own because of a collision
+	byName := make(map[string][]string)
+	for obfuscated, original := range filemap {
+		byName[original] = append(byName[original], obfuscated)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		obfuscatedNames := byName[name]
+		sort.Strings(obfuscatedNames)
+		if len(obfuscatedNames) > 1 {
+			c.problem(CheckDuplicate, path, filepath.Join(obfuscatedDir, obfuscatedNames[0]),
+				fmt.Errorf("name mapped to by %d objects: %s", len(obfuscatedNames), strings.Join(obfuscatedNames, ", ")))
+		}
+		for _, obfuscated := range obfuscatedNames {
+			if expected, err := obfuscateFilename(fs.filenameKey, name); err == nil && obfuscated != expected {
+				c.problem(CheckCollision, path, filepath.Join(obfuscatedDir, obfuscated),
+					fmt.Errorf("stored under collision-suffixed name"))
+			}
+		}
+	}
+
+	// Every object on disk must be named by the filemap
+	onDisk := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		if err := fs.contextErr(); err != nil {
+			return err
+		}
+		if info.Name() == GrainFSDir {
+			continue
+		}
+		onDisk[info.Name()] = true
+		obfuscatedPath := filepath.Join(obfuscatedDir, info.Name())
+
+		original, exists := filemap[info.Name()]
+		if !exists {
+			problem := c.problem(CheckOrphan, "", obfuscatedPath, ErrNotInFilemap)
+			if repairable {
+				if err := c.recoverOrphan(problem, dir, info.Name()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		path := filepath.Join(dir, original)
+		if info.IsDir() {
+			if err := c.checkDir(path, obfuscatedPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c.report.Files++
+		if md := metadata[info.Name()]; md != nil && md.Object != "" {
+			c.checkObject(path, md.Object)
+		} else if err := c.verifyContent(fs.underlying, obfuscatedPath); err != nil {
+			c.problem(CheckCorrupt, path, obfuscatedPath, err)
+		}
+	}
+
+	// Every filemap entry must have an object on disk
+	for _, obfuscated := range sortedKeys(filemap) {
+		if onDisk[obfuscated] {
+			continue
+		}
+		path := filepath.Join(dir, filemap[obfuscated])
+		problem := c.problem(CheckDangling, path, filepath.Join(obfuscatedDir, obfuscated), ErrMissingOnDisk)
+		if repairable {
+			if err := fs.removeFromFilemap(dir, obfuscated); err != nil {
+				return err
+			}
+			if err := fs.dropMetadata(dir, obfuscated); err != nil {
+				return err
+			}
+			fs.filemapManager.invalidate(fs.cacheKey(path))
+			problem.Repaired = true
+		}
+	}
+
+	return nil
+}
+
+// checkObject verifies the shared content object id of the linked file path
+func (c *checker) checkObject(path, id string) {
+	if verified, seen := c.objects[id]; seen {
+		if !verified {
+			c.problem(CheckCorrupt, path, objectPath(id), fmt.Errorf("shared content object %s is damaged", id))
+		}
+		return
+	}
+
+	err := c.verifyContent(c.fs.volume, objectPath(id))
+	c.objects[id] = err == nil
+	if err != nil {
+		c.problem(CheckCorrupt, path, objectPath(id), err)
+	}
+}
+
+// checkObjects checks the shared content objects against the link table
+func (c *checker) checkObjects() error {
+	fs := c.fs
+
+	table, err := fs.loadLinkTable()
+	if err != nil {
+		c.problem(CheckUndecryptable, "", filepath.Join(GrainFSDir, LinksFile), err)
+		return nil
+	}
+
+	infos, err := fs.volume.ReadDir(filepath.Join(GrainFSDir, ObjectsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", ObjectsDir, err)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	stored := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		id := info.Name()
+		stored[id] = true
+		if _, exists := table[id]; exists {
+			if _, seen := c.objects[id]; !seen {
+				// Not linked from the checked tree, verify it anyway
+				if err := c.verifyContent(fs.volume, objectPath(id)); err != nil {
+					c.problem(CheckCorrupt, "", objectPath(id), err)
+				}
+			}
+			continue
+		}
+
+		problem := c.problem(CheckOrphan, "", objectPath(id), fmt.Errorf("content object missing from link table"))
+		if c.opts.Repair {
+			if err := c.recoverObject(problem, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, id := range sortedKeys(table) {
+		if !stored[id] {
+			c.problem(CheckDangling, "", objectPath(id), fmt.Errorf("link table entry without content object"))
+		}
+	}
+	return nil
+}
+
+// verifyContent checks that the encrypted content at path authenticates. Empty
+// objects are files that were created but never written.
+func (c *checker) verifyContent(storage billy.Basic, path string) error {
+	file, err := storage.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	_, err = decryptData(c.fs.aead, data)
+	return err
+}
+
+// recoverOrphan moves the orphaned object obfuscated of the user directory dir into
+// lost+found
+func (c *checker) recoverOrphan(problem *CheckProblem, dir, obfuscated string) error {
+	fs := c.fs
+
+	// Names not mangled by collision handling can be recovered
+	name, err := deobfuscateFilename(fs.filenameKey, obfuscated)
+	if err != nil || name == "" || strings.ContainsRune(name, filepath.Separator) {
+		name = "#" + obfuscated
+	}
+
+	lostName, lostObfuscatedPath, err := c.lostAndFoundEntry(name)
+	if err != nil {
+		return err
+	}
+
+	obfuscatedDir, err := fs.getObfuscatedPath(dir)
+	if err != nil {
+		return err
+	}
+	if err := fs.underlying.Rename(filepath.Join(obfuscatedDir, obfuscated), lostObfuscatedPath); err != nil {
+		return fmt.Errorf("failed to move orphan into %s: %w", LostAndFoundDir, err)
+	}
+	if err := fs.moveMetadata(dir, obfuscated, LostAndFoundDir, filepath.Base(lostObfuscatedPath)); err != nil {
+		return err
+	}
+
+	problem.Repaired = true
+	problem.RepairedPath = filepath.Join(LostAndFoundDir, lostName)
+	return nil
+}
+
+// recoverObject moves the shared content object id, missing from the link table, into
+// lost+found as a regular file
+func (c *checker) recoverObject(problem *CheckProblem, id string) error {
+	lostName, lostObfuscatedPath, err := c.lostAndFoundEntry("object-" + id)
+	if err != nil {
+		return err
+	}
+
+	if err := c.fs.volume.Rename(objectPath(id), filepath.Join(c.fs.volumePrefix, lostObfuscatedPath)); err != nil {
+		return fmt.Errorf("failed to move object into %s: %w", LostAndFoundDir, err)
+	}
+
+	problem.Repaired = true
+	problem.RepairedPath = filepath.Join(LostAndFoundDir, lostName)
+	return nil
+}
+
+// lostAndFoundEntry registers a new entry in lost+found, creating it if needed, under
+// name or a variant of it not taken yet. It returns the name and obfuscated path of
+// the entry.
+func (c *checker) lostAndFoundEntry(name string) (string, string, error) {
+	fs := c.fs
+
+	if err := fs.mkdirLevelLocked(".", LostAndFoundDir, 0700); err != nil {
+		return "", "", fmt.Errorf("failed to create %s: %w", LostAndFoundDir, err)
+	}
+	obfuscatedDir, err := fs.getObfuscatedPath(LostAndFoundDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	filemap, err := fs.reloadFilemap(LostAndFoundDir)
+	if err != nil {
+		return "", "", err
+	}
+	candidate := name
+	for i := 1; ; i++ {
+		if _, taken := lookupObfuscatedName(filemap, candidate); !taken {
+			break
+		}
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+
+	obfuscated, err := fs.obfuscateFilename(LostAndFoundDir, candidate)
+	if err != nil {
+		return "", "", err
+	}
+	return candidate, filepath.Join(obfuscatedDir, obfuscated), nil
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,235 @@
+package grainfs
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// findProblem returns the first problem of kind in report, or nil
+func findProblem(report *CheckReport, kind CheckKind) *CheckProblem {
+	for i := range report.Problems {
+		if report.Problems[i].Kind == kind {
+			return &report.Problems[i]
+		}
+	}
+	return nil
+}
+
+func TestGrainFSCheck(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	for _, name := range []string{"dir/fine.txt", "dir/gone.txt", "dir/lost.txt", "other.txt"} {
+		if _, err := fs.Write(name, []byte("content of "+name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	if err := fs.Link("other.txt", "dir/linked.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	report, err := fs.Check(CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || len(report.Problems) != 0 {
+		t.Fatalf("Expected a consistent filesystem, got %+v", report.Problems)
+	}
+	if report.Directories != 2 || report.Files != 5 {
+		t.Fatalf("Expected 2 directories and 5 files, got %d and %d", report.Directories, report.Files)
+	}
+
+	// Forget the name of lost.txt, lose the object of gone.txt and damage fine.txt
+	lost, err := fs.getObfuscatedPath("dir/lost.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	if err := fs.removeFromFilemap("dir", filepath.Base(lost)); err != nil {
+		t.Fatalf("Failed to remove filemap entry: %v", err)
+	}
+	gone, err := fs.getObfuscatedPath("dir/gone.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	if err := underlying.Remove(gone); err != nil {
+		t.Fatalf("Failed to remove object: %v", err)
+	}
+	fine, err := fs.getObfuscatedPath("dir/fine.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	data, err := util.ReadFile(underlying, fine)
+	if err != nil {
+		t.Fatalf("Failed to read object: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := util.WriteFile(underlying, fine, data, 0644); err != nil {
+		t.Fatalf("Failed to damage object: %v", err)
+	}
+
+	report, err = fs.Check(CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.OK() || len(report.Problems) != 3 {
+		t.Fatalf("Expected 3 problems, got %+v", report.Problems)
+	}
+	if problem := findProblem(report, CheckOrphan); problem == nil || problem.ObfuscatedPath != lost || !errors.Is(problem.Err, ErrNotInFilemap) {
+		t.Fatalf("Expected orphaned lost.txt, got %+v", problem)
+	}
+	if problem := findProblem(report, CheckDangling); problem == nil || problem.Path != "dir/gone.txt" {
+		t.Fatalf("Expected dangling gone.txt, got %+v", problem)
+	}
+	if problem := findProblem(report, CheckCorrupt); problem == nil || problem.Path != "dir/fine.txt" {
+		t.Fatalf("Expected corrupt fine.txt, got %+v", problem)
+	}
+
+	// Repair recovers the orphan under its original name and drops the dangling entry
+	report, err = fs.Check(CheckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Check with repair failed: %v", err)
+	}
+	if problem := findProblem(report, CheckOrphan); problem == nil || !problem.Repaired || problem.RepairedPath != "lost+found/lost.txt" {
+		t.Fatalf("Expected lost.txt to be recovered, got %+v", problem)
+	}
+	if problem := findProblem(report, CheckDangling); problem == nil || !problem.Repaired {
+		t.Fatalf("Expected gone.txt to be removed, got %+v", problem)
+	}
+	if problem := findProblem(report, CheckCorrupt); problem == nil || problem.Repaired {
+		t.Fatalf("Expected fine.txt to stay corrupt, got %+v", problem)
+	}
+
+	recovered, err := util.ReadFile(fs, "lost+found/lost.txt")
+	if err != nil || string(recovered) != "content of dir/lost.txt" {
+		t.Fatalf("Expected recovered content, got %q: %v", recovered, err)
+	}
+	if _, err := fs.Stat("dir/gone.txt"); err == nil {
+		t.Fatalf("Expected gone.txt to be gone")
+	}
+	content, err := util.ReadFile(fs, "dir/linked.txt")
+	if err != nil || string(content) != "content of other.txt" {
+		t.Fatalf("Expected linked content to survive, got %q: %v", content, err)
+	}
+
+	// Only the damaged content is left
+	report, err = fs.Check(CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != CheckCorrupt {
+		t.Fatalf("Expected only the corrupt file, got %+v", report.Problems)
+	}
+}
+
+func TestGrainFSCheckMetadataProblems(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	for _, name := range []string{"dir/a.txt", "b.txt"} {
+		if _, err := fs.Write(name, []byte(name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	b, err := fs.getObfuscatedPath("b.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+
+	// A second object claiming the name b.txt under a collision-suffixed name
+	if err := util.WriteFile(underlying, b+".1", nil, 0644); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+	if err := fs.modifyFilemap(".", func(filemap FilenameMap) bool {
+		filemap[filepath.Base(b)+".1"] = "b.txt"
+		return true
+	}); err != nil {
+		t.Fatalf("Failed to modify filemap: %v", err)
+	}
+
+	// A directory whose filemap cannot be decrypted
+	dir, err := fs.getObfuscatedPath("dir")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	if err := util.WriteFile(underlying, filepath.Join(dir, GrainFSDir, FilemapFile), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed to damage filemap: %v", err)
+	}
+
+	report, err := fs.Check(CheckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.OK() {
+		t.Fatalf("Expected problems, got %+v", report.Problems)
+	}
+	if problem := findProblem(report, CheckDuplicate); problem == nil || problem.Path != "b.txt" {
+		t.Fatalf("Expected duplicate b.txt, got %+v", problem)
+	}
+	if problem := findProblem(report, CheckCollision); problem == nil || problem.ObfuscatedPath != b+".1" {
+		t.Fatalf("Expected collision-suffixed b.txt, got %+v", problem)
+	}
+	if problem := findProblem(report, CheckUndecryptable); problem == nil || problem.Path != "dir" || problem.Repaired {
+		t.Fatalf("Expected undecryptable filemap of dir, got %+v", problem)
+	}
+	if _, err := fs.Stat(LostAndFoundDir); err == nil {
+		t.Fatalf("Expected nothing to be recovered from an undecryptable directory")
+	}
+
+	// Repairing needs write access
+	if _, err := fs.ReadOnly().Check(CheckOptions{Repair: true}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected ErrReadOnly, got %v", err)
+	}
+	if _, err := fs.ReadOnly().Check(CheckOptions{}); err != nil {
+		t.Fatalf("Expected read-only check to succeed, got %v", err)
+	}
+}
+
+func TestGrainFSCheckObjects(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("a.txt", []byte("shared")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := fs.Link("a.txt", "b.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	md, err := fs.lookupMetadata("a.txt")
+	if err != nil || md.Object == "" {
+		t.Fatalf("Expected linked metadata, got %v: %v", md, err)
+	}
+
+	// An object the link table forgot
+	if err := fs.modifyLinkTable(func(table linkTable) error {
+		delete(table, md.Object)
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to modify link table: %v", err)
+	}
+
+	report, err := fs.Check(CheckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	problem := findProblem(report, CheckOrphan)
+	if problem == nil || problem.ObfuscatedPath != objectPath(md.Object) || !problem.Repaired {
+		t.Fatalf("Expected recovered orphan object, got %+v", report.Problems)
+	}
+	content, err := util.ReadFile(fs, problem.RepairedPath)
+	if err != nil || string(content) != "shared" {
+		t.Fatalf("Expected recovered content, got %q: %v", content, err)
+	}
+}
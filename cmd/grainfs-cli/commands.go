@@ -0,0 +1,830 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NovaCove/grainfs"
+	grainwebdav "github.com/NovaCove/grainfs/webdav"
+	"golang.org/x/net/webdav"
+)
+
+// Exit codes of the subcommands
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// errUsage is returned by commands called with invalid arguments
+var errUsage = errors.New("invalid usage")
+
+// command is a non-interactive subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(c *CLI, args []string) error
+}
+
+// commands are the subcommands of grainfs-cli, by name
+var commands map[string]command
+
+func init() {
+	// Set up in init, as the shell runs some of the commands
+	commands = map[string]command{
+		"ls":      {"ls [path]", "List a directory", (*CLI).commandList},
+		"cat":     {"cat <file>...", "Write the content of files to stdout", (*CLI).commandCat},
+		"put":     {"put <local|-> <path>", "Store a local file, or stdin, at path", (*CLI).commandPut},
+		"get":     {"get <path> [local|-]", "Retrieve a file into a local file, or stdout", (*CLI).commandGet},
+		"rm":      {"rm [-r] <path>...", "Remove files, or directory trees with -r", (*CLI).commandRemove},
+		"mv":      {"mv <old> <new>", "Rename a file or directory", (*CLI).commandMove},
+		"mkdir":   {"mkdir <path>...", "Create directories and their parents", (*CLI).commandMkdir},
+		"stat":    {"stat <path>", "Show information about a file", (*CLI).commandStat},
+		"tree":    {"tree [path]", "Show a directory tree", (*CLI).commandTree},
+		"fsck":    {"fsck [--repair]", "Check filesystem consistency, optionally repairing it", (*CLI).commandCheck},
+		"rebuild": {"rebuild [path]", "Recover lost filename mappings from on-disk names", (*CLI).commandRebuild},
+		"import":  {"import <host> <path>", "Copy a host tree in; --exclude <glob>, -q", (*CLI).commandImport},
+		"export":  {"export <path> <host>", "Copy a tree out to the host; --exclude <glob>, -q", (*CLI).commandExport},
+		"shell":   {"shell", "Start the interactive shell", (*CLI).commandShell},
+		"init":    {"init [options]", "Create a volume; see --kdf, --iterations, --memory, --threads, --cipher, --layout", (*CLI).commandInit},
+		"info":    {"info", "Show the volume format, encryption and sizes", (*CLI).commandInfo},
+		"upgrade": {"upgrade", "Migrate a volume of an older format in place", (*CLI).commandUpgrade},
+		"serve":   {"serve [options]", "Serve the files over HTTP; see --addr, --write, --user, --max-upload, --max-memory", (*CLI).commandServe},
+		"webdav":  {"webdav [options]", "Serve the files over WebDAV; see --addr, --user, --read-only", (*CLI).commandWebDAV},
+		"sftp":    {"sftp [options]", "Serve the files over SFTP; see --addr, --authorized-keys, --host-key, --read-only", (*CLI).commandSFTP},
+		"mount":   {"mount [options] <mountpoint>", "Mount the files with FUSE (Linux only); see --read-only, --allow-other, --debug", (*CLI).commandMount},
+	}
+}
+
+// commandNames returns the names of the subcommands in order
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// flags returns the flag set of a subcommand, which also accepts --json
+func (c *CLI) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&c.json, "json", c.json, "write machine-readable JSON output")
+	return flags
+}
+
+// parseFlags parses the arguments of a subcommand, returning the remaining ones, and
+// checks that their number is between min and max; max < 0 means no limit
+func parseFlags(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	args = flags.Args()
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return nil, errUsage
+	}
+	return args, nil
+}
+
+// pathError reports the error err of op on the user path path, dropping the obfuscated
+// path of errors from the underlying filesystem
+func pathError(op, path string, err error) error {
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &pathErr):
+		err = pathErr.Err
+	case errors.As(err, &linkErr):
+		err = linkErr.Err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// writeJSON writes v to stdout as JSON
+func writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// fileJSON describes a file in JSON output
+type fileJSON struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path,omitempty"`
+	Size     int64      `json:"size"`
+	Mode     string     `json:"mode"`
+	ModTime  time.Time  `json:"modTime"`
+	IsDir    bool       `json:"isDir"`
+	Error    string     `json:"error,omitempty"`
+	Children []fileJSON `json:"children,omitempty"`
+}
+
+// newFileJSON describes the file at path
+func newFileJSON(path string, info os.FileInfo) fileJSON {
+	return fileJSON{
+		Name:    info.Name(),
+		Path:    path,
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+func (c *CLI) commandList(args []string) error {
+	args, err := parseFlags(c.flags("ls"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	entries, err := c.fs.ReadDirDetailed(path)
+	if err != nil {
+		return pathError("ls", path, err)
+	}
+
+	if c.json {
+		files := make([]fileJSON, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Err != nil {
+				files = append(files, fileJSON{Name: entry.Name, Error: entry.Err.Error()})
+				continue
+			}
+			files = append(files, newFileJSON(filepath.Join(path, entry.Name), entry.Info))
+		}
+		return writeJSON(files)
+	}
+
+	for _, entry := range entries {
+		if entry.Err != nil {
+			name := entry.Name
+			if name == "" {
+				name = "<unknown> (" + entry.ObfuscatedName + ")"
+			}
+			fmt.Fprintf(os.Stderr, "warning: %s: %v\n", name, entry.Err)
+			continue
+		}
+		typeStr := "file"
+		if entry.Info.IsDir() {
+			typeStr = "dir "
+		}
+		fmt.Printf("%s  %8d  %s\n", typeStr, entry.Info.Size(), entry.Info.Name())
+	}
+	return nil
+}
+
+func (c *CLI) commandCat(args []string) error {
+	args, err := parseFlags(c.flags("cat"), args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range args {
+		if err := c.copyOut(path, os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CLI) commandPut(args []string) error {
+	args, err := parseFlags(c.flags("put"), args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	var src io.Reader = os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		src = file
+	}
+
+	dst, err := c.fs.Create(args[1])
+	if err != nil {
+		return pathError("put", args[1], err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return pathError("put", args[1], err)
+	}
+	if err := dst.Close(); err != nil {
+		return pathError("put", args[1], err)
+	}
+	return nil
+}
+
+func (c *CLI) commandGet(args []string) error {
+	args, err := parseFlags(c.flags("get"), args, 1, 2)
+	if err != nil {
+		return err
+	}
+	local := filepath.Base(args[0])
+	if len(args) > 1 {
+		local = args[1]
+	}
+	if local == "-" {
+		return c.copyOut(args[0], os.Stdout)
+	}
+
+	// The content goes to a temporary file beside local, which only replaces it once the
+	// whole file has been decrypted, so a failed get never loses an existing file
+	src, err := c.fs.Open(args[0])
+	if err != nil {
+		return pathError("open", args[0], err)
+	}
+	defer src.Close()
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(local); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dst, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".get-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return pathError("read", args[0], err)
+	}
+	if err := dst.Chmod(mode); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := os.Rename(dst.Name(), local); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return nil
+}
+
+// copyOut writes the decrypted content of the file at path to w
+func (c *CLI) copyOut(path string, w io.Writer) error {
+	src, err := c.fs.Open(path)
+	if err != nil {
+		return pathError("open", path, err)
+	}
+	defer src.Close()
+
+	if _, err := io.Copy(w, src); err != nil {
+		return pathError("read", path, err)
+	}
+	return nil
+}
+
+func (c *CLI) commandRemove(args []string) error {
+	flags := c.flags("rm")
+	recursive := flags.Bool("r", false, "remove directories and their contents")
+	args, err := parseFlags(flags, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range args {
+		if *recursive {
+			err = c.fs.RemoveAll(path)
+		} else {
+			err = c.fs.Remove(path)
+		}
+		if err != nil {
+			return pathError("rm", path, err)
+		}
+	}
+	return nil
+}
+
+func (c *CLI) commandMove(args []string) error {
+	args, err := parseFlags(c.flags("mv"), args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.Rename(args[0], args[1]); err != nil {
+		return pathError("mv", args[0], err)
+	}
+	return nil
+}
+
+func (c *CLI) commandMkdir(args []string) error {
+	args, err := parseFlags(c.flags("mkdir"), args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range args {
+		if err := c.fs.MkdirAll(path, 0755); err != nil {
+			return pathError("mkdir", path, err)
+		}
+	}
+	return nil
+}
+
+func (c *CLI) commandStat(args []string) error {
+	args, err := parseFlags(c.flags("stat"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	info, err := c.fs.Stat(args[0])
+	if err != nil {
+		return pathError("stat", args[0], err)
+	}
+
+	if c.json {
+		return writeJSON(newFileJSON(args[0], info))
+	}
+	printFileInfo(args[0], info)
+	return nil
+}
+
+func (c *CLI) commandTree(args []string) error {
+	args, err := parseFlags(c.flags("tree"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	info, err := c.fs.Stat(path)
+	if err != nil {
+		return pathError("tree", path, err)
+	}
+	tree := newFileJSON(path, info)
+	if err := c.buildTree(&tree); err != nil {
+		return err
+	}
+
+	if c.json {
+		return writeJSON(tree)
+	}
+	fmt.Println(path)
+	printTree(tree.Children, "")
+	return nil
+}
+
+// buildTree fills in the children of the directory node, recursively
+func (c *CLI) buildTree(node *fileJSON) error {
+	if !node.IsDir {
+		return nil
+	}
+
+	infos, err := c.fs.ReadDir(node.Path)
+	if err != nil {
+		return pathError("tree", node.Path, err)
+	}
+	sortDirsFirst(infos)
+
+	for _, info := range infos {
+		child := newFileJSON(filepath.Join(node.Path, info.Name()), info)
+		if err := c.buildTree(&child); err != nil {
+			return err
+		}
+		node.Children = append(node.Children, child)
+	}
+	return nil
+}
+
+// printTree prints tree nodes below a line prefixed with prefix
+func printTree(nodes []fileJSON, prefix string) {
+	for i, node := range nodes {
+		connector, childPrefix := "├── ", prefix+"│   "
+		if i == len(nodes)-1 {
+			connector, childPrefix = "└── ", prefix+"    "
+		}
+
+		typeIndicator := ""
+		if node.IsDir {
+			typeIndicator = "/"
+		}
+		fmt.Printf("%s%s%s%s\n", prefix, connector, node.Name, typeIndicator)
+		printTree(node.Children, childPrefix)
+	}
+}
+
+// problemJSON describes a problem found by fsck in JSON output
+type problemJSON struct {
+	Kind           string `json:"kind"`
+	Path           string `json:"path,omitempty"`
+	ObfuscatedPath string `json:"obfuscatedPath"`
+	Error          string `json:"error"`
+	Repaired       bool   `json:"repaired"`
+	RepairedPath   string `json:"repairedPath,omitempty"`
+}
+
+func (c *CLI) commandCheck(args []string) error {
+	flags := c.flags("fsck")
+	repair := flags.Bool("repair", false, "repair the problems found")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	report, err := c.fs.Check(grainfs.CheckOptions{Repair: *repair})
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		problems := make([]problemJSON, 0, len(report.Problems))
+		for _, problem := range report.Problems {
+			problems = append(problems, problemJSON{
+				Kind:           problem.Kind.String(),
+				Path:           problem.Path,
+				ObfuscatedPath: problem.ObfuscatedPath,
+				Error:          problem.Err.Error(),
+				Repaired:       problem.Repaired,
+				RepairedPath:   problem.RepairedPath,
+			})
+		}
+		err = writeJSON(struct {
+			Directories int           `json:"directories"`
+			Files       int           `json:"files"`
+			OK          bool          `json:"ok"`
+			Problems    []problemJSON `json:"problems"`
+		}{report.Directories, report.Files, report.OK(), problems})
+		if err != nil {
+			return err
+		}
+	} else {
+		printCheckReport(report, *repair)
+	}
+
+	if !report.OK() {
+		return errors.New("filesystem has problems")
+	}
+	return nil
+}
+
+func (c *CLI) commandRebuild(args []string) error {
+	args, err := parseFlags(c.flags("rebuild"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	report, err := c.fs.RebuildFilemaps(path)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return writeJSON(struct {
+			Directories   int      `json:"directories"`
+			Recovered     []string `json:"recovered"`
+			Replaced      []string `json:"replaced"`
+			Unrecoverable []string `json:"unrecoverable"`
+		}{report.Directories, report.Recovered, report.Replaced, report.Unrecoverable})
+	}
+	printRebuildReport(report, path)
+	return nil
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// transferFlags parses the arguments of import and export, returning the source, the
+// destination and the options of the copy, which reports its progress on stderr unless
+// -q is given. The summary is filled in as the copy goes.
+func (c *CLI) transferFlags(name string, args []string, summary *transferSummary) (string, string, grainfs.TreeOptions, error) {
+	var exclude stringList
+	flags := c.flags(name)
+	flags.Var(&exclude, "exclude", "skip entries matching a glob; may be repeated")
+	quiet := flags.Bool("q", false, "do not report progress")
+	args, err := parseFlags(flags, args, 2, 2)
+	if err != nil {
+		return "", "", grainfs.TreeOptions{}, err
+	}
+
+	opts := grainfs.TreeOptions{
+		Exclude: exclude,
+		Progress: func(path string, info os.FileInfo) {
+			switch {
+			case info.IsDir():
+				summary.Directories++
+			case info.Mode().IsRegular():
+				summary.Files++
+				summary.Bytes += info.Size()
+			default:
+				summary.Links++
+			}
+			if !*quiet && !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "%sed %s (%d bytes)\n", name, path, info.Size())
+			}
+		},
+	}
+	return args[0], args[1], opts, nil
+}
+
+// transferSummary counts what import and export copied
+type transferSummary struct {
+	Files       int   `json:"files"`
+	Directories int   `json:"directories"`
+	Links       int   `json:"links"`
+	Bytes       int64 `json:"bytes"`
+}
+
+// reportTransfer prints the summary of a finished import or export
+func (c *CLI) reportTransfer(verb string, summary *transferSummary) error {
+	if c.json {
+		return writeJSON(summary)
+	}
+	fmt.Fprintf(os.Stderr, "%s %d files, %d directories and %d links (%d bytes)\n",
+		verb, summary.Files, summary.Directories, summary.Links, summary.Bytes)
+	return nil
+}
+
+func (c *CLI) commandImport(args []string) error {
+	var summary transferSummary
+	src, dst, opts, err := c.transferFlags("import", args, &summary)
+	if err != nil {
+		return err
+	}
+
+	host, name, err := hostPath(src)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.ImportTree(host, name, c.resolvePath(dst), opts); err != nil {
+		return err
+	}
+	return c.reportTransfer("Imported", &summary)
+}
+
+func (c *CLI) commandExport(args []string) error {
+	var summary transferSummary
+	src, dst, opts, err := c.transferFlags("export", args, &summary)
+	if err != nil {
+		return err
+	}
+
+	host, name, err := hostPath(dst)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.ExportTree(c.resolvePath(src), host, name, opts); err != nil {
+		return err
+	}
+	return c.reportTransfer("Exported", &summary)
+}
+
+func (c *CLI) commandShell(args []string) error {
+	if _, err := parseFlags(c.flags("shell"), args, 0, 0); err != nil {
+		return err
+	}
+
+	fmt.Printf("GrainFS CLI - Connected to: %s\n", c.rootPath)
+	fmt.Println("Type 'help' for available commands")
+	c.run()
+	return nil
+}
+
+func (c *CLI) commandInit(args []string) error {
+	var opts grainfs.VolumeOptions
+	var threads uint
+	flags := c.flags("init")
+	flags.StringVar(&opts.KDF, "kdf", grainfs.KDFPBKDF2, "key derivation function: "+grainfs.KDFPBKDF2+" or "+grainfs.KDFArgon2id)
+	flags.IntVar(&opts.Iterations, "iterations", 0, "PBKDF2 iterations or argon2id passes (default depends on --kdf)")
+	memory := flags.Uint("memory", grainfs.DefaultArgon2Memory, "argon2id memory in KiB")
+	flags.UintVar(&threads, "threads", grainfs.DefaultArgon2Threads, "argon2id threads")
+	flags.StringVar(&opts.Cipher, "cipher", grainfs.CipherAES256GCM, "content cipher: "+grainfs.CipherAES256GCM+" or "+grainfs.CipherChaCha20Poly1305)
+	flags.StringVar(&opts.Layout, "layout", grainfs.LayoutNested, "storage layout: "+grainfs.LayoutNested)
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+	if *memory > math.MaxUint32 || threads == 0 || threads > math.MaxUint8 {
+		return fmt.Errorf("%w: invalid --memory or --threads", errUsage)
+	}
+	if opts.KDF == grainfs.KDFArgon2id {
+		opts.Memory, opts.Threads = uint32(*memory), uint8(threads)
+	}
+
+	fs, err := grainfs.Init(c.underlying, c.password, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create volume: %w", err)
+	}
+	c.fs = fs
+	if !c.json {
+		fmt.Printf("Created GrainFS volume at %s\n", c.rootPath)
+	}
+	return c.commandInfo(nil)
+}
+
+func (c *CLI) commandInfo(args []string) error {
+	if _, err := parseFlags(c.flags("info"), args, 0, 0); err != nil {
+		return err
+	}
+
+	info, err := c.fs.Info()
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return writeJSON(struct {
+			Version        string `json:"version"`
+			KDF            string `json:"kdf"`
+			Iterations     int    `json:"iterations"`
+			Memory         uint32 `json:"memory,omitempty"`
+			Threads        uint8  `json:"threads,omitempty"`
+			Cipher         string `json:"cipher"`
+			Layout         string `json:"layout"`
+			NeedsUpgrade   bool   `json:"needsUpgrade"`
+			Directories    int    `json:"directories"`
+			Files          int    `json:"files"`
+			Symlinks       int    `json:"symlinks"`
+			PlaintextSize  int64  `json:"plaintextSize"`
+			CiphertextSize int64  `json:"ciphertextSize"`
+		}{info.Version, info.KDF, info.Iterations, info.Memory, info.Threads, info.Cipher, info.Layout, info.NeedsUpgrade,
+			info.Directories, info.Files, info.Symlinks, info.PlaintextSize, info.CiphertextSize})
+	}
+
+	fmt.Printf("Volume:       %s\n", c.rootPath)
+	fmt.Printf("Version:      %s\n", info.Version)
+	if info.KDF == grainfs.KDFArgon2id {
+		fmt.Printf("KDF:          %s (%d passes, %d KiB, %d threads)\n", info.KDF, info.Iterations, info.Memory, info.Threads)
+	} else {
+		fmt.Printf("KDF:          %s (%d iterations)\n", info.KDF, info.Iterations)
+	}
+	fmt.Printf("Cipher:       %s\n", info.Cipher)
+	fmt.Printf("Layout:       %s\n", info.Layout)
+	fmt.Printf("Directories:  %d\n", info.Directories)
+	fmt.Printf("Files:        %d\n", info.Files)
+	fmt.Printf("Symlinks:     %d\n", info.Symlinks)
+	fmt.Printf("Plaintext:    %d bytes\n", info.PlaintextSize)
+	fmt.Printf("Ciphertext:   %d bytes, including GrainFS state\n", info.CiphertextSize)
+	if info.NeedsUpgrade {
+		fmt.Printf("\nThe volume uses an older format; migrate it with: grainfs-cli %s upgrade\n", c.rootPath)
+	}
+	return nil
+}
+
+func (c *CLI) commandUpgrade(args []string) error {
+	if _, err := parseFlags(c.flags("upgrade"), args, 0, 0); err != nil {
+		return err
+	}
+
+	report, err := c.fs.Upgrade()
+	if err != nil {
+		return fmt.Errorf("upgrade failed, run it again to resume: %w", err)
+	}
+
+	if c.json {
+		return writeJSON(struct {
+			FromVersion string   `json:"fromVersion"`
+			ToVersion   string   `json:"toVersion"`
+			Directories int      `json:"directories"`
+			StateFiles  int      `json:"stateFiles"`
+			Symlinks    int      `json:"symlinks"`
+			Unconverted []string `json:"unconverted"`
+		}{report.FromVersion, report.ToVersion, report.Directories, report.StateFiles, report.Symlinks, report.Unconverted})
+	}
+
+	if report.FromVersion == report.ToVersion {
+		fmt.Printf("Volume is already at version %s\n", report.ToVersion)
+		return nil
+	}
+	fmt.Printf("Upgraded from version %s to %s\n", report.FromVersion, report.ToVersion)
+	fmt.Printf("  Directories visited:      %d\n", report.Directories)
+	fmt.Printf("  State files rewritten:    %d\n", report.StateFiles)
+	fmt.Printf("  Symbolic links converted: %d\n", report.Symlinks)
+	for _, path := range report.Unconverted {
+		fmt.Printf("  !  %s: target could not be resolved, left unchanged\n", path)
+	}
+	return nil
+}
+
+// HTTPPasswordEnv is the environment variable holding the password of serve --user, so
+// that it does not show up in process listings
+const HTTPPasswordEnv = "GRAINFS_HTTP_PASSWORD"
+
+func (c *CLI) commandServe(args []string) error {
+	var opts grainfs.HTTPOptions
+	flags := c.flags("serve")
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	flags.BoolVar(&opts.AllowWrite, "write", false, "accept PUT uploads and DELETE")
+	flags.StringVar(&opts.Username, "user", "", "require basic authentication as this user, with the password in $"+HTTPPasswordEnv)
+	flags.Int64Var(&opts.MaxUploadSize, "max-upload", 0, "maximum upload size in bytes, 0 for no limit")
+	flags.Int64Var(&opts.MaxDownloadMemory, "max-memory", grainfs.DefaultMaxDownloadMemory, "memory in bytes held by downloads, which decrypt whole files; -1 for no limit")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+	password, err := httpPassword(opts.Username)
+	if err != nil {
+		return err
+	}
+	opts.Password = password
+	opts.OnError = func(r *http.Request, err error) {
+		fmt.Fprintf(os.Stderr, "grainfs-cli: %s %s: %v\n", r.Method, r.URL.Path, err)
+	}
+
+	mode := ""
+	if opts.AllowWrite {
+		mode = " with uploads"
+	}
+	return c.listenAndServe(*addr, c.fs.HTTPHandler(opts), mode)
+}
+
+func (c *CLI) commandWebDAV(args []string) error {
+	flags := c.flags("webdav")
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	username := flags.String("user", "", "require basic authentication as this user, with the password in $"+HTTPPasswordEnv)
+	readOnly := flags.Bool("read-only", false, "refuse all changes")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+	password, err := httpPassword(*username)
+	if err != nil {
+		return err
+	}
+
+	fs, mode := c.fs, ""
+	if *readOnly {
+		fs, mode = fs.ReadOnly(), " read-only"
+	}
+	var handler http.Handler = &webdav.Handler{
+		FileSystem: grainwebdav.New(fs),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "grainfs-cli: %s %s: %v\n", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	if *username != "" {
+		handler = grainfs.BasicAuth(handler, *username, password)
+	}
+	return c.listenAndServe(*addr, handler, mode)
+}
+
+// httpPassword returns the password of the basic authentication user username from the
+// environment, or nothing if there is no user
+func httpPassword(username string) (string, error) {
+	if username == "" {
+		return "", nil
+	}
+	password := os.Getenv(HTTPPasswordEnv)
+	if password == "" {
+		return "", fmt.Errorf("--user needs a password in $%s", HTTPPasswordEnv)
+	}
+	return password, nil
+}
+
+// listenAndServe serves handler on addr until interrupted, letting the requests in
+// progress finish so that no upload is cut short
+func (c *CLI) listenAndServe(addr string, handler http.Handler, mode string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "Serving %s on http://%s/%s; press Ctrl-C to stop\n", c.rootPath, addr, mode)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/NovaCove/grainfs"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		min, max int
+		rest     []string
+		usage    bool
+	}{
+		{args: nil, min: 0, max: 0},
+		{args: []string{"a"}, min: 0, max: 0, usage: true},
+		{args: []string{"a"}, min: 1, max: 1, rest: []string{"a"}},
+		{args: []string{"a", "b"}, min: 1, max: 1, usage: true},
+		{args: nil, min: 1, max: -1, usage: true},
+		{args: []string{"a", "b", "c"}, min: 1, max: -1, rest: []string{"a", "b", "c"}},
+		{args: []string{"-q", "a"}, min: 1, max: 1, rest: []string{"a"}},
+		{args: []string{"-bogus", "a"}, min: 1, max: 1, usage: true},
+	}
+
+	for _, test := range tests {
+		flags := (&CLI{}).flags("test")
+		flags.Bool("q", false, "")
+		rest, err := parseFlags(flags, test.args, test.min, test.max)
+		if test.usage {
+			if !errors.Is(err, errUsage) {
+				t.Fatalf("Expected %v between %d and %d to be a usage error, got %v", test.args, test.min, test.max, err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(rest, " ") != strings.Join(test.rest, " ") {
+			t.Fatalf("Expected %v between %d and %d to leave %v, got %v: %v", test.args, test.min, test.max, test.rest, rest, err)
+		}
+	}
+}
+
+// runJSON runs grainfs-cli with args, which must succeed, and decodes its output into v
+func runJSON(t *testing.T, v interface{}, args ...string) {
+	t.Helper()
+	code, stdout, stderr := runCLI(t, "", args...)
+	if code != exitOK {
+		t.Fatalf("%v failed with %d: %s", args, code, stderr)
+	}
+	if err := json.Unmarshal([]byte(stdout), v); err != nil {
+		t.Fatalf("Expected JSON from %v, got %q: %v", args, stdout, err)
+	}
+}
+
+// keys returns the sorted keys of a JSON object
+func keys(object map[string]interface{}) []string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCommandsJSON(t *testing.T) {
+	volume := newTestVolume(t)
+	if code, _, stderr := runCLI(t, "hello, world", volume, "put", "-", "docs/hello.txt"); code != exitOK {
+		t.Fatalf("put failed with %d: %s", code, stderr)
+	}
+
+	// --json is accepted before the volume and after the command
+	var files []map[string]interface{}
+	runJSON(t, &files, "--json", volume, "ls", "docs")
+	if len(files) != 1 || !reflect.DeepEqual(keys(files[0]), []string{"isDir", "modTime", "mode", "name", "path", "size"}) ||
+		files[0]["name"] != "hello.txt" || files[0]["path"] != filepath.Join("docs", "hello.txt") || files[0]["size"] != 12.0 {
+		t.Fatalf("Unexpected ls output %v", files)
+	}
+
+	var file map[string]interface{}
+	runJSON(t, &file, volume, "stat", "--json", "docs")
+	if file["name"] != "docs" || file["isDir"] != true {
+		t.Fatalf("Unexpected stat output %v", file)
+	}
+
+	var tree struct {
+		Name     string
+		Children []struct {
+			Name     string
+			IsDir    bool
+			Children []struct{ Name string }
+		}
+	}
+	runJSON(t, &tree, "--json", volume, "tree")
+	if tree.Name != "." || len(tree.Children) != 1 || !tree.Children[0].IsDir ||
+		len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].Name != "hello.txt" {
+		t.Fatalf("Unexpected tree output %+v", tree)
+	}
+
+	// Empty lists are arrays rather than null, for consumers iterating over them
+	var report map[string]interface{}
+	runJSON(t, &report, "--json", volume, "fsck")
+	if !reflect.DeepEqual(keys(report), []string{"directories", "files", "ok", "problems"}) || report["ok"] != true ||
+		report["files"] != 1.0 || !reflect.DeepEqual(report["problems"], []interface{}{}) {
+		t.Fatalf("Unexpected fsck output %v", report)
+	}
+
+	var info map[string]interface{}
+	runJSON(t, &info, "--json", volume, "info")
+	if info["kdf"] != grainfs.KDFPBKDF2 || info["iterations"] != 1000.0 || info["files"] != 1.0 || info["plaintextSize"] != 12.0 || info["needsUpgrade"] != false {
+		t.Fatalf("Unexpected info output %v", info)
+	}
+
+	host := t.TempDir()
+	var summary map[string]interface{}
+	runJSON(t, &summary, "--json", volume, "export", "-q", "docs", filepath.Join(host, "out"))
+	if !reflect.DeepEqual(summary, map[string]interface{}{"files": 1.0, "directories": 1.0, "links": 0.0, "bytes": 12.0}) {
+		t.Fatalf("Unexpected export output %v", summary)
+	}
+	if content, err := os.ReadFile(filepath.Join(host, "out", "hello.txt")); err != nil || string(content) != "hello, world" {
+		t.Fatalf("Expected the exported content, got %q: %v", content, err)
+	}
+	runJSON(t, &summary, "--json", volume, "import", "-q", filepath.Join(host, "out"), "copy")
+	if summary["files"] != 1.0 || summary["bytes"] != 12.0 {
+		t.Fatalf("Unexpected import output %v", summary)
+	}
+
+	// Failures exit with an error and write no JSON
+	code, stdout, _ := runCLI(t, "", "--json", volume, "stat", "missing")
+	if code != exitFailure || stdout != "" {
+		t.Fatalf("Expected stat of a missing file to fail without output, got %d %q", code, stdout)
+	}
+}
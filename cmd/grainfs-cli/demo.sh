@@ -16,7 +16,7 @@ echo "1. Creating some test data..."
 echo "Creating directories and files through the CLI..."
 
 # Create test data using the CLI
-./grainfs-cli "$DEMO_DIR" "demo-password" << 'EOF'
+GRAINFS_PASSWORD="demo-password" ./grainfs-cli "$DEMO_DIR" << 'EOF'
 write README.md # GrainFS Demo
 
 This is a demonstration of the encrypted filesystem.
@@ -39,7 +39,7 @@ echo "2. Now let's explore the encrypted filesystem..."
 echo
 
 # Interactive exploration
-./grainfs-cli "$DEMO_DIR" "demo-password" << 'EOF'
+GRAINFS_PASSWORD="demo-password" ./grainfs-cli "$DEMO_DIR" << 'EOF'
 echo "=== Root Directory ==="
 ls
 echo
@@ -4,13 +4,26 @@ go 1.24.3
 
 require (
 	github.com/NovaCove/grainfs v0.0.0
+	github.com/NovaCove/grainfs/fuse v0.0.0
+	github.com/NovaCove/grainfs/sftp v0.0.0
+	github.com/NovaCove/grainfs/webdav v0.0.0
 	github.com/go-git/go-billy/v5 v5.6.2
+	github.com/hanwen/go-fuse/v2 v2.9.0
+	github.com/pkg/sftp v1.13.9
+	golang.org/x/crypto v0.32.0
+	golang.org/x/net v0.34.0
+	golang.org/x/term v0.33.0
 )
 
 require (
-	github.com/cyphar/filepath-securejoin v0.3.6 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
+	github.com/cyphar/filepath-securejoin v0.4.1 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
 )
 
-replace github.com/NovaCove/grainfs => ../..
+replace (
+	github.com/NovaCove/grainfs => ../..
+	github.com/NovaCove/grainfs/fuse => ../../fuse
+	github.com/NovaCove/grainfs/sftp => ../../sftp
+	github.com/NovaCove/grainfs/webdav => ../../webdav
+)
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// HistoryFile is the file of the underlying storage holding the encrypted shell history
+	HistoryFile = "history"
+
+	// historySize is the number of shell lines kept
+	historySize = 1000
+)
+
+// history is the persistent shell history. It is stored with the volume, encrypted with
+// a key derived from its password, so that commands naming files do not leak them.
+type history struct {
+	underlying billy.Filesystem
+	key        []byte
+	salt       []byte
+
+	// lines are the history entries, oldest first
+	lines []string
+}
+
+// loadHistory loads the shell history of the volume at underlying. A missing history is
+// empty; an unreadable one is reported, and replaced on the next save.
+func loadHistory(underlying billy.Filesystem, password string) (*history, error) {
+	h := &history{underlying: underlying}
+
+	data, err := util.ReadFile(underlying, historyPath())
+	if err != nil && !os.IsNotExist(err) {
+		return h, h.reset(password, fmt.Errorf("failed to read history: %w", err))
+	}
+	if err != nil || len(data) < grainfs.SaltSize {
+		return h, h.reset(password, nil)
+	}
+
+	h.salt = data[:grainfs.SaltSize]
+	h.key = historyKey(password, h.salt)
+	reader, err := grainfs.NewDecryptingReader(bytes.NewReader(data[grainfs.SaltSize:]), h.key)
+	if err != nil {
+		return h, h.reset(password, fmt.Errorf("failed to decrypt history: %w", err))
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return h, h.reset(password, fmt.Errorf("failed to decrypt history: %w", err))
+	}
+
+	if len(plaintext) > 0 {
+		h.lines = strings.Split(strings.TrimSuffix(string(plaintext), "\n"), "\n")
+	}
+	return h, nil
+}
+
+// reset gives the history a new salt and key, returning err
+func (h *history) reset(password string, err error) error {
+	h.salt = make([]byte, grainfs.SaltSize)
+	if _, randErr := rand.Read(h.salt); randErr != nil {
+		return fmt.Errorf("failed to generate salt: %w", randErr)
+	}
+	h.key = historyKey(password, h.salt)
+	h.lines = nil
+	return err
+}
+
+// historyKey derives the key of the history from the password of the volume
+func historyKey(password string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(password), salt, grainfs.DefaultIterations, grainfs.KeySize, sha256.New)
+}
+
+// historyPath returns the path of the history on the underlying storage
+func historyPath() string {
+	return filepath.Join(grainfs.GrainFSDir, HistoryFile)
+}
+
+// Add records a line, skipping blank ones and repeats of the last
+func (h *history) Add(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+	if len(h.lines) > 0 && h.lines[len(h.lines)-1] == entry {
+		return
+	}
+
+	h.lines = append(h.lines, entry)
+	if len(h.lines) > historySize {
+		h.lines = h.lines[len(h.lines)-historySize:]
+	}
+}
+
+// Len returns the number of entries
+func (h *history) Len() int {
+	return len(h.lines)
+}
+
+// At returns the entry idx, with 0 the most recent
+func (h *history) At(idx int) string {
+	return h.lines[len(h.lines)-1-idx]
+}
+
+// save writes the history to the underlying storage, replacing it atomically
+func (h *history) save() error {
+	var buf bytes.Buffer
+	buf.Write(h.salt)
+	writer, err := grainfs.NewEncryptingWriter(&buf, h.key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt history: %w", err)
+	}
+	for _, line := range h.lines {
+		fmt.Fprintln(writer, line)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt history: %w", err)
+	}
+
+	tmp := historyPath() + ".tmp"
+	file, err := h.underlying.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if err := h.underlying.Rename(tmp, historyPath()); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestHistory(t *testing.T) {
+	underlying := memfs.New()
+
+	h, err := loadHistory(underlying, testPassword)
+	if err != nil || h.Len() != 0 {
+		t.Fatalf("Expected a missing history to be empty, got %d entries: %v", h.Len(), err)
+	}
+	// Blank lines and repeats of the last one are skipped
+	for _, line := range []string{"ls", "  ", "cat secret-plans.txt", "cat secret-plans.txt", "ls"} {
+		h.Add(line)
+	}
+	if h.Len() != 3 || h.At(0) != "ls" || h.At(1) != "cat secret-plans.txt" || h.At(2) != "ls" {
+		t.Fatalf("Unexpected history %q", h.lines)
+	}
+	if err := h.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	// The history is encrypted with the password of the volume
+	data, err := util.ReadFile(underlying, historyPath())
+	if err != nil {
+		t.Fatalf("Failed to read the history: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-plans")) {
+		t.Fatalf("Expected the history to be encrypted")
+	}
+	h, err = loadHistory(underlying, testPassword)
+	if err != nil || h.Len() != 3 || h.At(1) != "cat secret-plans.txt" {
+		t.Fatalf("Expected the saved history, got %q: %v", h.lines, err)
+	}
+	h, err = loadHistory(underlying, "wrong-password")
+	if err == nil || h.Len() != 0 {
+		t.Fatalf("Expected a history of another password to be reported and dropped, got %q: %v", h.lines, err)
+	}
+
+	// Only the last entries are kept
+	for i := 0; i < historySize+10; i++ {
+		h.Add(fmt.Sprintf("cat %d", i))
+	}
+	if h.Len() != historySize || h.At(0) != fmt.Sprintf("cat %d", historySize+9) {
+		t.Fatalf("Expected the last %d entries, got %d ending with %q", historySize, h.Len(), h.At(0))
+	}
+}
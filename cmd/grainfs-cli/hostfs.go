@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// hostFS is the host filesystem below a directory. Unlike osfs, it supports
+// billy.Change, so that exports keep modes and modification times.
+type hostFS struct {
+	billy.Filesystem
+	root string
+}
+
+// newHostFS returns the host filesystem below root
+func newHostFS(root string) *hostFS {
+	return &hostFS{Filesystem: osfs.New(root), root: root}
+}
+
+// hostPath splits a path of the host into a filesystem of its parent directory and its
+// name in it
+func hostPath(path string) (*hostFS, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return newHostFS(filepath.Dir(abs)), filepath.Base(abs), nil
+}
+
+func (h *hostFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(filepath.Join(h.root, name), mode)
+}
+
+func (h *hostFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(filepath.Join(h.root, name), uid, gid)
+}
+
+func (h *hostFS) Chown(name string, uid, gid int) error {
+	return os.Chown(filepath.Join(h.root, name), uid, gid)
+}
+
+func (h *hostFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(filepath.Join(h.root, name), atime, mtime)
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -20,41 +21,84 @@ type CLI struct {
 	currentPath string
 	password    string
 	rootPath    string
+	json        bool
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: grainfs-cli <storage-path> [password]")
-		fmt.Println("  storage-path: Path to the encrypted filesystem storage")
-		fmt.Println("  password:     Password for decryption (will prompt if not provided)")
-		os.Exit(1)
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs grainfs-cli with the command line arguments args, returning the exit code
+func run(args []string) int {
+	var passwordOpts passwordOptions
+	var jsonOutput bool
+
+	flags := flag.NewFlagSet("grainfs-cli", flag.ContinueOnError)
+	flags.StringVar(&passwordOpts.file, "password-file", "", "read the password from the first line of a file")
+	flags.StringVar(&passwordOpts.keyfile, "keyfile", "", "use the whole content of a file as the password")
+	flags.StringVar(&passwordOpts.command, "password-command", "", "read the password from the first line output by a shell command")
+	flags.BoolVar(&passwordOpts.stdin, "password-stdin", false, "read the password from the first line of stdin")
+	flags.BoolVar(&jsonOutput, "json", false, "write machine-readable JSON output")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
 	}
 
-	storagePath := os.Args[1]
-	var password string
+	args = flags.Args()
+	if len(args) < 1 {
+		usage(flags)
+		return exitUsage
+	}
+	storagePath := args[0]
 
-	if len(os.Args) >= 3 {
-		password = os.Args[2]
-	} else {
-		fmt.Print("Enter password: ")
-		scanner := bufio.NewScanner(os.Stdin)
-		if scanner.Scan() {
-			password = scanner.Text()
-		}
-		if password == "" {
-			fmt.Println("Password cannot be empty")
-			os.Exit(1)
+	// Without a command, start the shell
+	name, commandArgs := "shell", args[1:]
+	if len(args) > 1 {
+		if _, known := commands[args[1]]; !known {
+			fmt.Fprintf(os.Stderr, "grainfs-cli: unknown command %q\n", args[1])
+			usage(flags)
+			return exitUsage
 		}
+		name, commandArgs = args[1], args[2:]
+	}
+	cmd := commands[name]
+
+	if passwordOpts.stdin && name == "put" && len(commandArgs) > 0 && commandArgs[0] == "-" {
+		fmt.Fprintln(os.Stderr, "grainfs-cli: cannot read both the password and the file from stdin")
+		return exitUsage
+	}
+	// Volumes are only created by init, so that a mistyped path does not create one
+	_, err := os.Stat(filepath.Join(storagePath, grainfs.GrainFSDir, grainfs.ConfigFile))
+	switch {
+	case name == "init" && err == nil:
+		fmt.Fprintf(os.Stderr, "grainfs-cli: %s already holds a GrainFS volume\n", storagePath)
+		return exitFailure
+	case name == "init":
+		// Creating a volume with a mistyped password would lock its data away
+		passwordOpts.confirm = true
+	case os.IsNotExist(err):
+		fmt.Fprintf(os.Stderr, "grainfs-cli: no GrainFS volume at %s; create one with: grainfs-cli %s init\n", storagePath, storagePath)
+		return exitFailure
+	}
+	password, err := readPassword(passwordOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grainfs-cli: %v\n", err)
+		return exitFailure
 	}
 
 	// Create underlying filesystem
 	underlying := osfs.New(storagePath)
 
-	// Create GrainFS
-	fs, err := grainfs.New(underlying, password)
-	if err != nil {
-		fmt.Printf("Failed to initialize GrainFS: %v\n", err)
-		os.Exit(1)
+	// Open GrainFS, unless init creates it
+	var fs *grainfs.GrainFS
+	if name != "init" {
+		if fs, err = grainfs.New(underlying, password); err != nil {
+			fmt.Fprintf(os.Stderr, "grainfs-cli: failed to initialize GrainFS: %v\n", err)
+			return exitFailure
+		}
 	}
 
 	cli := &CLI{
@@ -63,30 +107,58 @@ func main() {
 		currentPath: ".",
 		password:    password,
 		rootPath:    storagePath,
+		json:        jsonOutput,
 	}
 
-	fmt.Printf("GrainFS CLI - Connected to: %s\n", storagePath)
-	fmt.Println("Type 'help' for available commands")
+	if err := cmd.run(cli, commandArgs); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "Usage: grainfs-cli <storage-path> %s\n", cmd.usage)
+			return exitUsage
+		}
+		fmt.Fprintf(os.Stderr, "grainfs-cli: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
 
-	cli.run()
+// usage prints the command line usage on stderr
+func usage(flags *flag.FlagSet) {
+	out := os.Stderr
+	fmt.Fprintln(out, "Usage: grainfs-cli [flags] <storage-path> [command] [args...]")
+	fmt.Fprintln(out, "  storage-path: Path to the encrypted filesystem storage")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Commands:")
+	for _, name := range commandNames() {
+		cmd := commands[name]
+		fmt.Fprintf(out, "  %-22s %s\n", cmd.usage, cmd.help)
+	}
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Without a command, the interactive shell is started.")
+	fmt.Fprintln(out, "The password is read from --password-file, --keyfile, --password-command, --password-stdin")
+	fmt.Fprintf(out, "or $%s, or else prompted for without echo, twice for a new volume.\n", PasswordEnv)
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Flags:")
+	flags.SetOutput(out)
+	flags.PrintDefaults()
 }
 
 func (c *CLI) run() {
-	scanner := bufio.NewScanner(os.Stdin)
+	input := c.newShellInput()
 
 	for {
-		fmt.Printf("grainfs:%s> ", c.currentPath)
-
-		if !scanner.Scan() {
+		line, err := input.readLine(fmt.Sprintf("grainfs:%s> ", c.currentPath))
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Error reading input: %v\n", err)
+			}
 			break
 		}
 
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
+		parts, err := splitLine(line)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			continue
 		}
-
-		parts := strings.Fields(line)
 		if len(parts) == 0 {
 			continue
 		}
@@ -111,6 +183,20 @@ func (c *CLI) run() {
 			c.makeDirectory(args)
 		case "rm", "remove":
 			c.removeFile(args)
+		case "rmdir":
+			c.removeDirectory(args)
+		case "mv", "move":
+			c.moveFile(args)
+		case "cp", "copy":
+			c.copyFile(args)
+		case "find":
+			c.findFiles(args)
+		case "du":
+			c.diskUsage(args)
+		case "head":
+			c.headFile(args)
+		case "edit":
+			c.editFile(args)
 		case "stat":
 			c.statFile(args)
 		case "debug":
@@ -121,6 +207,16 @@ func (c *CLI) run() {
 			c.showFilemap(args)
 		case "tree":
 			c.showTree(args)
+		case "fsck":
+			c.checkFilesystem(args)
+		case "info":
+			c.runShellCommand("info", args)
+		case "rebuild", "rebuild-filemaps":
+			c.rebuildFilemaps(args)
+		case "import":
+			c.runShellCommand("import", args)
+		case "export":
+			c.runShellCommand("export", args)
 		case "exit", "quit", "q":
 			fmt.Println("Goodbye!")
 			return
@@ -141,12 +237,27 @@ func (c *CLI) showHelp() {
 	fmt.Println("  write <file> <text>  - Write text to file")
 	fmt.Println("  mkdir <path>         - Create directory")
 	fmt.Println("  rm, remove <file>    - Remove file")
+	fmt.Println("  rmdir [-r] <dir>     - Remove an empty directory, or a whole tree with -r")
+	fmt.Println("  mv <old> <new>       - Move or rename a file or directory")
+	fmt.Println("  cp [-r] <src> <dst>  - Copy a file, or a directory tree with -r")
+	fmt.Println("  find [path] [opts]   - List paths, filtered by -name <glob> and -type f|d")
+	fmt.Println("  du [-s] [path]       - Show plaintext sizes of directories, or only the total")
+	fmt.Println("  head [-n N] <file>   - Show the first lines of a file")
+	fmt.Println("  edit <file>          - Edit a file with $EDITOR, encrypting it back on save")
 	fmt.Println("  stat <file>          - Show file information")
 	fmt.Println("  debug [path]         - Show debug information")
 	fmt.Println("  raw [path]           - Show raw encrypted filesystem contents")
 	fmt.Println("  filemap [path]       - Show filename mappings")
 	fmt.Println("  tree [path]          - Show directory tree")
+	fmt.Println("  fsck [--repair]      - Check filesystem consistency, optionally repairing it")
+	fmt.Println("  info                 - Show the volume format, encryption and sizes")
+	fmt.Println("  rebuild [path]       - Recover lost filename mappings from on-disk names")
+	fmt.Println("  import <host> <path> - Copy a host file or tree in (--exclude <glob>, -q)")
+	fmt.Println("  export <path> <host> - Copy a file or tree out to the host (--exclude <glob>, -q)")
 	fmt.Println("  exit, quit, q        - Exit the CLI")
+	fmt.Println("")
+	fmt.Println("Arguments can be quoted with '...' or \"...\", or escaped with a backslash.")
+	fmt.Println("On a terminal, Tab completes commands and paths and the history is kept encrypted.")
 }
 
 func (c *CLI) listFiles(args []string) {
@@ -155,29 +266,42 @@ func (c *CLI) listFiles(args []string) {
 		path = c.resolvePath(args[0])
 	}
 
-	infos, err := c.fs.ReadDir(path)
+	entries, err := c.fs.ReadDirDetailed(path)
 	if err != nil {
 		fmt.Printf("Error listing directory: %v\n", err)
 		return
 	}
 
-	if len(infos) == 0 {
+	if len(entries) == 0 {
 		fmt.Println("Directory is empty")
 		return
 	}
 
-	// Sort by name
-	sort.Slice(infos, func(i, j int) bool {
-		return infos[i].Name() < infos[j].Name()
-	})
-
+	// Entries come sorted by name
 	fmt.Printf("Contents of %s:\n", path)
-	for _, info := range infos {
+	var problems []grainfs.DirEntryDetail
+	for _, entry := range entries {
+		if entry.Err != nil {
+			problems = append(problems, entry)
+			continue
+		}
 		typeStr := "file"
-		if info.IsDir() {
+		if entry.Info.IsDir() {
 			typeStr = "dir "
 		}
-		fmt.Printf("  %s  %8d  %s\n", typeStr, info.Size(), info.Name())
+		fmt.Printf("  %s  %8d  %s\n", typeStr, entry.Info.Size(), entry.Info.Name())
+	}
+
+	// Warn about data that cannot be seen through the filesystem
+	if len(problems) > 0 {
+		fmt.Printf("Warning: %d entries cannot be read:\n", len(problems))
+		for _, entry := range problems {
+			name := entry.Name
+			if name == "" {
+				name = "<unknown> (" + entry.ObfuscatedName + ")"
+			}
+			fmt.Printf("  !  %s: %v\n", name, entry.Err)
+		}
 	}
 }
 
@@ -306,7 +430,12 @@ func (c *CLI) statFile(args []string) {
 		return
 	}
 
-	fmt.Printf("File: %s\n", filename)
+	printFileInfo(filename, info)
+}
+
+// printFileInfo prints information about the file at path
+func printFileInfo(path string, info os.FileInfo) {
+	fmt.Printf("File: %s\n", path)
 	fmt.Printf("  Name: %s\n", info.Name())
 	fmt.Printf("  Size: %d bytes\n", info.Size())
 	fmt.Printf("  Mode: %s\n", info.Mode())
@@ -324,40 +453,107 @@ func (c *CLI) debugInfo(args []string) {
 	fmt.Printf("Storage root: %s\n", c.rootPath)
 	fmt.Printf("Current path: %s\n", c.currentPath)
 
-	// Try to get obfuscated path using reflection or internal access
-	// Since we can't access private methods directly, we'll show what we can
-	fmt.Printf("\nEncrypted filesystem structure:\n")
-	c.showRawFiles([]string{})
+	obfuscatedPath, err := c.fs.ObfuscatedPath(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Obfuscated path: %s\n", obfuscatedPath)
+
+	info, err := c.fs.Lstat(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	rawInfo, err := c.underlying.Lstat(obfuscatedPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, _ := c.fs.Readlink(path)
+		fmt.Printf("Type: symlink -> %s\n", target)
+	case info.IsDir():
+		fmt.Printf("Type: directory\n")
+	default:
+		fmt.Printf("Type: file\n")
+		fmt.Printf("Size: %d bytes plaintext, %d bytes on disk\n", info.Size(), rawInfo.Size())
+	}
+	fmt.Printf("Mode: %s\n", info.Mode())
+
+	if info.IsDir() {
+		filemap, err := c.fs.Filemap(path)
+		if err != nil {
+			fmt.Printf("Error reading filemap: %v\n", err)
+			return
+		}
+		fmt.Printf("Filemap entries: %d\n", len(filemap))
+
+		fmt.Printf("\nEncrypted directory contents:\n")
+		c.showRawDirectory(obfuscatedPath, path, 0)
+	}
 }
 
 func (c *CLI) showRawFiles(args []string) {
-	path := "."
+	path := c.currentPath
 	if len(args) > 0 {
-		path = args[0]
+		path = c.resolvePath(args[0])
+	}
+
+	obfuscatedPath, err := c.fs.ObfuscatedPath(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
-	fmt.Printf("Raw encrypted filesystem contents (underlying storage):\n")
-	c.showRawDirectory(path, 0)
+	fmt.Printf("Raw encrypted contents of %s (%s on disk):\n", path, obfuscatedPath)
+	c.showRawDirectory(obfuscatedPath, path, 0)
 }
 
-func (c *CLI) showRawDirectory(path string, depth int) {
+// showRawDirectory lists the directory at obfuscatedPath of the underlying storage,
+// annotating each entry with its decrypted name from the filemap of userPath. Entries
+// of directories outside the filemaps, such as the GrainFS state, are not annotated.
+func (c *CLI) showRawDirectory(obfuscatedPath, userPath string, depth int) {
 	indent := strings.Repeat("  ", depth)
 
-	infos, err := c.underlying.ReadDir(path)
+	infos, err := c.underlying.ReadDir(obfuscatedPath)
 	if err != nil {
 		fmt.Printf("%sError reading directory: %v\n", indent, err)
 		return
 	}
 
+	var filemap grainfs.FilenameMap
+	if userPath != "" {
+		if filemap, err = c.fs.Filemap(userPath); err != nil {
+			fmt.Printf("%sError reading filemap: %v\n", indent, err)
+		}
+	}
+
 	for _, info := range infos {
+		original, mapped := filemap[info.Name()]
+		annotation := ""
+		switch {
+		case info.Name() == grainfs.GrainFSDir && userPath != "":
+			annotation = "  (GrainFS state)"
+		case mapped:
+			annotation = "  -> " + original
+		case filemap != nil:
+			annotation = "  (not in filemap)"
+		}
+
 		if info.IsDir() {
-			fmt.Printf("%s[DIR]  %s/\n", indent, info.Name())
+			fmt.Printf("%s[DIR]  %s/%s\n", indent, info.Name(), annotation)
 			if depth < 3 { // Limit recursion depth
-				subPath := filepath.Join(path, info.Name())
-				c.showRawDirectory(subPath, depth+1)
+				childPath := ""
+				if mapped {
+					childPath = filepath.Join(userPath, original)
+				}
+				c.showRawDirectory(filepath.Join(obfuscatedPath, info.Name()), childPath, depth+1)
 			}
 		} else {
-			fmt.Printf("%s[FILE] %s (%d bytes)\n", indent, info.Name(), info.Size())
+			fmt.Printf("%s[FILE] %s (%d bytes)%s\n", indent, info.Name(), info.Size(), annotation)
 		}
 	}
 }
@@ -368,9 +564,30 @@ func (c *CLI) showFilemap(args []string) {
 		path = c.resolvePath(args[0])
 	}
 
+	filemap, err := c.fs.Filemap(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
 	fmt.Printf("Filename mappings for directory: %s\n", path)
-	fmt.Println("(Note: This requires access to internal GrainFS methods)")
-	fmt.Println("Use 'raw' command to see the encrypted filenames on disk")
+	if len(filemap) == 0 {
+		fmt.Println("(no entries)")
+		return
+	}
+
+	obfuscated := make([]string, 0, len(filemap))
+	width := 0
+	for obf, original := range filemap {
+		obfuscated = append(obfuscated, obf)
+		width = max(width, len(original))
+	}
+	sort.Slice(obfuscated, func(i, j int) bool {
+		return filemap[obfuscated[i]] < filemap[obfuscated[j]]
+	})
+	for _, obf := range obfuscated {
+		fmt.Printf("  %-*s -> %s\n", width, filemap[obf], obf)
+	}
 }
 
 func (c *CLI) showTree(args []string) {
@@ -390,13 +607,7 @@ func (c *CLI) showTreeRecursive(path, prefix string, isLast bool) {
 		return
 	}
 
-	// Sort directories first, then files
-	sort.Slice(infos, func(i, j int) bool {
-		if infos[i].IsDir() != infos[j].IsDir() {
-			return infos[i].IsDir()
-		}
-		return infos[i].Name() < infos[j].Name()
-	})
+	sortDirsFirst(infos)
 
 	for i, info := range infos {
 		isLastItem := i == len(infos)-1
@@ -429,6 +640,107 @@ func (c *CLI) showTreeRecursive(path, prefix string, isLast bool) {
 	}
 }
 
+// sortDirsFirst sorts directories first, then files, by name
+func sortDirsFirst(infos []os.FileInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].IsDir() != infos[j].IsDir() {
+			return infos[i].IsDir()
+		}
+		return infos[i].Name() < infos[j].Name()
+	})
+}
+
+func (c *CLI) checkFilesystem(args []string) {
+	repair := false
+	for _, arg := range args {
+		if arg != "--repair" {
+			fmt.Println("Usage: fsck [--repair]")
+			return
+		}
+		repair = true
+	}
+
+	report, err := c.fs.Check(grainfs.CheckOptions{Repair: repair})
+	if err != nil {
+		fmt.Printf("Error checking filesystem: %v\n", err)
+		return
+	}
+
+	printCheckReport(report, repair)
+}
+
+// printCheckReport prints the report of a check, made with repair or not
+func printCheckReport(report *grainfs.CheckReport, repair bool) {
+	fmt.Printf("Checked %d directories and %d files\n", report.Directories, report.Files)
+	for _, problem := range report.Problems {
+		path := problem.Path
+		if path == "" {
+			path = "(unknown)"
+		}
+		fmt.Printf("  %-13s %s [%s]: %v\n", problem.Kind, path, problem.ObfuscatedPath, problem.Err)
+		if problem.Repaired {
+			if problem.RepairedPath != "" {
+				fmt.Printf("  %-13s moved to %s\n", "", problem.RepairedPath)
+			} else {
+				fmt.Printf("  %-13s removed\n", "")
+			}
+		}
+	}
+
+	if report.OK() {
+		fmt.Println("Filesystem is consistent")
+	} else if repair {
+		fmt.Println("Filesystem has problems that cannot be repaired automatically")
+	} else {
+		fmt.Println("Filesystem has problems; run 'fsck --repair' to repair what can be repaired")
+	}
+}
+
+func (c *CLI) rebuildFilemaps(args []string) {
+	path := c.currentPath
+	if len(args) > 0 {
+		path = c.resolvePath(args[0])
+	}
+
+	report, err := c.fs.RebuildFilemaps(path)
+	if err != nil {
+		fmt.Printf("Error rebuilding filemaps: %v\n", err)
+		return
+	}
+
+	printRebuildReport(report, path)
+}
+
+// printRebuildReport prints the report of rebuilding the filemaps below path
+func printRebuildReport(report *grainfs.RebuildReport, path string) {
+	fmt.Printf("Rebuilt filemaps of %d directories under %s\n", report.Directories, path)
+	for _, dir := range report.Replaced {
+		fmt.Printf("  replaced undecryptable filemap of %s\n", dir)
+	}
+	for _, recovered := range report.Recovered {
+		fmt.Printf("  recovered %s\n", recovered)
+	}
+	if len(report.Unrecoverable) > 0 {
+		fmt.Printf("Warning: %d entries could not be recovered:\n", len(report.Unrecoverable))
+		for _, obfuscated := range report.Unrecoverable {
+			fmt.Printf("  %s\n", obfuscated)
+		}
+		fmt.Println("Run 'fsck --repair' to move them into lost+found")
+	}
+}
+
+// runShellCommand runs a subcommand from the shell, printing its errors
+func (c *CLI) runShellCommand(name string, args []string) {
+	cmd := commands[name]
+	if err := cmd.run(c, args); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Printf("Usage: %s\n", cmd.usage)
+			return
+		}
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
 func (c *CLI) resolvePath(path string) string {
 	if filepath.IsAbs(path) {
 		return path
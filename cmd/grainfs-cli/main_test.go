@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPassword is the password of the volumes created by the tests
+const testPassword = "test-password-123"
+
+// capture runs f with stdin reading from stdin, and returns what it wrote to stdout and
+// stderr
+func capture(t *testing.T, stdin string, f func()) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 3)
+	for i, name := range []string{"stdin", "stdout", "stderr"} {
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		defer file.Close()
+		files[i] = file
+	}
+	if _, err := io.WriteString(files[0], stdin); err != nil {
+		t.Fatalf("Failed to write stdin: %v", err)
+	}
+	if _, err := files[0].Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Failed to rewind stdin: %v", err)
+	}
+
+	oldStdin, oldStdout, oldStderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = files[0], files[1], files[2]
+	defer func() {
+		os.Stdin, os.Stdout, os.Stderr = oldStdin, oldStdout, oldStderr
+	}()
+	f()
+
+	stdout, err := os.ReadFile(files[1].Name())
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	stderr, err := os.ReadFile(files[2].Name())
+	if err != nil {
+		t.Fatalf("Failed to read stderr: %v", err)
+	}
+	return string(stdout), string(stderr)
+}
+
+// runCLI runs grainfs-cli with args, returning its exit code and output
+func runCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var code int
+	stdout, stderr := capture(t, stdin, func() {
+		code = run(args)
+	})
+	return code, stdout, stderr
+}
+
+// newTestVolume creates a volume in a temporary directory, with the password in the
+// environment, and returns its path
+func newTestVolume(t *testing.T) string {
+	t.Helper()
+	t.Setenv(PasswordEnv, testPassword)
+	volume := filepath.Join(t.TempDir(), "volume")
+	if code, _, stderr := runCLI(t, "", volume, "init", "--iterations", "1000"); code != exitOK {
+		t.Fatalf("init failed with %d: %s", code, stderr)
+	}
+	return volume
+}
+
+func TestRun(t *testing.T) {
+	volume := newTestVolume(t)
+	local := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(local, []byte("hello, world"), 0644); err != nil {
+		t.Fatalf("Failed to write local file: %v", err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name   string
+		stdin  string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{name: "no arguments", args: nil, code: exitUsage},
+		{name: "help", args: []string{"-h"}, code: exitOK},
+		{name: "unknown flag", args: []string{"--bogus", volume, "ls"}, code: exitUsage},
+		{name: "unknown command", args: []string{volume, "lss"}, code: exitUsage},
+		{name: "no volume", args: []string{missing, "ls"}, code: exitFailure},
+		{name: "init twice", args: []string{volume, "init"}, code: exitFailure},
+		{name: "two password sources", args: []string{"--password-stdin", "--password-command", "echo x", volume, "ls"}, code: exitFailure},
+		{name: "password and file on stdin", args: []string{"--password-stdin", volume, "put", "-", "a.txt"}, code: exitUsage},
+
+		{name: "put", args: []string{volume, "put", local, "docs/hello.txt"}, code: exitOK},
+		{name: "put stdin", stdin: "from stdin", args: []string{volume, "put", "-", "docs/stdin.txt"}, code: exitOK},
+		{name: "put without parent", args: []string{volume, "put", local, "missing/dir/x.txt"}, code: exitOK},
+		{name: "cat", args: []string{volume, "cat", "docs/hello.txt", "docs/stdin.txt"}, code: exitOK, stdout: "hello, worldfrom stdin"},
+		{name: "get to stdout", args: []string{volume, "get", "docs/hello.txt", "-"}, code: exitOK, stdout: "hello, world"},
+		{name: "ls", args: []string{volume, "ls", "docs"}, code: exitOK, stdout: "file        12  hello.txt\nfile        10  stdin.txt\n"},
+		{name: "mv", args: []string{volume, "mv", "docs/stdin.txt", "docs/moved.txt"}, code: exitOK},
+		{name: "cat moved", args: []string{volume, "cat", "docs/moved.txt"}, code: exitOK, stdout: "from stdin"},
+		{name: "cat missing", args: []string{volume, "cat", "docs/stdin.txt"}, code: exitFailure},
+		{name: "rm directory", args: []string{volume, "rm", "missing"}, code: exitFailure},
+		{name: "rm -r", args: []string{volume, "rm", "-r", "missing"}, code: exitOK},
+		{name: "fsck", args: []string{volume, "fsck"}, code: exitOK},
+
+		// Argument counts are checked against the bounds of each command
+		{name: "ls too many", args: []string{volume, "ls", "a", "b"}, code: exitUsage},
+		{name: "cat too few", args: []string{volume, "cat"}, code: exitUsage},
+		{name: "put too few", args: []string{volume, "put", local}, code: exitUsage},
+		{name: "fsck with an argument", args: []string{volume, "fsck", "docs"}, code: exitUsage},
+		{name: "command flag", args: []string{volume, "rm", "--bogus", "docs"}, code: exitUsage},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, test.stdin, test.args...)
+			if code != test.code {
+				t.Fatalf("Expected exit code %d, got %d: %s", test.code, code, stderr)
+			}
+			if test.stdout != "" && stdout != test.stdout {
+				t.Fatalf("Expected output %q, got %q", test.stdout, stdout)
+			}
+		})
+	}
+}
+
+func TestRunWrongPassword(t *testing.T) {
+	volume := newTestVolume(t)
+	if code, _, stderr := runCLI(t, "secret", volume, "put", "-", "secret.txt"); code != exitOK {
+		t.Fatalf("put failed with %d: %s", code, stderr)
+	}
+
+	t.Setenv(PasswordEnv, "wrong-password")
+	if code, stdout, _ := runCLI(t, "", volume, "cat", "secret.txt"); code != exitFailure || stdout != "" {
+		t.Fatalf("Expected reading with a wrong password to fail with %d, got %d %q", exitFailure, code, stdout)
+	}
+}
+
+func TestGetKeepsLocalFile(t *testing.T) {
+	volume := newTestVolume(t)
+	if code, _, stderr := runCLI(t, "secret", volume, "put", "-", "secret.txt"); code != exitOK {
+		t.Fatalf("put failed with %d: %s", code, stderr)
+	}
+	dir := t.TempDir()
+	local := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(local, []byte("my notes"), 0600); err != nil {
+		t.Fatalf("Failed to write local file: %v", err)
+	}
+
+	// Neither a missing file nor a wrong password touches the existing file
+	if code, _, _ := runCLI(t, "", volume, "get", "missing.txt", local); code != exitFailure {
+		t.Fatalf("Expected getting a missing file to fail with %d, got %d", exitFailure, code)
+	}
+	t.Setenv(PasswordEnv, "wrong-password")
+	if code, _, _ := runCLI(t, "", volume, "get", "secret.txt", local); code != exitFailure {
+		t.Fatalf("Expected getting with a wrong password to fail with %d, got %d", exitFailure, code)
+	}
+	if content, err := os.ReadFile(local); err != nil || string(content) != "my notes" {
+		t.Fatalf("Expected the local file to be unchanged, got %q: %v", content, err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("Expected no temporary files to be left, found %d: %v", len(entries), err)
+	}
+
+	// A successful get replaces the file and keeps its mode
+	t.Setenv(PasswordEnv, testPassword)
+	if code, _, stderr := runCLI(t, "", volume, "get", "secret.txt", local); code != exitOK {
+		t.Fatalf("get failed with %d: %s", code, stderr)
+	}
+	if content, err := os.ReadFile(local); err != nil || string(content) != "secret" {
+		t.Fatalf("Expected the fetched content, got %q: %v", content, err)
+	}
+	if info, err := os.Stat(local); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the mode to be kept, got %v: %v", info, err)
+	}
+}
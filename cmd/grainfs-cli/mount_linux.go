@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	grainfuse "github.com/NovaCove/grainfs/fuse"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func (c *CLI) commandMount(args []string) error {
+	flags := c.flags("mount")
+	readOnly := flags.Bool("read-only", false, "refuse all changes")
+	allowOther := flags.Bool("allow-other", false, "let other users access the mount")
+	debug := flags.Bool("debug", false, "log every FUSE request")
+	rest, err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	mountpoint := rest[0]
+
+	fs, mode := c.fs, ""
+	if *readOnly {
+		fs, mode = fs.ReadOnly(), " read-only"
+	}
+	server, err := grainfuse.Mount(fs, mountpoint, &fusefs.Options{
+		MountOptions: fuse.MountOptions{
+			AllowOther: *allowOther,
+			Debug:      *debug,
+			// Mount with the mount syscall when permitted, falling back to fusermount
+			DirectMount: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	unmounted := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(unmounted)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Mounted %s on %s%s; press Ctrl-C or unmount to stop\n", c.rootPath, mountpoint, mode)
+	select {
+	case <-unmounted:
+		return nil
+	case <-signals:
+	}
+	if err := server.Unmount(); err != nil {
+		return fmt.Errorf("failed to unmount %s: %w", mountpoint, err)
+	}
+	<-unmounted
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func (c *CLI) commandMount(args []string) error {
+	return errors.New("mount is only supported on Linux")
+}
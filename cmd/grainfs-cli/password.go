@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// PasswordEnv is the environment variable the password is read from when no other
+// source is given
+const PasswordEnv = "GRAINFS_PASSWORD"
+
+// passwordOptions are the sources a password can be read from
+type passwordOptions struct {
+	file    string
+	keyfile string
+	command string
+	stdin   bool
+
+	// confirm asks twice for a password prompted on a terminal, as it initializes a
+	// new volume
+	confirm bool
+}
+
+// readPassword returns the password from the source configured in opts, the
+// environment, or else prompts for it
+func readPassword(opts passwordOptions) (string, error) {
+	sources := 0
+	for _, given := range []bool{opts.file != "", opts.keyfile != "", opts.command != "", opts.stdin} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", errors.New("only one of --password-file, --keyfile, --password-command and --password-stdin can be given")
+	}
+
+	var password string
+	switch {
+	case opts.file != "":
+		data, err := os.ReadFile(opts.file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		password = firstLine(string(data))
+	case opts.keyfile != "":
+		// The whole content is the key, whatever bytes it holds
+		data, err := os.ReadFile(opts.keyfile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read keyfile: %w", err)
+		}
+		password = string(data)
+	case opts.command != "":
+		cmd := exec.Command("sh", "-c", opts.command)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("password command failed: %w", err)
+		}
+		password = firstLine(string(output))
+	case opts.stdin:
+		line, err := readLine(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		password = line
+	case os.Getenv(PasswordEnv) != "":
+		password = os.Getenv(PasswordEnv)
+	default:
+		var err error
+		if password, err = promptPassword(opts.confirm); err != nil {
+			return "", err
+		}
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("password cannot be empty")
+	}
+	return password, nil
+}
+
+// promptPassword prompts for the password on stderr. On a terminal it is read without
+// echo, twice if confirm is set; otherwise it is the first line of stdin.
+func promptPassword(confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Enter password: ")
+		line, err := readLine(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return line, nil
+	}
+
+	password, err := readHidden(fd, "Enter password: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm && password != "" {
+		again, err := readHidden(fd, "Confirm password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+// readHidden reads a line from the terminal fd without echoing it
+func readHidden(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(password), nil
+}
+
+// readLine reads a single line from r without buffering past it, so that the rest of r
+// can still be read by the command
+func readLine(r io.Reader) (string, error) {
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line.WriteByte(buf[0])
+		}
+		if err == io.EOF {
+			if line.Len() == 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(line.String(), "\r"), nil
+}
+
+// firstLine returns the first line of s, without its line ending
+func firstLine(s string) string {
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	if scanner.Scan() {
+		return scanner.Text()
+	}
+	return ""
+}
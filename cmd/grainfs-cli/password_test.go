@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadPassword(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	if err := os.WriteFile(file, []byte("from-file\nsecond line\n"), 0600); err != nil {
+		t.Fatalf("Failed to write password file: %v", err)
+	}
+	keyfile := filepath.Join(dir, "keyfile")
+	if err := os.WriteFile(keyfile, []byte("key\x00bytes\n"), 0600); err != nil {
+		t.Fatalf("Failed to write keyfile: %v", err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatalf("Failed to write empty file: %v", err)
+	}
+	t.Setenv(PasswordEnv, "from-env")
+
+	tests := []struct {
+		name     string
+		opts     passwordOptions
+		stdin    string
+		password string
+		err      string
+	}{
+		// Explicit sources take precedence over the environment
+		{name: "file", opts: passwordOptions{file: file}, password: "from-file"},
+		{name: "keyfile", opts: passwordOptions{keyfile: keyfile}, password: "key\x00bytes\n"},
+		{name: "command", opts: passwordOptions{command: "printf 'from-command\\nmore'"}, password: "from-command"},
+		{name: "stdin", opts: passwordOptions{stdin: true}, stdin: "from-stdin\r\nrest", password: "from-stdin"},
+		{name: "environment", opts: passwordOptions{}, password: "from-env"},
+
+		{name: "two sources", opts: passwordOptions{file: file, stdin: true}, err: "only one of"},
+		{name: "missing file", opts: passwordOptions{file: filepath.Join(dir, "missing")}, err: "failed to read password file"},
+		{name: "empty file", opts: passwordOptions{file: empty}, err: "cannot be empty"},
+		{name: "failing command", opts: passwordOptions{command: "exit 3"}, err: "password command failed"},
+		{name: "empty stdin", opts: passwordOptions{stdin: true}, err: "failed to read password from stdin"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var password string
+			var err error
+			capture(t, test.stdin, func() {
+				password, err = readPassword(test.opts)
+			})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected an error containing %q, got %q: %v", test.err, password, err)
+				}
+				return
+			}
+			if err != nil || password != test.password {
+				t.Fatalf("Expected %q, got %q: %v", test.password, password, err)
+			}
+		})
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	// The rest of the input is left for the command
+	reader := strings.NewReader("password\nfile content")
+	if line, err := readLine(reader); err != nil || line != "password" {
+		t.Fatalf("Expected the first line, got %q: %v", line, err)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || string(rest) != "file content" {
+		t.Fatalf("Expected the rest of the input to be left, got %q: %v", rest, err)
+	}
+
+	if line, err := readLine(strings.NewReader("no newline")); err != nil || line != "no newline" {
+		t.Fatalf("Expected a line without newline, got %q: %v", line, err)
+	}
+	if _, err := readLine(strings.NewReader("")); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF on empty input, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/NovaCove/grainfs"
+	grainsftp "github.com/NovaCove/grainfs/sftp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func (c *CLI) commandSFTP(args []string) error {
+	home, _ := os.UserHomeDir()
+	configDir, _ := os.UserConfigDir()
+
+	flags := c.flags("sftp")
+	addr := flags.String("addr", "127.0.0.1:2022", "address to listen on")
+	authorizedKeys := flags.String("authorized-keys", filepath.Join(home, ".ssh", "authorized_keys"), "public keys allowed to connect, in authorized_keys format")
+	hostKey := flags.String("host-key", filepath.Join(configDir, "grainfs", "ssh_host_ed25519_key"), "private host key, generated if missing")
+	readOnly := flags.Bool("read-only", false, "refuse all changes")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := sshServerConfig(*authorizedKeys, *hostKey)
+	if err != nil {
+		return err
+	}
+
+	fs, mode := c.fs, ""
+	if *readOnly {
+		fs, mode = fs.ReadOnly(), " read-only"
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %s over SFTP on %s%s; press Ctrl-C to stop\n", c.rootPath, listener.Addr(), mode)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveSSH(conn, config, fs)
+	}
+}
+
+// sshServerConfig returns the configuration of an SSH server accepting the public keys
+// of the authorized_keys file authorizedKeys, with the host key at hostKeyPath
+func sshServerConfig(authorizedKeys, hostKeyPath string) (*ssh.ServerConfig, error) {
+	data, err := os.ReadFile(authorizedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	allowed := make(map[string]bool)
+	for len(data) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// Only blank lines and comments remain
+			break
+		}
+		allowed[string(key.Marshal())] = true
+		data = rest
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("no public keys in %s", authorizedKeys)
+	}
+
+	hostKey, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !allowed[string(key.Marshal())] {
+				return nil, fmt.Errorf("unknown public key for %s", meta.User())
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"fingerprint": ssh.FingerprintSHA256(key)}}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	return config, nil
+}
+
+// loadHostKey loads the private host key at path, generating an ed25519 one if there is
+// none yet
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %w", err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "grainfs-cli sftp")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode host key: %w", err)
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to save host key: %w", err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save host key: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Generated host key %s\n", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// serveSSH serves the sftp subsystem of an SSH connection
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, fs *grainfs.GrainFS) {
+	defer conn.Close()
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grainfs-cli: %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	defer serverConn.Close()
+	fmt.Fprintf(os.Stderr, "%s connected as %s with key %s\n", conn.RemoteAddr(), serverConn.User(), serverConn.Permissions.Extensions["fingerprint"])
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grainfs-cli: %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		go serveSession(channel, channelRequests, fs)
+	}
+}
+
+// serveSession serves an SSH session, which can only run the sftp subsystem
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request, fs *grainfs.GrainFS) {
+	defer channel.Close()
+
+	for req := range requests {
+		// The payload of a subsystem request is its length-prefixed name
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+
+		server := sftp.NewRequestServer(channel, grainsftp.Handlers(fs))
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			fmt.Fprintf(os.Stderr, "grainfs-cli: sftp: %v\n", err)
+		}
+		server.Close()
+		return
+	}
+}
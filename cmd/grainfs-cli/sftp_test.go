@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// connMetadata is the metadata of a connection authenticating as user
+type connMetadata struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (m connMetadata) User() string {
+	return m.user
+}
+
+// newPublicKey returns a new ed25519 public key
+func newPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return key
+}
+
+func TestSSHServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	allowed, other := newPublicKey(t), newPublicKey(t)
+	authorizedKeys := filepath.Join(dir, "authorized_keys")
+	data := "# partners\n\n" + string(ssh.MarshalAuthorizedKey(allowed)) + "\n# end\n"
+	if err := os.WriteFile(authorizedKeys, []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write authorized keys: %v", err)
+	}
+	hostKeyPath := filepath.Join(dir, "keys", "host_key")
+
+	var config *ssh.ServerConfig
+	var err error
+	capture(t, "", func() {
+		config, err = sshServerConfig(authorizedKeys, hostKeyPath)
+	})
+	if err != nil {
+		t.Fatalf("sshServerConfig failed: %v", err)
+	}
+	// Only the listed keys are accepted
+	if _, err := config.PublicKeyCallback(connMetadata{user: "partner"}, allowed); err != nil {
+		t.Fatalf("Expected the authorized key to be accepted, got %v", err)
+	}
+	if _, err := config.PublicKeyCallback(connMetadata{user: "partner"}, other); err == nil {
+		t.Fatalf("Expected another key to be refused")
+	}
+
+	// The host key is generated once, private to the user, and reused
+	info, err := os.Stat(hostKeyPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a private host key, got %v: %v", info, err)
+	}
+	first, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		t.Fatalf("loadHostKey failed: %v", err)
+	}
+	second, err := loadHostKey(hostKeyPath)
+	if err != nil || string(second.PublicKey().Marshal()) != string(first.PublicKey().Marshal()) {
+		t.Fatalf("Expected the host key to be reused: %v", err)
+	}
+
+	// A file without keys is refused rather than locking everyone out silently
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("# nobody\n"), 0600); err != nil {
+		t.Fatalf("Failed to write authorized keys: %v", err)
+	}
+	if _, err := sshServerConfig(empty, hostKeyPath); err == nil {
+		t.Fatalf("Expected authorized keys without keys to be refused")
+	}
+	if _, err := sshServerConfig(filepath.Join(dir, "missing"), hostKeyPath); err == nil {
+		t.Fatalf("Expected missing authorized keys to be refused")
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/NovaCove/grainfs"
+	"golang.org/x/term"
+)
+
+// shellCommands are the names completed as the first word of a shell line
+var shellCommands = []string{
+	"cat", "cd", "cp", "debug", "du", "edit", "exit", "export", "filemap", "find", "fsck",
+	"head", "help", "import", "info", "ls", "mkdir", "mv", "pwd", "quit", "raw", "rebuild", "rm",
+	"rmdir", "stat", "tree", "write",
+}
+
+// shellInput reads the lines of the shell. On a terminal they are edited with
+// completion and recorded in the history; otherwise they are read as they come.
+type shellInput struct {
+	fd       int
+	terminal *term.Terminal
+	history  *history
+	scanner  *bufio.Scanner
+
+	// saveFailed is set once saving the history failed, to warn only once
+	saveFailed bool
+}
+
+// newShellInput returns the input of the shell of c
+func (c *CLI) newShellInput() *shellInput {
+	in := &shellInput{fd: int(os.Stdin.Fd())}
+	if !term.IsTerminal(in.fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		in.scanner = bufio.NewScanner(os.Stdin)
+		return in
+	}
+
+	history, err := loadHistory(c.underlying, c.password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	in.history = history
+
+	in.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	in.terminal.History = history
+	in.terminal.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		return c.complete(in.terminal, line, pos, key)
+	}
+	return in
+}
+
+// readLine reads a line after showing prompt, returning io.EOF at the end of the input
+func (in *shellInput) readLine(prompt string) (string, error) {
+	if in.terminal == nil {
+		fmt.Print(prompt)
+		if !in.scanner.Scan() {
+			if err := in.scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return in.scanner.Text(), nil
+	}
+
+	// The terminal is only raw while editing, so that commands and editors see it as usual
+	state, err := term.MakeRaw(in.fd)
+	if err != nil {
+		return "", fmt.Errorf("failed to set up terminal: %w", err)
+	}
+	in.terminal.SetPrompt(prompt)
+	line, err := in.terminal.ReadLine()
+	term.Restore(in.fd, state)
+	if errors.Is(err, term.ErrPasteIndicator) {
+		err = nil
+	}
+	if err != nil {
+		if err == io.EOF {
+			fmt.Println()
+		}
+		return "", err
+	}
+
+	if err := in.history.save(); err != nil && !in.saveFailed {
+		in.saveFailed = true
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	return line, nil
+}
+
+// splitLine splits a shell line into words. Words are separated by whitespace, which
+// single or double quotes, or a backslash, make part of a word. Within double quotes a
+// backslash only escapes a double quote or a backslash.
+func splitLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quote, escaped := false, rune(0), false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quoteWord escapes the characters of word that splitLine treats specially
+func quoteWord(word string) string {
+	var quoted strings.Builder
+	for _, r := range word {
+		switch r {
+		case ' ', '\t', '\'', '"', '\\':
+			quoted.WriteRune('\\')
+		}
+		quoted.WriteRune(r)
+	}
+	return quoted.String()
+}
+
+// currentWord returns where the word being typed at the end of line starts, and its
+// unquoted value
+func currentWord(line string) (int, string) {
+	start := 0
+	var word strings.Builder
+	quote, escaped := rune(0), false
+
+	for i, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ' ' || r == '\t':
+			start = i + 1
+			word.Reset()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	return start, word.String()
+}
+
+// complete completes the word before the cursor on a tab: commands as the first word,
+// and plaintext paths after it. Ambiguous completions are listed on the terminal.
+func (c *CLI) complete(terminal *term.Terminal, line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	start, word := currentWord(line[:pos])
+	var candidates []string
+	if strings.TrimSpace(line[:start]) == "" {
+		for _, name := range shellCommands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name)
+			}
+		}
+	} else {
+		candidates = c.completePath(word)
+	}
+	if len(candidates) == 0 {
+		return line, pos, true
+	}
+
+	completion := quoteWord(commonPrefix(candidates))
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "/") {
+		completion += " "
+	} else if len(candidates) > 1 && commonPrefix(candidates) == word {
+		names := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			names[i] = path.Base(candidate)
+			if strings.HasSuffix(candidate, "/") {
+				names[i] += "/"
+			}
+		}
+		fmt.Fprintln(terminal, strings.Join(names, "  "))
+	}
+
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// completePath returns the paths completing the partial path word, with a slash after
+// directories
+func (c *CLI) completePath(word string) []string {
+	dir, prefix := path.Split(word)
+	listed := c.currentPath
+	if dir != "" {
+		listed = c.resolvePath(dir)
+	}
+
+	infos, err := c.fs.ReadDir(listed)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []string
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		candidate := dir + info.Name()
+		if info.IsDir() {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// commonPrefix returns the longest prefix shared by words
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	// Do not split a multi-byte character
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
+
+// shellFlags returns the flag set of a shell command
+func shellFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// intoDirectory returns the path dst names when copying or moving src there: inside dst
+// if it is a directory
+func (c *CLI) intoDirectory(src, dst string) string {
+	if info, err := c.fs.Stat(dst); err == nil && info.IsDir() {
+		return filepath.Join(dst, filepath.Base(src))
+	}
+	return dst
+}
+
+func (c *CLI) moveFile(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: mv <old> <new>")
+		return
+	}
+
+	oldPath := c.resolvePath(args[0])
+	newPath := c.intoDirectory(oldPath, c.resolvePath(args[1]))
+
+	if err := c.fs.Rename(oldPath, newPath); err != nil {
+		fmt.Printf("Error moving file: %v\n", pathError("mv", oldPath, err))
+		return
+	}
+
+	fmt.Printf("Moved %s to %s\n", oldPath, newPath)
+}
+
+func (c *CLI) copyFile(args []string) {
+	flags := shellFlags("cp")
+	recursive := flags.Bool("r", false, "copy directories and their contents")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		fmt.Println("Usage: cp [-r] <src> <dst>")
+		return
+	}
+
+	src := c.resolvePath(flags.Arg(0))
+	info, err := c.fs.Lstat(src)
+	if err != nil {
+		fmt.Printf("Error: %v\n", pathError("cp", src, err))
+		return
+	}
+	if info.IsDir() && !*recursive {
+		fmt.Printf("Error: %s is a directory (use cp -r)\n", src)
+		return
+	}
+
+	dst := c.intoDirectory(src, c.resolvePath(flags.Arg(1)))
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		fmt.Printf("Error: cannot copy %s into itself\n", src)
+		return
+	}
+
+	if err := c.fs.ImportTree(c.fs, src, dst, grainfs.TreeOptions{}); err != nil {
+		fmt.Printf("Error copying: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Copied %s to %s\n", src, dst)
+}
+
+func (c *CLI) removeDirectory(args []string) {
+	flags := shellFlags("rmdir")
+	recursive := flags.Bool("r", false, "remove the contents too")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Println("Usage: rmdir [-r] <directory>")
+		return
+	}
+
+	dirPath := c.resolvePath(flags.Arg(0))
+	info, err := c.fs.Lstat(dirPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", pathError("rmdir", dirPath, err))
+		return
+	}
+	if !info.IsDir() {
+		fmt.Printf("Error: %s is not a directory\n", dirPath)
+		return
+	}
+
+	if *recursive {
+		err = c.fs.RemoveAll(dirPath)
+	} else {
+		err = c.fs.Remove(dirPath)
+	}
+	if err != nil {
+		fmt.Printf("Error removing directory: %v\n", pathError("rmdir", dirPath, err))
+		return
+	}
+
+	fmt.Printf("Successfully removed directory: %s\n", dirPath)
+}
+
+func (c *CLI) findFiles(args []string) {
+	root := c.currentPath
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		root = c.resolvePath(args[0])
+		args = args[1:]
+	}
+
+	flags := shellFlags("find")
+	name := flags.String("name", "", "match names against a glob")
+	kind := flags.String("type", "", "match only files (f) or directories (d)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || (*kind != "" && *kind != "f" && *kind != "d") {
+		fmt.Println("Usage: find [path] [-name <glob>] [-type f|d]")
+		return
+	}
+	if _, err := path.Match(*name, ""); err != nil {
+		fmt.Printf("Error: invalid pattern %q\n", *name)
+		return
+	}
+
+	err := c.fs.Walk(root, func(walked string, info os.FileInfo, err error) error {
+		if err != nil {
+			if walked == root {
+				return err
+			}
+			fmt.Printf("Error: %v\n", pathError("find", walked, err))
+			return nil
+		}
+		if *name != "" {
+			if matched, _ := path.Match(*name, info.Name()); !matched {
+				return nil
+			}
+		}
+		if (*kind == "f" && info.IsDir()) || (*kind == "d" && !info.IsDir()) {
+			return nil
+		}
+		fmt.Println(walked)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", pathError("find", root, err))
+	}
+}
+
+func (c *CLI) diskUsage(args []string) {
+	flags := shellFlags("du")
+	summarize := flags.Bool("s", false, "only show the total")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		fmt.Println("Usage: du [-s] [path]")
+		return
+	}
+
+	root := c.currentPath
+	if flags.NArg() == 1 {
+		root = c.resolvePath(flags.Arg(0))
+	}
+
+	// Sizes are the plaintext sizes, added to every directory up to the root
+	var dirs []string
+	sizes := make(map[string]int64)
+	err := c.fs.Walk(root, func(walked string, info os.FileInfo, err error) error {
+		if err != nil {
+			if walked == root {
+				return err
+			}
+			fmt.Printf("Error: %v\n", pathError("du", walked, err))
+			return nil
+		}
+		if info.IsDir() {
+			dirs = append(dirs, walked)
+			return nil
+		}
+		for dir := filepath.Dir(walked); ; dir = filepath.Dir(dir) {
+			sizes[dir] += info.Size()
+			if dir == root || dir == "." || dir == "/" {
+				break
+			}
+		}
+		if walked == root {
+			dirs = append(dirs, walked)
+			sizes[walked] = info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", pathError("du", root, err))
+		return
+	}
+
+	if *summarize {
+		dirs = []string{root}
+	}
+	// Subdirectories come before the directories holding them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fmt.Printf("%10d  %s\n", sizes[dirs[i]], dirs[i])
+	}
+}
+
+func (c *CLI) headFile(args []string) {
+	flags := shellFlags("head")
+	lines := flags.Int("n", 10, "number of lines")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *lines < 0 {
+		fmt.Println("Usage: head [-n lines] <file>")
+		return
+	}
+
+	filename := c.resolvePath(flags.Arg(0))
+	file, err := c.fs.Open(filename)
+	if err != nil {
+		fmt.Printf("Error opening file: %v\n", pathError("open", filename, err))
+		return
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for i := 0; i < *lines; i++ {
+		line, err := reader.ReadString('\n')
+		fmt.Print(line)
+		if err == io.EOF {
+			if line != "" {
+				fmt.Println()
+			}
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error reading file: %v\n", err)
+			return
+		}
+	}
+}
+
+// editFile edits a file with $VISUAL or $EDITOR. The plaintext is written to a temporary
+// file only the user can access, which is removed afterwards, and encrypted back into
+// the file if it was saved with changes.
+func (c *CLI) editFile(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: edit <file>")
+		return
+	}
+
+	filename := c.resolvePath(args[0])
+	perm := os.FileMode(0644)
+	var content []byte
+	info, err := c.fs.Stat(filename)
+	switch {
+	case err == nil && info.IsDir():
+		fmt.Printf("Error: %s is a directory\n", filename)
+		return
+	case err == nil:
+		perm = info.Mode().Perm()
+		var buf bytes.Buffer
+		if err := c.copyOut(filename, &buf); err != nil {
+			fmt.Printf("Error reading file: %v\n", err)
+			return
+		}
+		content = buf.Bytes()
+	case !os.IsNotExist(err):
+		fmt.Printf("Error: %v\n", pathError("edit", filename, err))
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "grainfs-edit-")
+	if err != nil {
+		fmt.Printf("Error creating temporary directory: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Keep the name, so that editors recognize the file type
+	tmpFile := filepath.Join(tmpDir, filepath.Base(filename))
+	if err := os.WriteFile(tmpFile, content, 0600); err != nil {
+		fmt.Printf("Error writing temporary file: %v\n", err)
+		return
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may hold arguments, as in "code --wait"
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "editor", tmpFile)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Editor failed, %s left unchanged: %v\n", filename, err)
+		return
+	}
+
+	edited, err := os.ReadFile(tmpFile)
+	if err != nil {
+		fmt.Printf("Error reading edited file: %v\n", err)
+		return
+	}
+	if bytes.Equal(edited, content) {
+		fmt.Printf("No changes to %s\n", filename)
+		return
+	}
+
+	file, err := c.fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		fmt.Printf("Error opening file: %v\n", pathError("edit", filename, err))
+		return
+	}
+	if _, err := file.Write(edited); err != nil {
+		file.Close()
+		fmt.Printf("Error writing file: %v\n", pathError("edit", filename, err))
+		return
+	}
+	if err := file.Close(); err != nil {
+		fmt.Printf("Error writing file: %v\n", pathError("edit", filename, err))
+		return
+	}
+
+	fmt.Printf("Saved %s (%d bytes)\n", filename, len(edited))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		words []string
+		err   bool
+	}{
+		{line: "", words: nil},
+		{line: "  ls  \t docs ", words: []string{"ls", "docs"}},
+		{line: `cat 'my file.txt'`, words: []string{"cat", "my file.txt"}},
+		{line: `cat "my file.txt"`, words: []string{"cat", "my file.txt"}},
+		{line: `cat my\ file.txt`, words: []string{"cat", "my file.txt"}},
+		{line: `write a ''`, words: []string{"write", "a", ""}},
+		{line: `write a "say \"hi\" \\ \n"`, words: []string{"write", "a", `say "hi" \ \n`}},
+		{line: `write a 'no \escape'`, words: []string{"write", "a", `no \escape`}},
+		{line: `cat a"b c"d`, words: []string{"cat", "ab cd"}},
+		{line: `cat 'unterminated`, err: true},
+		{line: `cat "unterminated`, err: true},
+		{line: `cat trailing\`, err: true},
+	}
+
+	for _, test := range tests {
+		words, err := splitLine(test.line)
+		if test.err {
+			if err == nil {
+				t.Fatalf("Expected splitting %q to fail, got %q", test.line, words)
+			}
+			continue
+		}
+		if err != nil || strings.Join(words, "|") != strings.Join(test.words, "|") || len(words) != len(test.words) {
+			t.Fatalf("Expected %q to split into %q, got %q: %v", test.line, test.words, words, err)
+		}
+	}
+}
+
+func TestQuoteWord(t *testing.T) {
+	// Quoted words split back into themselves
+	for _, word := range []string{"plain", "my file.txt", `it's`, `say "hi"`, `back\slash`, "tab\there"} {
+		quoted := quoteWord(word)
+		if words, err := splitLine(quoted); err != nil || len(words) != 1 || words[0] != word {
+			t.Fatalf("Expected %q quoted as %q to split back, got %q: %v", word, quoted, words, err)
+		}
+	}
+	if quoted := quoteWord("my file.txt"); quoted != `my\ file.txt` {
+		t.Fatalf("Expected the space to be escaped, got %q", quoted)
+	}
+}
+
+func TestShellEdit(t *testing.T) {
+	fs, err := grainfs.New(memfs.New(), testPassword)
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "notes.txt", []byte("old"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	cli := &CLI{fs: fs, currentPath: "."}
+
+	// The editor is run by the shell with the file as its last argument
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	t.Setenv("VISUAL", "")
+	edit := func(editor, name string) string {
+		t.Setenv("EDITOR", editor)
+		stdout, _ := capture(t, "", func() { cli.editFile([]string{name}) })
+		return stdout
+	}
+
+	if out := edit("printf new >", "notes.txt"); !strings.HasPrefix(out, "Saved notes.txt") {
+		t.Fatalf("Expected the edit to be saved, got %q", out)
+	}
+	if content, err := util.ReadFile(fs, "notes.txt"); err != nil || string(content) != "new" {
+		t.Fatalf("Expected the edited content, got %q: %v", content, err)
+	}
+	if info, err := fs.Stat("notes.txt"); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the mode to be kept, got %v: %v", info, err)
+	}
+
+	// Unchanged files and failed editors leave the file alone
+	for _, editor := range []string{"true", "printf lost > \"$1\"; false"} {
+		if out := edit(editor, "notes.txt"); strings.HasPrefix(out, "Saved") {
+			t.Fatalf("Expected %q not to save, got %q", editor, out)
+		}
+		if content, err := util.ReadFile(fs, "notes.txt"); err != nil || string(content) != "new" {
+			t.Fatalf("Expected %q to leave the content, got %q: %v", editor, content, err)
+		}
+	}
+
+	// New files are created, and directories refused
+	if out := edit("printf created >", "new.txt"); !strings.HasPrefix(out, "Saved new.txt") {
+		t.Fatalf("Expected a new file to be saved, got %q", out)
+	}
+	if content, err := util.ReadFile(fs, "new.txt"); err != nil || string(content) != "created" {
+		t.Fatalf("Expected the new file, got %q: %v", content, err)
+	}
+	if err := fs.MkdirAll("dir", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if out := edit("printf x >", "dir"); !strings.Contains(out, "is a directory") {
+		t.Fatalf("Expected editing a directory to fail, got %q", out)
+	}
+
+	// The plaintext copies do not outlive the edits
+	if entries, err := os.ReadDir(tmpDir); err != nil || len(entries) > 0 {
+		t.Fatalf("Expected the temporary copies to be removed, found %d: %v", len(entries), err)
+	}
+}
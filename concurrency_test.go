@@ -0,0 +1,352 @@
+package grainfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// The stress tests run against osfs, as memfs is not safe for concurrent use. Run them
+// with -race.
+
+const (
+	stressWorkers     = 256
+	stressDirectories = 16
+	stressIterations  = 6
+)
+
+func TestGrainFSConcurrentOperations(t *testing.T) {
+	underlying := osfs.New(t.TempDir())
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	dirFor := func(worker int) string {
+		// Spread workers over nested directories sharing ancestors
+		return fmt.Sprintf("top-%d/dir-%02d", worker%4, worker%stressDirectories)
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < stressWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			dir := dirFor(worker)
+			for i := 0; i < stressIterations; i++ {
+				name := fmt.Sprintf("%s/w%03d-%d.txt", dir, worker, i)
+				content := []byte(fmt.Sprintf("worker %d iteration %d", worker, i))
+
+				if _, err := fs.Write(name, content); err != nil {
+					t.Errorf("Write %s failed: %v", name, err)
+					return
+				}
+				if data := readAllConcurrent(fs, name); !bytes.Equal(data, content) {
+					t.Errorf("Expected %q in %s, got %q", content, name, data)
+					return
+				}
+				if _, err := fs.Stat(name); err != nil {
+					t.Errorf("Stat %s failed: %v", name, err)
+					return
+				}
+				if _, err := fs.ReadDir(dir); err != nil {
+					t.Errorf("ReadDir %s failed: %v", dir, err)
+					return
+				}
+
+				// Odd iterations are renamed, every third file is removed again
+				if i%2 == 1 {
+					renamed := strings.TrimSuffix(name, ".txt") + ".moved"
+					if err := fs.Rename(name, renamed); err != nil {
+						t.Errorf("Rename %s failed: %v", name, err)
+						return
+					}
+					name = renamed
+				}
+				if i%3 == 2 {
+					if err := fs.Remove(name); err != nil {
+						t.Errorf("Remove %s failed: %v", name, err)
+						return
+					}
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	expected := make(map[string][]string)
+	for worker := 0; worker < stressWorkers; worker++ {
+		dir := dirFor(worker)
+		for i := 0; i < stressIterations; i++ {
+			if i%3 == 2 {
+				continue
+			}
+			name := fmt.Sprintf("w%03d-%d.txt", worker, i)
+			if i%2 == 1 {
+				name = strings.TrimSuffix(name, ".txt") + ".moved"
+			}
+			expected[dir] = append(expected[dir], name)
+		}
+	}
+
+	// Both the instance that did the work and a fresh one reading everything back from
+	// disk must agree on the final state
+	reopened, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to reopen GrainFS: %v", err)
+	}
+	for _, view := range []*GrainFS{fs, reopened} {
+		for dir, names := range expected {
+			infos, err := view.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ReadDir %s failed: %v", dir, err)
+			}
+			var listed []string
+			for _, info := range infos {
+				listed = append(listed, info.Name())
+			}
+			sort.Strings(listed)
+			sort.Strings(names)
+			if strings.Join(listed, ",") != strings.Join(names, ",") {
+				t.Fatalf("Unexpected entries in %s:\nexpected %v\ngot      %v", dir, names, listed)
+			}
+
+			for _, name := range names {
+				var worker, i int
+				fmt.Sscanf(name, "w%03d-%d", &worker, &i)
+				want := fmt.Sprintf("worker %d iteration %d", worker, i)
+				if data := readAllConcurrent(view, dir+"/"+name); string(data) != want {
+					t.Fatalf("Expected %q in %s/%s, got %q", want, dir, name, data)
+				}
+			}
+		}
+	}
+}
+
+func TestGrainFSConcurrentRenamesAcrossDirectories(t *testing.T) {
+	fs, err := New(osfs.New(t.TempDir()), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	const files = 128
+	for i := 0; i < files; i++ {
+		if _, err := fs.Write(fmt.Sprintf("left/f%03d", i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := fs.MkdirAll("right", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	// Every file is moved back and forth between the two directories while readers
+	// list them
+	var wg sync.WaitGroup
+	for i := 0; i < files; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("f%03d", i)
+			for round := 0; round < 3; round++ {
+				if err := fs.Rename("left/"+name, "right/"+name); err != nil {
+					t.Errorf("Rename to right failed: %v", err)
+					return
+				}
+				if err := fs.Rename("right/"+name, "left/"+name); err != nil {
+					t.Errorf("Rename to left failed: %v", err)
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for _, dir := range []string{"left", "right"} {
+				if _, err := fs.ReadDir(dir); err != nil {
+					t.Errorf("ReadDir %s failed: %v", dir, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	infos, err := fs.ReadDir("left")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(infos) != files {
+		t.Fatalf("Expected %d files in left, got %d", files, len(infos))
+	}
+	if infos, _ := fs.ReadDir("right"); len(infos) != 0 {
+		t.Fatalf("Expected right to be empty, got %d entries", len(infos))
+	}
+	for i := 0; i < files; i++ {
+		name := fmt.Sprintf("left/f%03d", i)
+		if data := readAllConcurrent(fs, name); string(data) != fmt.Sprint(i) {
+			t.Fatalf("Expected %d in %s, got %q", i, name, data)
+		}
+	}
+}
+
+func TestGrainFSConcurrentLinks(t *testing.T) {
+	fs, err := New(osfs.New(t.TempDir()), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("shared/original", []byte("linked content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := fs.Link("shared/original", "shared/first"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	const links = 100
+	var wg sync.WaitGroup
+	for i := 0; i < links; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("links-%d/link-%d", i%10, i)
+			if err := fs.MkdirAll(fmt.Sprintf("links-%d", i%10), 0755); err != nil {
+				t.Errorf("MkdirAll failed: %v", err)
+				return
+			}
+			if err := fs.Link("shared/original", name); err != nil {
+				t.Errorf("Link %s failed: %v", name, err)
+				return
+			}
+			if err := fs.SetXattr(name, fmt.Sprintf("user.%d", i), []byte("x")); err != nil {
+				t.Errorf("SetXattr %s failed: %v", name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	if n := linkCount(t, fs, "shared/original"); n != links+2 {
+		t.Fatalf("Expected %d links, got %d", links+2, n)
+	}
+
+	// Every attribute set through any of the names is kept
+	attrs, err := fs.ListXattr("shared/first")
+	if err != nil {
+		t.Fatalf("ListXattr failed: %v", err)
+	}
+	if len(attrs) != links {
+		t.Fatalf("Expected %d attributes, got %d", links, len(attrs))
+	}
+}
+
+// blockingFS blocks file creation below a path until released
+type blockingFS struct {
+	billy.Filesystem
+	prefix  string
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if b.prefix != "" && flag&os.O_CREATE != 0 && strings.HasPrefix(filename, b.prefix) {
+		b.once.Do(func() { close(b.entered) })
+		<-b.release
+	}
+	return b.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func TestGrainFSSlowWriteDoesNotBlockOthers(t *testing.T) {
+	backend := &blockingFS{
+		Filesystem: osfs.New(t.TempDir()),
+		entered:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	fs, err := New(backend, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("slow/existing", []byte("readable")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	slowDir, err := fs.getObfuscatedPath("slow")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	backend.prefix = slowDir + string(os.PathSeparator)
+
+	slowDone := make(chan error)
+	go func() {
+		_, err := fs.Write("slow/new", []byte("eventually"))
+		slowDone <- err
+	}()
+	<-backend.entered
+
+	// While the slow write holds its directory, other directories can be written and
+	// the slow one can still be read
+	done := make(chan error)
+	go func() {
+		if _, err := fs.Write("fast/file", []byte("quick")); err != nil {
+			done <- err
+			return
+		}
+		if _, err := fs.ReadDir("slow"); err != nil {
+			done <- err
+			return
+		}
+		if data := readAllConcurrent(fs, "slow/existing"); string(data) != "readable" {
+			done <- fmt.Errorf("unexpected content %q", data)
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Operation during slow write failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Operations were blocked by a slow write in another directory")
+	}
+
+	close(backend.release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("Slow write failed: %v", err)
+	}
+	if data := readAllConcurrent(fs, "slow/new"); string(data) != "eventually" {
+		t.Fatalf("Unexpected content after slow write: %q", data)
+	}
+}
+
+// readAllConcurrent reads a whole file, returning nil on failure so it can be used from
+// goroutines other than the test's
+func readAllConcurrent(fs billy.Basic, name string) []byte {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(file); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -4,58 +4,217 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
 	// Configuration constants
-	ConfigVersion     = "1.0.0"
+	ConfigVersion     = "2.0.0"
 	DefaultIterations = 100000
 	SaltSize          = 32
 	KeySize           = 32
 	FilenameKeySize   = 32
 
+	// LegacyConfigVersion is the format of the first volumes. Volumes before 2.0.0 do
+	// not record the key derivation, cipher and layout in the configuration, and
+	// Upgrade migrates them.
+	LegacyConfigVersion = "1.0.0"
+
+	// Key derivation functions
+	KDFPBKDF2   = "pbkdf2-sha256"
+	KDFArgon2id = "argon2id"
+
+	// Default argon2id parameters: passes, memory in KiB and threads
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+
+	// LayoutNested stores every directory as an obfuscated directory holding its own
+	// filemap, mirroring the tree of the volume
+	LayoutNested = "nested"
+
 	// Directory and file names
-	GrainFSDir  = ".grainfs"
-	ConfigFile  = "config.json"
-	FilemapFile = "filemap.json"
+	GrainFSDir   = ".grainfs"
+	ConfigFile   = "config.json"
+	FilemapFile  = "filemap.json"
+	MetadataFile = "metadata.json"
+	LinksFile    = "links.json"
+	ObjectsDir   = "objects"
+	LockFile     = "lock"
+	LocksFile    = "locks.json"
 )
 
+// ErrUnsupportedVersion is returned when opening a volume created by a newer version of
+// GrainFS
+var ErrUnsupportedVersion = errors.New("unsupported grainfs volume version")
+
 // Config represents the GrainFS configuration stored in .grainfs/config.json
 type Config struct {
-	Salt       []byte `json:"salt"`
+	Salt []byte `json:"salt"`
+	// Iterations is the number of PBKDF2 iterations, or of argon2id passes
 	Iterations int    `json:"iterations"`
 	Version    string `json:"version"`
+
+	// KDF, Cipher and Layout are unset in legacy volumes, which use PBKDF2, AES-256-GCM
+	// and the nested layout
+	KDF    string `json:"kdf,omitempty"`
+	Cipher string `json:"cipher,omitempty"`
+	Layout string `json:"layout,omitempty"`
+
+	// Memory in KiB and Threads are the argon2id parameters
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
 }
 
-// initializeConfig creates a new configuration with random salt
-func (fs *GrainFS) initializeConfig() error {
-	// Generate random salt
+// VolumeOptions configures the volume created by Init. Zero fields take the defaults:
+// PBKDF2 with DefaultIterations, AES-256-GCM and the nested layout.
+type VolumeOptions struct {
+	// KDF is KDFPBKDF2 or KDFArgon2id
+	KDF string
+	// Iterations is the number of PBKDF2 iterations, or of argon2id passes
+	Iterations int
+	// Memory in KiB and Threads configure argon2id
+	Memory  uint32
+	Threads uint8
+	// Cipher is CipherAES256GCM or CipherChaCha20Poly1305
+	Cipher string
+	// Layout is LayoutNested, the only layout so far
+	Layout string
+}
+
+// newConfig returns the configuration of a new volume with a random salt
+func newConfig(opts VolumeOptions) (*Config, error) {
 	salt := make([]byte, SaltSize)
 	if _, err := rand.Read(salt); err != nil {
-		return fmt.Errorf("failed to generate salt: %w", err)
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
 	config := &Config{
 		Salt:       salt,
-		Iterations: DefaultIterations,
+		Iterations: opts.Iterations,
 		Version:    ConfigVersion,
+		KDF:        opts.KDF,
+		Cipher:     opts.Cipher,
+		Layout:     opts.Layout,
+		Memory:     opts.Memory,
+		Threads:    opts.Threads,
+	}
+	if config.KDF == "" {
+		config.KDF = KDFPBKDF2
+	}
+	if config.KDF == KDFArgon2id {
+		if config.Iterations == 0 {
+			config.Iterations = DefaultArgon2Time
+		}
+		if config.Memory == 0 {
+			config.Memory = DefaultArgon2Memory
+		}
+		if config.Threads == 0 {
+			config.Threads = DefaultArgon2Threads
+		}
+	}
+	if config.Iterations == 0 {
+		config.Iterations = DefaultIterations
+	}
+	if config.Cipher == "" {
+		config.Cipher = CipherAES256GCM
+	}
+	if config.Layout == "" {
+		config.Layout = LayoutNested
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// initializeConfig creates a new configuration with random salt
+func (fs *GrainFS) initializeConfig() error {
+	config, err := newConfig(VolumeOptions{})
+	if err != nil {
+		return err
+	}
 	return fs.saveConfig(config)
 }
 
+// validate checks that the configuration describes a volume this version can open
+func (config *Config) validate() error {
+	if len(config.Salt) != SaltSize {
+		return fmt.Errorf("invalid salt size: expected %d, got %d", SaltSize, len(config.Salt))
+	}
+	if config.Iterations <= 0 {
+		return fmt.Errorf("invalid iterations: %d", config.Iterations)
+	}
+	if major(config.Version) > major(ConfigVersion) {
+		return fmt.Errorf("volume version %s is newer than %s: %w", config.Version, ConfigVersion, ErrUnsupportedVersion)
+	}
+
+	switch config.kdf() {
+	case KDFPBKDF2:
+	case KDFArgon2id:
+		if config.Memory == 0 || config.Threads == 0 {
+			return fmt.Errorf("invalid argon2id parameters: memory %d KiB, %d threads", config.Memory, config.Threads)
+		}
+	default:
+		return fmt.Errorf("unsupported key derivation function: %q", config.KDF)
+	}
+	if _, err := newAEAD(config.cipher(), make([]byte, KeySize)); err != nil {
+		return err
+	}
+	if config.layout() != LayoutNested {
+		return fmt.Errorf("unsupported layout: %q", config.Layout)
+	}
+	return nil
+}
+
+// kdf returns the key derivation function of the volume
+func (config *Config) kdf() string {
+	if config.KDF == "" {
+		return KDFPBKDF2
+	}
+	return config.KDF
+}
+
+// cipher returns the content cipher of the volume
+func (config *Config) cipher() string {
+	if config.Cipher == "" {
+		return CipherAES256GCM
+	}
+	return config.Cipher
+}
+
+// layout returns the layout of the volume
+func (config *Config) layout() string {
+	if config.Layout == "" {
+		return LayoutNested
+	}
+	return config.Layout
+}
+
+// major returns the major number of a version, 0 if it cannot be parsed
+func major(version string) int {
+	n, _ := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return n
+}
+
 // loadConfig loads the configuration from .grainfs/config.json
 func (fs *GrainFS) loadConfig() (*Config, error) {
 	configPath := filepath.Join(GrainFSDir, ConfigFile)
 
 	file, err := fs.underlying.Open(configPath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(err) && fs.readOnly {
+			return nil, fmt.Errorf("config file missing, cannot initialize read-only filesystem: %w", err)
+		} else if os.IsNotExist(err) {
 			// Config doesn't exist, initialize it
 			if err := fs.initializeConfig(); err != nil {
 				return nil, fmt.Errorf("failed to initialize config: %w", err)
@@ -72,12 +231,8 @@ func (fs *GrainFS) loadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to decode config: %w", err)
 	}
 
-	// Validate config
-	if len(config.Salt) != SaltSize {
-		return nil, fmt.Errorf("invalid salt size: expected %d, got %d", SaltSize, len(config.Salt))
-	}
-	if config.Iterations <= 0 {
-		return nil, fmt.Errorf("invalid iterations: %d", config.Iterations)
+	if err := config.validate(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
@@ -90,29 +245,33 @@ func (fs *GrainFS) saveConfig(config *Config) error {
 		return fmt.Errorf("failed to create .grainfs directory: %w", err)
 	}
 
-	configPath := filepath.Join(GrainFSDir, ConfigFile)
-
-	file, err := fs.underlying.Create(configPath)
+	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create config file: %w", err)
+		return fmt.Errorf("failed to encode config: %w", err)
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(config); err != nil {
-		return fmt.Errorf("failed to encode config: %w", err)
+	// Replaced atomically, as Upgrade rewrites it in place
+	if err := writeFileAtomic(fs.underlying, filepath.Join(GrainFSDir, ConfigFile), append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
 	return nil
 }
 
-// deriveKeys derives the master key and filename key from password and salt
-func deriveKeys(password string, salt []byte, iterations int) (masterKey, filenameKey []byte) {
+// deriveKeys derives the master key and filename key from password with the key
+// derivation function of config
+func deriveKeys(password string, config *Config) (masterKey, filenameKey []byte) {
+	salt, iterations := config.Salt, config.Iterations
+
 	// Derive master key for file content encryption
-	masterKey = pbkdf2.Key([]byte(password), salt, iterations, KeySize, sha256.New)
+	if config.kdf() == KDFArgon2id {
+		masterKey = argon2.IDKey([]byte(password), salt, uint32(iterations), config.Memory, config.Threads, KeySize)
+	} else {
+		masterKey = pbkdf2.Key([]byte(password), salt, iterations, KeySize, sha256.New)
+	}
 
-	// Derive filename key using master key as input with different salt
+	// Derive filename key using master key as input with different salt. The few
+	// argon2id passes are enough here, as the master key is uniformly random.
 	filenameSalt := append(salt, []byte("filename")...)
 	filenameKey = pbkdf2.Key(masterKey, filenameSalt, iterations, FilenameKeySize, sha256.New)
 
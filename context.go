@@ -0,0 +1,107 @@
+package grainfs
+
+import (
+	"context"
+	"io"
+)
+
+// Cancellation
+//
+// A view returned by WithContext stops opening, reading, writing, listing, walking
+// and renaming once its context is done, returning the context's error. Path lookups
+// check the context before loading each directory's filemap, and file content is
+// read and written in chunks with the context checked in between. Mutations check it
+// only until they start changing state, so a cancelled context never leaves an
+// operation half done.
+
+// contextChunkSize is the amount of file content transferred between context checks
+const contextChunkSize = 64 * 1024
+
+// WithContext returns a view of the filesystem whose operations honor the
+// cancellation and deadline of ctx. Files opened through the view stay bound to ctx.
+func (fs *GrainFS) WithContext(ctx context.Context) *GrainFS {
+	if ctx == nil {
+		panic("grainfs: nil context")
+	}
+
+	view := *fs
+	view.ctx = ctx
+	return &view
+}
+
+// Context returns the context of the view, or context.Background if it has none
+func (fs *GrainFS) Context() context.Context {
+	if fs.ctx == nil {
+		return context.Background()
+	}
+	return fs.ctx
+}
+
+// contextErr returns the error of the view's context once it is done
+func (fs *GrainFS) contextErr() error {
+	if fs.ctx == nil {
+		return nil
+	}
+	return fs.ctx.Err()
+}
+
+// contextReader returns r, reading in chunks that stop once the view's context is done
+func (fs *GrainFS) contextReader(r io.Reader) io.Reader {
+	if fs.ctx == nil {
+		return r
+	}
+	return &contextReader{ctx: fs.ctx, reader: r}
+}
+
+// contextWriter returns w, writing in chunks that stop once the view's context is done
+func (fs *GrainFS) contextWriter(w io.Writer) io.Writer {
+	if fs.ctx == nil {
+		return w
+	}
+	return &contextWriter{ctx: fs.ctx, writer: w}
+}
+
+// contextReader is an io.Reader checking a context before each chunk
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Read reads at most one chunk, unless the context is done
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(p) > contextChunkSize {
+		p = p[:contextChunkSize]
+	}
+	return r.reader.Read(p)
+}
+
+// contextWriter is an io.Writer checking a context before each chunk
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+// Write writes p chunk by chunk, stopping once the context is done
+func (w *contextWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := w.ctx.Err(); err != nil {
+			return written, err
+		}
+
+		chunk := p
+		if len(chunk) > contextChunkSize {
+			chunk = chunk[:contextChunkSize]
+		}
+		n, err := w.writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,159 @@
+package grainfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// cancellingFS cancels a context when a filemap is opened after being armed
+type cancellingFS struct {
+	billy.Filesystem
+	armed  bool
+	cancel context.CancelFunc
+}
+
+func (c *cancellingFS) Open(filename string) (billy.File, error) {
+	if c.armed && filepath.Base(filename) == FilemapFile {
+		c.cancel()
+	}
+	return c.Filesystem.Open(filename)
+}
+
+func TestGrainFSWithContextCancelled(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("dir/file.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	view := fs.WithContext(ctx)
+	if view.Context() != ctx {
+		t.Fatalf("Expected view to carry its context")
+	}
+
+	// Files opened before cancellation stop reading and writing
+	file, err := view.Open("dir/file.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer file.Close()
+	out, err := view.Create("dir/out.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer out.Close()
+
+	cancel()
+
+	if _, err := file.Read(make([]byte, 16)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Read to fail with context.Canceled, got %v", err)
+	}
+	if _, err := out.Write([]byte("data")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Write to fail with context.Canceled, got %v", err)
+	}
+
+	if _, err := view.Open("dir/file.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Open to fail with context.Canceled, got %v", err)
+	}
+	if _, err := view.Create("dir/new.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Create to fail with context.Canceled, got %v", err)
+	}
+	if _, err := view.Stat("dir/file.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Stat to fail with context.Canceled, got %v", err)
+	}
+	if _, err := view.ReadDir("dir"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected ReadDir to fail with context.Canceled, got %v", err)
+	}
+	if err := view.Walk(".", func(string, os.FileInfo, error) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Walk to fail with context.Canceled, got %v", err)
+	}
+	if err := view.Rename("dir/file.txt", "dir/moved.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Rename to fail with context.Canceled, got %v", err)
+	}
+
+	// Chrooted views keep the context
+	sub, err := view.Chroot("dir")
+	if err != nil {
+		t.Fatalf("Chroot failed: %v", err)
+	}
+	if _, err := sub.Stat("file.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected chrooted Stat to fail with context.Canceled, got %v", err)
+	}
+
+	// The filesystem itself is unaffected, and nothing was changed
+	if data := readAll(t, fs, "dir/file.txt"); string(data) != "content" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+	if _, err := fs.Stat("dir/moved.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Expected cancelled rename to leave no trace, got %v", err)
+	}
+	if _, err := fs.Stat("dir/new.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Expected cancelled create to leave no trace, got %v", err)
+	}
+}
+
+func TestGrainFSWithContextBetweenFilemapLoads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	underlying := &cancellingFS{Filesystem: memfs.New(), cancel: cancel}
+
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("a/b/c/file.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// A fresh instance has to load each directory's filemap along the path; the
+	// lookup stops after the first one cancels the context
+	fresh, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	underlying.armed = true
+
+	if _, err := fresh.WithContext(ctx).Stat("a/b/c/file.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Stat to fail with context.Canceled, got %v", err)
+	}
+}
+
+// cancelOnWrite cancels a context after its first write
+type cancelOnWrite struct {
+	bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnWrite) Write(p []byte) (int, error) {
+	defer c.cancel()
+	return c.Buffer.Write(p)
+}
+
+func TestGrainFSContextChunks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	view := (&GrainFS{}).WithContext(ctx)
+
+	// Writes stop between chunks
+	target := &cancelOnWrite{cancel: cancel}
+	n, err := view.contextWriter(target).Write(make([]byte, 3*contextChunkSize))
+	if !errors.Is(err, context.Canceled) || n != contextChunkSize {
+		t.Fatalf("Expected one chunk before cancellation, wrote %d: %v", n, err)
+	}
+
+	// Reads return at most a chunk at a time
+	reader := (&GrainFS{}).WithContext(context.Background()).contextReader(bytes.NewReader(make([]byte, 3*contextChunkSize)))
+	if n, err := reader.Read(make([]byte, 3*contextChunkSize)); err != nil || n != contextChunkSize {
+		t.Fatalf("Expected a chunk, read %d: %v", n, err)
+	}
+}
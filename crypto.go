@@ -9,6 +9,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 const (
@@ -19,19 +21,41 @@ const (
 	MaxFilenameLen = 200 // Maximum obfuscated filename length
 )
 
-// encryptData encrypts data using AES-256-GCM
-// Returns: [nonce][encrypted_data][auth_tag]
-func encryptData(key, plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
-	}
+// Ciphers encrypting file contents and GrainFS state. Filenames are always obfuscated
+// with AES.
+const (
+	CipherAES256GCM        = "aes-256-gcm"
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+)
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+// newAEAD returns the cipher named name keyed with key. Both ciphers use NonceSize
+// nonces and TagSize tags, so data has the same layout whichever is used.
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
+	switch name {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM: %w", err)
+		}
+		return gcm, nil
+	case CipherChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher: %q", name)
 	}
+}
 
+// encryptData encrypts data with the volume's cipher
+// Returns: [nonce][encrypted_data][auth_tag]
+func encryptData(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
 	// Generate random nonce
 	nonce := make([]byte, NonceSize)
 	if _, err := rand.Read(nonce); err != nil {
@@ -39,7 +63,7 @@ func encryptData(key, plaintext []byte) ([]byte, error) {
 	}
 
 	// Encrypt and authenticate
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext := aead.Seal(nil, nonce, plaintext, nil)
 
 	// Prepend nonce to ciphertext
 	result := make([]byte, NonceSize+len(ciphertext))
@@ -51,27 +75,17 @@ func encryptData(key, plaintext []byte) ([]byte, error) {
 
 // decryptData decrypts data encrypted with encryptData
 // Expects: [nonce][encrypted_data][auth_tag]
-func decryptData(key, ciphertext []byte) ([]byte, error) {
+func decryptData(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
 	if len(ciphertext) < NonceSize+TagSize {
 		return nil, fmt.Errorf("ciphertext too short: %d bytes", len(ciphertext))
 	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
 	// Extract nonce and encrypted data
 	nonce := ciphertext[:NonceSize]
 	encrypted := ciphertext[NonceSize:]
 
 	// Decrypt and verify
-	plaintext, err := gcm.Open(nil, nonce, encrypted, nil)
+	plaintext, err := aead.Open(nil, nonce, encrypted, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
@@ -183,18 +197,17 @@ type EncryptingWriter struct {
 	buffer []byte
 }
 
-// NewEncryptingWriter creates a new encrypting writer
+// NewEncryptingWriter creates a new encrypting writer using AES-256-GCM
 func NewEncryptingWriter(w io.Writer, key []byte) (*EncryptingWriter, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newAEAD(CipherAES256GCM, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
+	return newEncryptingWriter(w, gcm)
+}
 
+// newEncryptingWriter creates a new encrypting writer using aead
+func newEncryptingWriter(w io.Writer, gcm cipher.AEAD) (*EncryptingWriter, error) {
 	// Generate and write nonce
 	nonce := make([]byte, NonceSize)
 	if _, err := rand.Read(nonce); err != nil {
@@ -245,22 +258,21 @@ type DecryptingReader struct {
 	initialized bool
 }
 
-// NewDecryptingReader creates a new decrypting reader
+// NewDecryptingReader creates a new decrypting reader using AES-256-GCM
 func NewDecryptingReader(r io.Reader, key []byte) (*DecryptingReader, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newAEAD(CipherAES256GCM, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
+	return newDecryptingReader(r, gcm), nil
+}
 
+// newDecryptingReader creates a new decrypting reader using aead
+func newDecryptingReader(r io.Reader, gcm cipher.AEAD) *DecryptingReader {
 	return &DecryptingReader{
 		reader: r,
 		gcm:    gcm,
-	}, nil
+	}
 }
 
 // Read decrypts and returns data
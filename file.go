@@ -12,6 +12,7 @@ import (
 // EncryptedFile wraps a billy.File to provide transparent encryption/decryption
 type EncryptedFile struct {
 	underlying  billy.File
+	storage     billy.Filesystem
 	fs          *GrainFS
 	filename    string
 	obfuscated  string
@@ -26,10 +27,19 @@ type EncryptedFile struct {
 	// For writing
 	encryptingWriter *EncryptingWriter
 	writeBuffer      []byte
+	// modified is whether the content was written or truncated, which moves the mtime
+	modified bool
 
 	// Synchronization
 	mutex  sync.RWMutex
 	closed bool
+
+	// File lock state, see filelock.go
+	lockMutex sync.Mutex
+	lockID    string
+	lockStop  chan struct{}
+	// lockErr is why the lock held through the file was lost, guarded by mutex
+	lockErr error
 }
 
 // HACK: Ensure EncryptedFile implements billy.File
@@ -48,6 +58,10 @@ func (f *EncryptedFile) Read(p []byte) (n int, err error) {
 		return 0, fmt.Errorf("file opened for writing")
 	}
 
+	if err := f.fs.contextErr(); err != nil {
+		return 0, err
+	}
+
 	// Initialize decrypting reader if not done yet
 	if !f.readInitialized {
 		if err := f.initializeReader(); err != nil {
@@ -68,6 +82,8 @@ func (f *EncryptedFile) ReadAt(p []byte, off int64) (n int, err error) {
 		return 0, os.ErrClosed
 	} else if f.isWriteMode {
 		return 0, fmt.Errorf("file opened for writing")
+	} else if err := f.fs.contextErr(); err != nil {
+		return 0, err
 	}
 
 	// For encrypted files, ReadAt is complex due to encryption overhead
@@ -114,22 +130,44 @@ func (f *EncryptedFile) Write(p []byte) (n int, err error) {
 		return 0, os.ErrClosed
 	} else if !f.isWriteMode {
 		return 0, fmt.Errorf("file not opened for writing")
+	} else if f.lockErr != nil {
+		return 0, f.lockErr
+	} else if err := f.fs.contextErr(); err != nil {
+		return 0, err
 	}
 
 	// Initialize encrypting writer if not done yet
 	if f.encryptingWriter == nil {
 		var err error
-		f.encryptingWriter, err = NewEncryptingWriter(f.underlying, f.fs.masterKey)
+		f.encryptingWriter, err = newEncryptingWriter(f.fs.contextWriter(f.underlying), f.fs.aead)
 		if err != nil {
 			return 0, fmt.Errorf("failed to initialize encrypting writer: %w", err)
 		}
 	}
 
-	return f.encryptingWriter.Write(p)
+	n, err = f.encryptingWriter.Write(p)
+	if n > 0 {
+		f.modified = true
+	}
+	return n, err
 }
 
-// Close closes the file and finalizes encryption if writing
+// Close closes the file and finalizes encryption if writing. Closing a file releases
+// the lock held through it, and reports if the lock was lost meanwhile.
 func (f *EncryptedFile) Close() error {
+	err := f.close()
+
+	f.lockMutex.Lock()
+	defer f.lockMutex.Unlock()
+	if unlockErr := f.releaseLock(); unlockErr != nil && err == nil {
+		err = fmt.Errorf("failed to release file lock: %w", unlockErr)
+	}
+
+	return err
+}
+
+// close closes the file and finalizes encryption if writing
+func (f *EncryptedFile) close() error {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
@@ -153,6 +191,13 @@ func (f *EncryptedFile) Close() error {
 		}
 	}
 
+	// Record the new modification time of written files
+	if f.modified && !f.isTempFile {
+		if touchErr := f.fs.touch(f.filename); touchErr != nil && err == nil {
+			err = fmt.Errorf("failed to update file metadata: %w", touchErr)
+		}
+	}
+
 	f.closed = true
 	return err
 }
@@ -231,22 +276,13 @@ func (f *EncryptedFile) Truncate(size int64) error {
 	if err := f.underlying.Truncate(0); err != nil {
 		return err
 	}
+	f.modified = true
 
 	_, err := f.underlying.Seek(0, io.SeekStart)
 	return err
 
 }
 
-// Lock locks the file (if supported by underlying filesystem)
-func (f *EncryptedFile) Lock() error {
-	return f.underlying.Lock()
-}
-
-// Unlock unlocks the file (if supported by underlying filesystem)
-func (f *EncryptedFile) Unlock() error {
-	return f.underlying.Unlock()
-}
-
 // initializeReader sets up the decrypting reader and reads all data
 func (f *EncryptedFile) initializeReader() error {
 	// Seek to beginning of file
@@ -255,15 +291,11 @@ func (f *EncryptedFile) initializeReader() error {
 	}
 
 	// Create decrypting reader
-	var err error
-	f.decryptingReader, err = NewDecryptingReader(f.underlying, f.fs.masterKey)
-	if err != nil {
-		return fmt.Errorf("failed to create decrypting reader: %w", err)
-	}
+	f.decryptingReader = newDecryptingReader(f.fs.contextReader(f.underlying), f.fs.aead)
 
 	// Force initialization by reading a byte (this triggers the initialize method)
 	// We'll read and then reset the position
-	_, err = f.decryptingReader.Read(make([]byte, 1))
+	_, err := f.decryptingReader.Read(make([]byte, 1))
 	if err != nil && err != io.EOF {
 		return fmt.Errorf("failed to initialize decrypting reader: %w", err)
 	}
@@ -284,7 +316,7 @@ func (f *EncryptedFile) Stat() (os.FileInfo, error) {
 	}
 
 	// Use the filesystem's Stat method instead of the file's
-	info, err := f.fs.underlying.Stat(f.obfuscated)
+	info, err := f.storage.Stat(f.obfuscated)
 	if err != nil {
 		return nil, err
 	}
@@ -302,11 +334,10 @@ func (f *EncryptedFile) Stat() (os.FileInfo, error) {
 		}, nil
 	}
 
-	// If we haven't read the file yet, we can't determine the actual size
-	// Return the encrypted size for now
+	// If we haven't read the file yet, the size follows from the encrypted size
 	return &EncryptedFileInfo{
 		FileInfo:     info,
-		actualSize:   info.Size(),
+		actualSize:   plaintextSize(info.Size()),
 		originalName: f.filename,
 	}, nil
 }
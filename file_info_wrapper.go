@@ -1,14 +1,72 @@
 package grainfs
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
-// FileInfoWrapper wraps os.FileInfo to show original filenames
+// FileInfoWrapper wraps os.FileInfo to show original filenames and the
+// metadata GrainFS recorded for the entry
 type FileInfoWrapper struct {
 	os.FileInfo
 	originalName string
+	metadata     *FileMetadata
 }
 
 // Name returns the original filename
 func (w *FileInfoWrapper) Name() string {
 	return w.originalName
 }
+
+// Mode returns the recorded mode, keeping the file type reported by the underlying
+// filesystem unless the entry is a GrainFS symbolic link
+func (w *FileInfoWrapper) Mode() os.FileMode {
+	if w.metadata == nil {
+		return w.FileInfo.Mode()
+	}
+	fileType := w.FileInfo.Mode().Type()
+	if w.metadata.Mode&os.ModeSymlink != 0 {
+		fileType = os.ModeSymlink
+	}
+	return fileType | (w.metadata.Mode &^ os.ModeType)
+}
+
+// ModTime returns the recorded modification time
+func (w *FileInfoWrapper) ModTime() time.Time {
+	if w.metadata == nil {
+		return w.FileInfo.ModTime()
+	}
+	return w.metadata.ModTime
+}
+
+// IsDir reports whether the entry is a directory
+func (w *FileInfoWrapper) IsDir() bool {
+	return w.Mode().IsDir()
+}
+
+// Sys returns the recorded *FileMetadata, or the underlying data source when the
+// entry has no metadata record
+func (w *FileInfoWrapper) Sys() interface{} {
+	if w.metadata == nil {
+		return w.FileInfo.Sys()
+	}
+	return w.metadata.clone()
+}
+
+// Size returns the size of the decrypted content of files
+func (w *FileInfoWrapper) Size() int64 {
+	if w.FileInfo.IsDir() {
+		return w.FileInfo.Size()
+	}
+	return plaintextSize(w.FileInfo.Size())
+}
+
+// plaintextSize returns the size of the decrypted content of a file stored in size
+// bytes, with a nonce and an authentication tag. Content too short to hold them, as
+// while it is being written, has no decrypted size yet.
+func plaintextSize(size int64) int64 {
+	if size < NonceSize+TagSize {
+		return 0
+	}
+	return size - NonceSize - TagSize
+}
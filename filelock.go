@@ -0,0 +1,286 @@
+package grainfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File locks
+//
+// EncryptedFile locks are managed by GrainFS rather than the backend, so they work the
+// same on every billy filesystem and between processes sharing a store. Each lock is a
+// record in the encrypted locks file of the .grainfs directory next to the file's
+// content, updated under the store lock like any other GrainFS state. Holders lease
+// their record and renew it while they hold the lock, so locks held by a process that
+// crashed expire on their own. A holder that cannot renew its lease before it runs out
+// loses the lock: the next Write, Unlock or Close of the file reports it.
+
+// ErrFileLocked is returned when a file lock could not be acquired before the timeout
+var ErrFileLocked = errors.New("file is locked")
+
+// DefaultFileLockTimeout is how long Lock and RLock wait for a file lock
+const DefaultFileLockTimeout = 30 * time.Second
+
+// fileLockLease is how long a lock record stays valid without being renewed
+var fileLockLease = 30 * time.Second
+
+// LockMode selects between shared and exclusive file locks
+type LockMode int
+
+const (
+	// LockShared allows other shared holders, but no exclusive one
+	LockShared LockMode = iota
+	// LockExclusive allows no other holder
+	LockExclusive
+)
+
+// lockHolder is a holder of a file lock
+type lockHolder struct {
+	ID        string    `json:"id"`
+	Exclusive bool      `json:"exclusive"`
+	Expires   time.Time `json:"expires"`
+}
+
+// fileLockTable maps the obfuscated names of locked files to their holders
+type fileLockTable map[string][]lockHolder
+
+// Lock takes an exclusive lock on the file, waiting up to DefaultFileLockTimeout
+func (f *EncryptedFile) Lock() error {
+	return f.LockWithTimeout(LockExclusive, DefaultFileLockTimeout)
+}
+
+// RLock takes a shared lock on the file, waiting up to DefaultFileLockTimeout
+func (f *EncryptedFile) RLock() error {
+	return f.LockWithTimeout(LockShared, DefaultFileLockTimeout)
+}
+
+// LockWithTimeout takes a lock of the given mode on the file, waiting up to timeout for
+// conflicting holders to release it. A lock already held through this file is
+// converted to the new mode. It fails with ErrFileLocked when the timeout expires.
+func (f *EncryptedFile) LockWithTimeout(mode LockMode, timeout time.Duration) error {
+	f.lockMutex.Lock()
+	defer f.lockMutex.Unlock()
+
+	f.mutex.Lock()
+	closed := f.closed
+	lost := f.lockErr != nil
+	f.lockErr = nil
+	f.mutex.Unlock()
+	if closed {
+		return os.ErrClosed
+	} else if f.fs.readOnly {
+		return &os.PathError{Op: "lock", Path: f.filename, Err: ErrReadOnly}
+	}
+
+	// A lost lock is taken afresh, with a new renewal
+	if lost && f.lockStop != nil {
+		close(f.lockStop)
+		f.lockStop = nil
+	}
+
+	if f.lockID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return fmt.Errorf("failed to generate lock id: %w", err)
+		}
+		f.lockID = hex.EncodeToString(id)
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		acquired, err := f.tryLock(mode == LockExclusive)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			return &os.PathError{Op: "lock", Path: f.filename, Err: ErrFileLocked}
+		}
+		time.Sleep(backoff)
+		if backoff < 100*time.Millisecond {
+			backoff *= 2
+		}
+	}
+
+	if f.lockStop == nil {
+		f.lockStop = make(chan struct{})
+		go f.renewLock(f.lockStop, fileLockLease)
+	}
+
+	return nil
+}
+
+// Unlock releases the lock held through the file. It fails with ErrFileLocked if the
+// lock was lost because its lease could not be renewed.
+func (f *EncryptedFile) Unlock() error {
+	f.lockMutex.Lock()
+	defer f.lockMutex.Unlock()
+
+	return f.releaseLock()
+}
+
+// releaseLock stops renewing the lock held through the file and drops its record,
+// returning why the lock was lost if it was. The caller must hold lockMutex.
+func (f *EncryptedFile) releaseLock() error {
+	if f.lockStop == nil {
+		return nil
+	}
+	close(f.lockStop)
+	f.lockStop = nil
+
+	f.mutex.Lock()
+	lost := f.lockErr
+	f.lockErr = nil
+	f.mutex.Unlock()
+
+	err := f.modifyLocks(func(holders []lockHolder) ([]lockHolder, bool) {
+		return withoutHolder(holders, f.lockID), true
+	})
+	if lost != nil {
+		return lost
+	}
+	return err
+}
+
+// tryLock adds the file's lock record if no other holder conflicts with it
+func (f *EncryptedFile) tryLock(exclusive bool) (bool, error) {
+	acquired := false
+	err := f.modifyLocks(func(holders []lockHolder) ([]lockHolder, bool) {
+		others := withoutHolder(holders, f.lockID)
+		for _, holder := range others {
+			if exclusive || holder.Exclusive {
+				return holders, false
+			}
+		}
+
+		acquired = true
+		return append(others, lockHolder{
+			ID:        f.lockID,
+			Exclusive: exclusive,
+			Expires:   time.Now().Add(fileLockLease),
+		}), true
+	})
+	return acquired, err
+}
+
+// renewLock extends the lease of the file's lock record until stop is closed. Failed
+// renewals are retried while the lease lasts; once the record is gone or the lease
+// runs out, the lock is lost and renewal stops.
+func (f *EncryptedFile) renewLock(stop chan struct{}, lease time.Duration) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+
+	expires := time.Now().Add(lease)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		renewed := false
+		err := f.modifyLocks(func(holders []lockHolder) ([]lockHolder, bool) {
+			for i := range holders {
+				if holders[i].ID == f.lockID {
+					holders[i].Expires = time.Now().Add(lease)
+					renewed = true
+					return holders, true
+				}
+			}
+			return holders, false
+		})
+		switch {
+		case err == nil && renewed:
+			expires = time.Now().Add(lease)
+		case err == nil:
+			// The record expired or was dropped, so another holder may have the lock
+			f.loseLock(&os.PathError{Op: "lock", Path: f.filename, Err: ErrFileLocked})
+			return
+		case time.Now().Add(lease / 3).After(expires):
+			// The lease runs out before the next attempt
+			f.loseLock(&os.PathError{Op: "lock", Path: f.filename, Err: fmt.Errorf("%w: failed to renew lease: %v", ErrFileLocked, err)})
+			return
+		}
+	}
+}
+
+// loseLock records that the lock held through the file was lost because of err
+func (f *EncryptedFile) loseLock(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.lockErr == nil {
+		f.lockErr = err
+	}
+}
+
+// modifyLocks applies update to the live holders of the file's lock under the store
+// lock of the directory holding its content. The result is saved if update reports a
+// change.
+func (f *EncryptedFile) modifyLocks(update func(holders []lockHolder) ([]lockHolder, bool)) error {
+	grainfsDir := filepath.Join(filepath.Dir(f.obfuscated), GrainFSDir)
+	locksPath := filepath.Join(grainfsDir, LocksFile)
+	key := filepath.Base(f.obfuscated)
+
+	unlock, err := lockStore(f.storage, grainfsDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	table := make(fileLockTable)
+	data, generation, err := f.fs.readStoreFile(f.storage, locksPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read lock records: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &table); err != nil {
+			return fmt.Errorf("failed to unmarshal lock records: %w", err)
+		}
+	}
+
+	// Forget holders whose lease ran out
+	now := time.Now()
+	var live []lockHolder
+	for _, holder := range table[key] {
+		if holder.Expires.After(now) {
+			live = append(live, holder)
+		}
+	}
+
+	holders, changed := update(live)
+	if !changed && len(live) == len(table[key]) {
+		return nil
+	}
+
+	if len(holders) == 0 {
+		delete(table, key)
+	} else {
+		table[key] = holders
+	}
+
+	if err := f.fs.writeStoreFile(f.storage, locksPath, table, generation); err != nil {
+		return fmt.Errorf("failed to write lock records: %w", err)
+	}
+	return nil
+}
+
+// withoutHolder returns holders without the holder with the given ID
+func withoutHolder(holders []lockHolder, id string) []lockHolder {
+	result := make([]lockHolder, 0, len(holders))
+	for _, holder := range holders {
+		if holder.ID != id {
+			result = append(result, holder)
+		}
+	}
+	return result
+}
@@ -0,0 +1,243 @@
+package grainfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// openEncrypted opens filename in fs as an EncryptedFile
+func openEncrypted(t *testing.T, fs billy.Basic, filename string) *EncryptedFile {
+	file, err := fs.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", filename, err)
+	}
+	return file.(*EncryptedFile)
+}
+
+func TestGrainFSFileLocks(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("data.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	first := openEncrypted(t, fs, "data.txt")
+	defer first.Close()
+	second := openEncrypted(t, fs, "data.txt")
+	defer second.Close()
+
+	// Exclusive locks exclude each other
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := second.LockWithTimeout(LockExclusive, 20*time.Millisecond); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected ErrFileLocked, got %v", err)
+	}
+	if err := second.LockWithTimeout(LockShared, 20*time.Millisecond); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected ErrFileLocked for shared lock, got %v", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	// Shared locks coexist, but keep out exclusive ones
+	if err := first.RLock(); err != nil {
+		t.Fatalf("RLock failed: %v", err)
+	}
+	if err := second.RLock(); err != nil {
+		t.Fatalf("Second RLock failed: %v", err)
+	}
+	third := openEncrypted(t, fs, "data.txt")
+	if err := third.LockWithTimeout(LockExclusive, 20*time.Millisecond); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected ErrFileLocked, got %v", err)
+	}
+
+	// Closing a file releases its lock
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := third.LockWithTimeout(LockExclusive, 20*time.Millisecond); err != nil {
+		t.Fatalf("Expected lock to be free after Close, got %v", err)
+	}
+	if err := third.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Locks cannot be taken through closed files
+	if err := third.Lock(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Expected os.ErrClosed, got %v", err)
+	}
+
+	// Locks of other files are independent
+	if _, err := fs.Write("other.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	locked := openEncrypted(t, fs, "data.txt")
+	defer locked.Close()
+	other := openEncrypted(t, fs, "other.txt")
+	defer other.Close()
+	if err := locked.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := other.LockWithTimeout(LockExclusive, 20*time.Millisecond); err != nil {
+		t.Fatalf("Expected lock of another file to succeed, got %v", err)
+	}
+}
+
+func TestGrainFSFileLocksAcrossInstances(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("dir/data.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// A second instance stands in for another process
+	otherFS, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	file := openEncrypted(t, fs, "dir/data.txt")
+	defer file.Close()
+	if err := file.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	otherFile := openEncrypted(t, otherFS, "dir/data.txt")
+	defer otherFile.Close()
+	if err := otherFile.LockWithTimeout(LockShared, 20*time.Millisecond); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected ErrFileLocked, got %v", err)
+	}
+
+	// A holder that crashed stops blocking others once its lease runs out
+	crashed := openEncrypted(t, otherFS, "dir/data.txt")
+	defer crashed.Close()
+	crashed.lockID = "crashed"
+	if err := file.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	previous := fileLockLease
+	fileLockLease = 20 * time.Millisecond
+	defer func() { fileLockLease = previous }()
+
+	if acquired, err := crashed.tryLock(true); err != nil || !acquired {
+		t.Fatalf("Failed to record lock: %v", err)
+	}
+	if err := file.LockWithTimeout(LockExclusive, time.Second); err != nil {
+		t.Fatalf("Expected expired lock to be ignored, got %v", err)
+	}
+}
+
+func TestGrainFSFileLockWaitAndRenewal(t *testing.T) {
+	fs, err := New(osfs.New(t.TempDir()), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("data.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	previous := fileLockLease
+	fileLockLease = 30 * time.Millisecond
+	defer func() { fileLockLease = previous }()
+
+	holder := openEncrypted(t, fs, "data.txt")
+	defer holder.Close()
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	// The holder keeps renewing its lease well past its length
+	waiter := openEncrypted(t, fs, "data.txt")
+	defer waiter.Close()
+	if err := waiter.LockWithTimeout(LockExclusive, 150*time.Millisecond); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected ErrFileLocked while the lock is renewed, got %v", err)
+	}
+
+	// Waiters get the lock once it is released
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.LockWithTimeout(LockExclusive, 5*time.Second)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := holder.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Waiting for lock failed: %v", err)
+	}
+}
+
+func TestGrainFSFileLockLost(t *testing.T) {
+	fs, err := New(osfs.New(t.TempDir()), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	previous := fileLockLease
+	fileLockLease = 30 * time.Millisecond
+	defer func() { fileLockLease = previous }()
+
+	file, err := fs.OpenFile("data.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	holder := file.(*EncryptedFile)
+	defer holder.Close()
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if _, err := holder.Write([]byte("before")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Drop the holder's record, as if its lease had run out, so that renewal fails
+	if err := holder.modifyLocks(func(holders []lockHolder) ([]lockHolder, bool) {
+		return nil, true
+	}); err != nil {
+		t.Fatalf("Failed to drop lock record: %v", err)
+	}
+	time.Sleep(3 * fileLockLease)
+
+	if _, err := holder.Write([]byte("after")); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected Write to fail with ErrFileLocked once the lock is lost, got %v", err)
+	}
+	other := openEncrypted(t, fs, "data.txt")
+	defer other.Close()
+	if err := other.LockWithTimeout(LockExclusive, 0); err != nil {
+		t.Fatalf("Expected the lost lock to be free, got %v", err)
+	}
+	if err := other.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := holder.Unlock(); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected Unlock to report the lost lock, got %v", err)
+	}
+
+	// Locking again takes the lock afresh
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if _, err := holder.Write([]byte("again")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := holder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
@@ -1,30 +1,109 @@
 package grainfs
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+
+	"github.com/go-git/go-billy/v5"
 )
 
 // FilenameMap represents the mapping between original and obfuscated filenames
 type FilenameMap map[string]string
 
-// FilemapManager handles filename mapping operations
+// FilemapManager handles filename mapping operations. Cached maps are shared between
+// goroutines and must never be modified in place.
 type FilemapManager struct {
-	fs         *GrainFS
-	cache      map[string]FilenameMap
-	cacheMutex sync.RWMutex
+	fs            *GrainFS
+	cache         map[string]FilenameMap
+	metadataCache map[string]MetadataMap
+	symlinkCache  map[string]symlinkIndex
+	versions      map[string]uint64
+	invalidations uint64
+	cacheMutex    sync.RWMutex
+
+	// linksMutex serializes updates of the link table
+	linksMutex sync.Mutex
 }
 
 // NewFilemapManager creates a new filename mapping manager
 func NewFilemapManager(fs *GrainFS) *FilemapManager {
 	return &FilemapManager{
-		fs:    fs,
-		cache: make(map[string]FilenameMap),
+		fs:            fs,
+		cache:         make(map[string]FilenameMap),
+		metadataCache: make(map[string]MetadataMap),
+		symlinkCache:  make(map[string]symlinkIndex),
+		versions:      make(map[string]uint64),
+	}
+}
+
+// cacheToken identifies the state of a cache key observed by a lookup that missed
+type cacheToken struct {
+	invalidations uint64
+	version       uint64
+}
+
+// token returns the current token of a cache key. The caller must hold cacheMutex.
+func (fm *FilemapManager) token(key string) cacheToken {
+	return cacheToken{invalidations: fm.invalidations, version: fm.versions[key]}
+}
+
+// storeLoaded runs store to cache state loaded from disk for a key, unless the key was
+// saved or invalidated since the lookup that returned token. This keeps a slow load
+// from replacing newer state.
+func (fm *FilemapManager) storeLoaded(key string, token cacheToken, store func()) {
+	fm.cacheMutex.Lock()
+	defer fm.cacheMutex.Unlock()
+
+	if fm.token(key) == token {
+		store()
+	}
+}
+
+// storeSaved runs store to cache state just written to disk for a key
+func (fm *FilemapManager) storeSaved(key string, store func()) {
+	fm.cacheMutex.Lock()
+	defer fm.cacheMutex.Unlock()
+
+	fm.versions[key]++
+	store()
+}
+
+// cacheKey returns the key of a user directory in the filemap cache. Keys are relative
+// to the volume root so that chrooted views can share one cache.
+func (fs *GrainFS) cacheKey(dir string) string {
+	return filepath.Join(fs.rootPath, dir)
+}
+
+// invalidate drops cached filemaps and metadata for a cache key and everything below it
+func (fm *FilemapManager) invalidate(key string) {
+	prefix := key + string(filepath.Separator)
+
+	fm.cacheMutex.Lock()
+	defer fm.cacheMutex.Unlock()
+
+	fm.invalidations++
+	for cached := range fm.versions {
+		if cached == key || strings.HasPrefix(cached, prefix) {
+			delete(fm.versions, cached)
+		}
+	}
+	for cached := range fm.cache {
+		if cached == key || strings.HasPrefix(cached, prefix) {
+			delete(fm.cache, cached)
+		}
+	}
+	for cached := range fm.metadataCache {
+		if cached == key || strings.HasPrefix(cached, prefix) {
+			delete(fm.metadataCache, cached)
+			delete(fm.symlinkCache, cached)
+		}
 	}
 }
 
@@ -82,38 +161,56 @@ func (fs *GrainFS) obfuscateFilename(dir, filename string) (string, error) {
 		return "", fmt.Errorf("failed to obfuscate filename: %w", err)
 	}
 
-	// Handle collisions by adding counter suffix
+	// Check if the name is registered already
+	filemap, err := fs.loadFilemap(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to load filemap: %w", err)
+	}
+	if finalObfuscated, registered := chooseObfuscatedName(filemap, obfuscated, filename); registered {
+		return finalObfuscated, nil
+	}
+
+	// Register it, choosing the name again from the current filemap on disk as another
+	// process may have changed it
+	var finalObfuscated string
+	err = fs.modifyFilemap(dir, func(filemap FilenameMap) bool {
+		var registered bool
+		finalObfuscated, registered = chooseObfuscatedName(filemap, obfuscated, filename)
+		if registered {
+			return false
+		}
+		filemap[finalObfuscated] = filename
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to update filemap: %w", err)
+	}
+
+	return finalObfuscated, nil
+}
+
+// chooseObfuscatedName returns the obfuscated name for filename in a filemap, adding a
+// counter suffix to obfuscated on collisions with other filenames. It reports whether
+// the name is registered in the filemap already.
+func chooseObfuscatedName(filemap FilenameMap, obfuscated, filename string) (string, bool) {
 	finalObfuscated := obfuscated
 	counter := 1
 	for {
-		// Check if this obfuscated name already exists in the filemap
-		filemap, err := fs.loadFilemap(dir)
-		if err != nil {
-			return "", fmt.Errorf("failed to load filemap: %w", err)
-		}
-
 		// Check if the obfuscated name is already used for a different original filename
-		if existingOriginal, exists := filemap[finalObfuscated]; exists {
-			if existingOriginal == filename {
-				// Same original filename, we can reuse this obfuscated name
-				return finalObfuscated, nil
-			}
-			// Collision with different original filename, try with counter
-			finalObfuscated = fmt.Sprintf("%s.%d", obfuscated, counter)
-			counter++
-			continue
+		existingOriginal, exists := filemap[finalObfuscated]
+		if !exists {
+			// No collision, we can use this obfuscated name
+			return finalObfuscated, false
+		}
+		if existingOriginal == filename {
+			// Same original filename, we can reuse this obfuscated name
+			return finalObfuscated, true
 		}
 
-		// No collision, we can use this obfuscated name
-		break
-	}
-
-	// Update the filemap with the new mapping
-	if err := fs.updateFilemap(dir, filename, finalObfuscated); err != nil {
-		return "", fmt.Errorf("failed to update filemap: %w", err)
+		// Collision with different original filename, try with counter
+		finalObfuscated = fmt.Sprintf("%s.%d", obfuscated, counter)
+		counter++
 	}
-
-	return finalObfuscated, nil
 }
 
 // deobfuscateFilename resolves an obfuscated filename back to the original
@@ -134,64 +231,50 @@ func (fs *GrainFS) deobfuscateFilename(dir, obfuscated string) (string, error) {
 
 	original, exists := filemap[obfuscated]
 	if !exists {
-		return "", fmt.Errorf("obfuscated filename not found in filemap: %s", obfuscated)
-	}
-
-	return original, nil
-}
-
-// updateFilemap updates the filename mapping for a directory
-func (fs *GrainFS) updateFilemap(dir, original, obfuscated string) error {
-	// Ensure .grainfs directory exists
-	if err := fs.ensureGrainFSDir(dir); err != nil {
-		return fmt.Errorf("failed to ensure .grainfs directory: %w", err)
-	}
-
-	// Load existing filemap
-	filemap, err := fs.loadFilemap(dir)
-	if err != nil {
-		// If filemap doesn't exist, create a new one
-		if os.IsNotExist(err) {
-			filemap = make(FilenameMap)
-		} else {
-			return fmt.Errorf("failed to load existing filemap: %w", err)
+		// The entry may have been added by another process since the filemap was cached
+		if filemap, err = fs.reloadFilemap(dir); err != nil {
+			return "", fmt.Errorf("failed to load filemap: %w", err)
+		}
+		if original, exists = filemap[obfuscated]; !exists {
+			return "", fmt.Errorf("obfuscated filename not found in filemap: %s", obfuscated)
 		}
 	}
 
-	// Update the mapping
-	filemap[obfuscated] = original
-
-	// Save the updated filemap
-	return fs.saveFilemap(dir, filemap)
+	return original, nil
 }
 
 // removeFromFilemap removes a filename mapping from the directory's filemap
 func (fs *GrainFS) removeFromFilemap(dir, obfuscated string) error {
-	filemap, err := fs.loadFilemap(dir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Filemap doesn't exist, nothing to remove
-			return nil
+	return fs.modifyFilemap(dir, func(filemap FilenameMap) bool {
+		if _, exists := filemap[obfuscated]; !exists {
+			return false
 		}
-		return fmt.Errorf("failed to load filemap: %w", err)
-	}
-
-	// Remove the mapping
-	delete(filemap, obfuscated)
-
-	// Save the updated filemap
-	return fs.saveFilemap(dir, filemap)
+		delete(filemap, obfuscated)
+		return true
+	})
 }
 
 // loadFilemap loads the filename mapping for a directory
 func (fs *GrainFS) loadFilemap(dir string) (FilenameMap, error) {
 	// Check cache first
 	fs.filemapManager.cacheMutex.RLock()
-	if cached, exists := fs.filemapManager.cache[dir]; exists {
-		fs.filemapManager.cacheMutex.RUnlock()
+	cached, exists := fs.filemapManager.cache[fs.cacheKey(dir)]
+	fs.filemapManager.cacheMutex.RUnlock()
+	if exists {
 		return cached, nil
 	}
-	fs.filemapManager.cacheMutex.RUnlock()
+
+	return fs.reloadFilemap(dir)
+}
+
+// reloadFilemap reads the filename mapping for a directory from disk and caches it
+func (fs *GrainFS) reloadFilemap(dir string) (FilenameMap, error) {
+	key := fs.cacheKey(dir)
+	fm := fs.filemapManager
+
+	fm.cacheMutex.RLock()
+	token := fm.token(key)
+	fm.cacheMutex.RUnlock()
 
 	// Get the obfuscated directory path
 	obfuscatedDir, err := fs.getObfuscatedPath(dir)
@@ -199,90 +282,124 @@ func (fs *GrainFS) loadFilemap(dir string) (FilenameMap, error) {
 		return nil, fmt.Errorf("failed to get obfuscated directory path: %w", err)
 	}
 
+	filemap, _, err := fs.readFilemap(obfuscatedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the loaded filemap
+	fm.storeLoaded(key, token, func() { fm.cache[key] = filemap })
+
+	return filemap, nil
+}
+
+// readFilemap reads and decrypts the filemap of an obfuscated directory, returning it
+// with its generation. A missing filemap is returned as an empty one.
+func (fs *GrainFS) readFilemap(obfuscatedDir string) (FilenameMap, uint64, error) {
 	filemapPath := filepath.Join(obfuscatedDir, GrainFSDir, FilemapFile)
 
-	file, err := fs.underlying.Open(filemapPath)
+	data, generation, err := fs.readStoreFile(fs.underlying, filemapPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Return empty filemap if it doesn't exist
-			emptyMap := make(FilenameMap)
-			// Cache the empty map
-			fs.filemapManager.cacheMutex.Lock()
-			fs.filemapManager.cache[dir] = emptyMap
-			fs.filemapManager.cacheMutex.Unlock()
-			return emptyMap, nil
+			return make(FilenameMap), 0, nil
 		}
-		return nil, fmt.Errorf("failed to open filemap: %w", err)
-	}
-	defer file.Close()
-
-	// Read and decrypt the filemap
-	encryptedData, err := io.ReadAll(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read filemap: %w", err)
-	}
-
-	// Decrypt the filemap data
-	decryptedData, err := decryptData(fs.masterKey, encryptedData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt filemap: %w", err)
+		return nil, 0, fmt.Errorf("failed to read filemap: %w", err)
 	}
 
 	var filemap FilenameMap
-	if err := json.Unmarshal(decryptedData, &filemap); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal filemap: %w", err)
+	if err := json.Unmarshal(data, &filemap); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal filemap: %w", err)
+	}
+	if filemap == nil {
+		filemap = make(FilenameMap)
 	}
 
-	// Cache the loaded filemap
-	fs.filemapManager.cacheMutex.Lock()
-	fs.filemapManager.cache[dir] = filemap
-	fs.filemapManager.cacheMutex.Unlock()
-
-	return filemap, nil
+	return filemap, generation, nil
 }
 
-// saveFilemap saves the filename mapping for a directory
-func (fs *GrainFS) saveFilemap(dir string, filemap FilenameMap) error {
-	// Marshal the filemap to JSON
-	jsonData, err := json.MarshalIndent(filemap, "", "  ")
+// modifyFilemap applies update to the filemap of a directory under its store lock,
+// reading it back from disk first so that changes made by other processes are kept.
+// The filemap is saved if update reports a change.
+func (fs *GrainFS) modifyFilemap(dir string, update func(filemap FilenameMap) bool) error {
+	obfuscatedDir, err := fs.getObfuscatedPath(dir)
 	if err != nil {
-		return fmt.Errorf("failed to marshal filemap: %w", err)
+		return fmt.Errorf("failed to get obfuscated directory path: %w", err)
 	}
 
-	// Encrypt the filemap data
-	encryptedData, err := encryptData(fs.masterKey, jsonData)
+	unlock, err := lockStore(fs.underlying, filepath.Join(obfuscatedDir, GrainFSDir))
 	if err != nil {
-		return fmt.Errorf("failed to encrypt filemap: %w", err)
+		return err
 	}
+	defer unlock()
 
-	// Get the obfuscated directory path
-	obfuscatedDir, err := fs.getObfuscatedPath(dir)
+	filemap, generation, err := fs.readFilemap(obfuscatedDir)
 	if err != nil {
-		return fmt.Errorf("failed to get obfuscated directory path: %w", err)
+		return err
 	}
 
-	filemapPath := filepath.Join(obfuscatedDir, GrainFSDir, FilemapFile)
+	if update(filemap) {
+		filemapPath := filepath.Join(obfuscatedDir, GrainFSDir, FilemapFile)
+		if err := fs.writeStoreFile(fs.underlying, filemapPath, filemap, generation); err != nil {
+			return fmt.Errorf("failed to write filemap: %w", err)
+		}
+	}
+
+	// Update cache
+	key := fs.cacheKey(dir)
+	fm := fs.filemapManager
+	fm.storeSaved(key, func() { fm.cache[key] = filemap })
+
+	return nil
+}
+
+// writeFileAtomic replaces the named file with data by writing a temporary file next to
+// it and renaming it into place, so concurrent readers never see a partial write.
+func writeFileAtomic(bfs billy.Basic, name string, data []byte) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to generate temporary name: %w", err)
+	}
+	tempName := name + ".tmp-" + hex.EncodeToString(suffix)
 
-	file, err := fs.underlying.Create(filemapPath)
+	file, err := bfs.OpenFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
-		return fmt.Errorf("failed to create filemap file: %w", err)
+		return err
 	}
-	defer file.Close()
 
-	if _, err := file.Write(encryptedData); err != nil {
-		return fmt.Errorf("failed to write filemap: %w", err)
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		bfs.Remove(tempName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		bfs.Remove(tempName)
+		return err
 	}
 
-	// Update cache
-	fs.filemapManager.cacheMutex.Lock()
-	fs.filemapManager.cache[dir] = filemap
-	fs.filemapManager.cacheMutex.Unlock()
+	if err := bfs.Rename(tempName, name); err != nil {
+		bfs.Remove(tempName)
+		return err
+	}
 
 	return nil
 }
 
 // getObfuscatedPath converts a user path to the obfuscated path on disk
 func (fs *GrainFS) getObfuscatedPath(userPath string) (string, error) {
+	return fs.obfuscatePath(nil, userPath)
+}
+
+// getObfuscatedPathCtx converts a user path to the obfuscated path on disk, giving up
+// between filemap loads once the context of the view is done. Mutations only use it
+// before they start changing state.
+func (fs *GrainFS) getObfuscatedPathCtx(userPath string) (string, error) {
+	return fs.obfuscatePath(fs.ctx, userPath)
+}
+
+// obfuscatePath converts a user path to the obfuscated path on disk, checking ctx
+// before looking up each component if it is not nil
+func (fs *GrainFS) obfuscatePath(ctx context.Context, userPath string) (string, error) {
 	if userPath == "" || userPath == "." {
 		return ".", nil
 	}
@@ -300,6 +417,12 @@ func (fs *GrainFS) getObfuscatedPath(userPath string) (string, error) {
 			continue
 		}
 
+		if ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+		}
+
 		// Get obfuscated name for this part (without updating filemap yet)
 		obfuscatedPart, err := fs.getObfuscatedFilename(currentUserDir, part)
 		if err != nil {
@@ -336,8 +459,16 @@ func (fs *GrainFS) getObfuscatedFilename(dir, filename string) (string, error) {
 	}
 
 	// Look for existing mapping (reverse lookup)
-	for obfuscated, original := range filemap {
-		if original == filename {
+	if obfuscated, found := lookupObfuscatedName(filemap, filename); found {
+		return obfuscated, nil
+	}
+
+	// The name may have been added by another process since the filemap was cached
+	if filemap != nil {
+		if filemap, err = fs.reloadFilemap(dir); err != nil {
+			return "", fmt.Errorf("failed to load filemap: %w", err)
+		}
+		if obfuscated, found := lookupObfuscatedName(filemap, filename); found {
 			return obfuscated, nil
 		}
 	}
@@ -371,6 +502,16 @@ func (fs *GrainFS) getObfuscatedFilename(dir, filename string) (string, error) {
 	return finalObfuscated, nil
 }
 
+// lookupObfuscatedName returns the obfuscated name registered for filename in a filemap
+func lookupObfuscatedName(filemap FilenameMap, filename string) (string, bool) {
+	for obfuscated, original := range filemap {
+		if original == filename {
+			return obfuscated, true
+		}
+	}
+	return "", false
+}
+
 // getUserPath converts an obfuscated path back to the user path
 func (fs *GrainFS) getUserPath(obfuscatedPath string) (string, error) {
 	if obfuscatedPath == "" || obfuscatedPath == "." {
@@ -400,7 +541,7 @@ func (fs *GrainFS) getUserPath(obfuscatedPath string) (string, error) {
 
 		// Update current directory for next iteration
 		if i < len(parts)-1 {
-			currentDir = filepath.Join(currentDir, part)
+			currentDir = filepath.Join(currentDir, userPart)
 		}
 	}
 
@@ -0,0 +1,4 @@
+// Package fuse mounts a GrainFS with FUSE on Linux, through github.com/hanwen/go-fuse.
+// It is a module of its own, so that the core grainfs module does not depend on the
+// FUSE packages.
+package fuse
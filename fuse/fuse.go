@@ -0,0 +1,602 @@
+//go:build linux
+
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5/util"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// fuseRenameNoReplace is the renameat2 flag refusing to replace an existing target
+const fuseRenameNoReplace = 0x1
+
+// writeFlags are the open flags that may change a file
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// fuseNode is a file, directory or symbolic link of a GrainFS mounted with FUSE. The
+// content of a file is loaded when it is first opened and shared by all its open
+// handles, so that it can be read and written at any offset. It is stored, encrypted,
+// when a handle is flushed and it changed.
+type fuseNode struct {
+	fusefs.Inode
+	fs *grainfs.GrainFS
+
+	mutex sync.Mutex
+	data  []byte
+	opens int
+	dirty bool
+	// atime and mtime are the times set while the content is unstored, applied once it
+	// is stored
+	atime, mtime *time.Time
+}
+
+// Ensure fuseNode implements the node interfaces it serves
+var (
+	_ fusefs.NodeGetattrer  = (*fuseNode)(nil)
+	_ fusefs.NodeSetattrer  = (*fuseNode)(nil)
+	_ fusefs.NodeLookuper   = (*fuseNode)(nil)
+	_ fusefs.NodeReaddirer  = (*fuseNode)(nil)
+	_ fusefs.NodeOpener     = (*fuseNode)(nil)
+	_ fusefs.NodeCreater    = (*fuseNode)(nil)
+	_ fusefs.NodeReader     = (*fuseNode)(nil)
+	_ fusefs.NodeWriter     = (*fuseNode)(nil)
+	_ fusefs.NodeFlusher    = (*fuseNode)(nil)
+	_ fusefs.NodeFsyncer    = (*fuseNode)(nil)
+	_ fusefs.NodeReleaser   = (*fuseNode)(nil)
+	_ fusefs.NodeMkdirer    = (*fuseNode)(nil)
+	_ fusefs.NodeUnlinker   = (*fuseNode)(nil)
+	_ fusefs.NodeRmdirer    = (*fuseNode)(nil)
+	_ fusefs.NodeRenamer    = (*fuseNode)(nil)
+	_ fusefs.NodeSymlinker  = (*fuseNode)(nil)
+	_ fusefs.NodeReadlinker = (*fuseNode)(nil)
+	_ fusefs.NodeLinker     = (*fuseNode)(nil)
+)
+
+// Mount mounts fs at mountpoint with FUSE and returns the server, which
+// serves requests until it is unmounted. Lookups, listings, reads and writes at any
+// offset, creation, links, rename, unlink, rmdir and setattr map to the matching
+// GrainFS operations. Files are held in memory while they are open. opts may be nil
+// for the defaults. The kernel requests are served from many goroutines at once, so the
+// underlying filesystem must be safe for concurrent use; memfs is not.
+func Mount(fs *grainfs.GrainFS, mountpoint string, opts *fusefs.Options) (*fuse.Server, error) {
+	if opts == nil {
+		opts = &fusefs.Options{}
+	}
+	if opts.FsName == "" {
+		opts.FsName = "grainfs"
+	}
+	if opts.Name == "" {
+		opts.Name = "grainfs"
+	}
+	if fs.IsReadOnly() {
+		opts.Options = append(opts.Options, "ro")
+	}
+
+	server, err := fusefs.Mount(mountpoint, &fuseNode{fs: fs}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mount %s: %w", mountpoint, err)
+	}
+	return server, nil
+}
+
+// fuseErrno converts an error of a GrainFS operation into an errno for the kernel
+func fuseErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, grainfs.ErrReadOnly):
+		return syscall.EROFS
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, os.ErrInvalid):
+		return syscall.EINVAL
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return syscall.EINTR
+	}
+	return syscall.EIO
+}
+
+// fuseMode returns the type bits of a mode in the format of the kernel
+func fuseMode(mode os.FileMode) uint32 {
+	switch {
+	case mode.IsDir():
+		return syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		return syscall.S_IFLNK
+	}
+	return syscall.S_IFREG
+}
+
+// userPath returns the user path of the node, with "." for the root
+func (n *fuseNode) userPath() string {
+	name := n.Path(nil)
+	if name == "" {
+		return "."
+	}
+	return filepath.FromSlash(name)
+}
+
+// childPath returns the user path of the child name of the node
+func (n *fuseNode) childPath(name string) string {
+	return filepath.Join(n.userPath(), name)
+}
+
+// fillAttr fills out with info. Open files have the size of their content.
+func (n *fuseNode) fillAttr(info os.FileInfo, out *fuse.Attr) {
+	out.Mode = fuseMode(info.Mode()) | uint32(info.Mode().Perm())
+	out.Size = uint64(info.Size())
+	out.Nlink = 1
+	if info.IsDir() {
+		out.Nlink = 2
+	}
+	atime, mtime := info.ModTime(), info.ModTime()
+	if md, ok := info.Sys().(*grainfs.FileMetadata); ok {
+		out.Uid, out.Gid = uint32(md.UID), uint32(md.GID)
+		if md.Links > 1 {
+			out.Nlink = uint32(md.Links)
+		}
+		if !md.AccessTime.IsZero() {
+			atime = md.AccessTime
+		}
+	}
+
+	n.mutex.Lock()
+	if n.data != nil {
+		out.Size = uint64(len(n.data))
+	}
+	if n.atime != nil {
+		atime = *n.atime
+	}
+	if n.mtime != nil {
+		mtime = *n.mtime
+	}
+	n.mutex.Unlock()
+
+	out.SetTimes(&atime, &mtime, &mtime)
+	out.Blocks = (out.Size + 511) / 512
+}
+
+// newChild returns the node of the child name with info, reusing the existing one if it
+// has the same type
+func (n *fuseNode) newChild(ctx context.Context, name string, info os.FileInfo, out *fuse.EntryOut) *fusefs.Inode {
+	mode := fuseMode(info.Mode())
+	child := n.GetChild(name)
+	if child == nil || child.StableAttr().Mode != mode {
+		child = n.NewInode(ctx, &fuseNode{fs: n.fs}, fusefs.StableAttr{Mode: mode})
+	}
+	child.Operations().(*fuseNode).fillAttr(info, &out.Attr)
+	return child
+}
+
+// Getattr returns the attributes of the node, without following symbolic links
+func (n *fuseNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := n.fs.WithContext(ctx).Lstat(n.userPath())
+	if err != nil {
+		return fuseErrno(err)
+	}
+	n.fillAttr(info, &out.Attr)
+	return 0
+}
+
+// Setattr changes the size, mode, owner or times of the node
+func (n *fuseNode) Setattr(ctx context.Context, f fusefs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	fs := n.fs.WithContext(ctx)
+	userPath := n.userPath()
+
+	if size, ok := in.GetSize(); ok {
+		if errno := n.truncate(ctx, fs, userPath, int64(size)); errno != 0 {
+			return errno
+		}
+	}
+	if mode, ok := in.GetMode(); ok {
+		if err := fs.Chmod(userPath, os.FileMode(mode).Perm()); err != nil {
+			return fuseErrno(err)
+		}
+	}
+	uid, uidOK := in.GetUID()
+	gid, gidOK := in.GetGID()
+	if uidOK || gidOK {
+		owner, group := -1, -1
+		if uidOK {
+			owner = int(uid)
+		}
+		if gidOK {
+			group = int(gid)
+		}
+		if err := fs.Lchown(userPath, owner, group); err != nil {
+			return fuseErrno(err)
+		}
+	}
+	atime, atimeOK := in.GetATime()
+	mtime, mtimeOK := in.GetMTime()
+	if atimeOK || mtimeOK {
+		if errno := n.chtimes(fs, userPath, atime, atimeOK, mtime, mtimeOK); errno != 0 {
+			return errno
+		}
+	}
+
+	return n.Getattr(ctx, f, out)
+}
+
+// truncate resizes the content of the node. Open content is resized in memory and
+// stored right away, as the handles may be released after the call returns.
+func (n *fuseNode) truncate(ctx context.Context, fs *grainfs.GrainFS, userPath string, size int64) syscall.Errno {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.data != nil {
+		if fs.IsReadOnly() {
+			return syscall.EROFS
+		}
+		n.data = resize(n.data, size)
+		n.dirty = true
+		return n.store(ctx)
+	}
+
+	content, err := util.ReadFile(fs, userPath)
+	if err != nil {
+		return fuseErrno(err)
+	}
+	if err := util.WriteFile(fs, userPath, resize(content, size), 0644); err != nil {
+		return fuseErrno(err)
+	}
+	return 0
+}
+
+// chtimes sets the times of the node, or records them until its changed content is
+// stored, which would set its modification time
+func (n *fuseNode) chtimes(fs *grainfs.GrainFS, userPath string, atime time.Time, atimeOK bool, mtime time.Time, mtimeOK bool) syscall.Errno {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.dirty {
+		if atimeOK {
+			n.atime = &atime
+		}
+		if mtimeOK {
+			n.mtime = &mtime
+		}
+		return 0
+	}
+
+	if !atimeOK || !mtimeOK {
+		info, err := fs.Lstat(userPath)
+		if err != nil {
+			return fuseErrno(err)
+		}
+		if !mtimeOK {
+			mtime = info.ModTime()
+		}
+		if !atimeOK {
+			atime = info.ModTime()
+			if md, ok := info.Sys().(*grainfs.FileMetadata); ok && !md.AccessTime.IsZero() {
+				atime = md.AccessTime
+			}
+		}
+	}
+	if err := fs.Chtimes(userPath, atime, mtime); err != nil {
+		return fuseErrno(err)
+	}
+	return 0
+}
+
+// resize returns data truncated or extended with zeros to size
+func resize(data []byte, size int64) []byte {
+	if size <= int64(len(data)) {
+		return data[:size]
+	}
+	return append(data, make([]byte, size-int64(len(data)))...)
+}
+
+// Lookup finds the child name of a directory
+func (n *fuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	info, err := n.fs.WithContext(ctx).Lstat(n.childPath(name))
+	if err != nil {
+		return nil, fuseErrno(err)
+	}
+	return n.newChild(ctx, name, info, out), 0
+}
+
+// Readdir lists a directory, sorted by name
+func (n *fuseNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Errno) {
+	infos, err := n.fs.WithContext(ctx).ReadDir(n.userPath())
+	if err != nil {
+		return nil, fuseErrno(err)
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fuse.DirEntry{Name: info.Name(), Mode: fuseMode(info.Mode())})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return fusefs.NewListDirStream(entries), 0
+}
+
+// Open opens a file, loading its content unless it is already open
+func (n *fuseNode) Open(ctx context.Context, flags uint32) (fusefs.FileHandle, uint32, syscall.Errno) {
+	fs := n.fs.WithContext(ctx)
+	if int(flags)&writeFlags != 0 && fs.IsReadOnly() {
+		return nil, 0, syscall.EROFS
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.data == nil {
+		content, err := util.ReadFile(fs, n.userPath())
+		if err != nil {
+			return nil, 0, fuseErrno(err)
+		}
+		if content == nil {
+			content = []byte{}
+		}
+		n.data = content
+	}
+	if int(flags)&os.O_TRUNC != 0 {
+		n.data = n.data[:0]
+		n.dirty = true
+	}
+	n.opens++
+	return nil, 0, 0
+}
+
+// Create creates and opens the file name, which must not exist
+func (n *fuseNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fusefs.Inode, fusefs.FileHandle, uint32, syscall.Errno) {
+	fs := n.fs.WithContext(ctx)
+	userPath := n.childPath(name)
+
+	file, err := fs.OpenFile(userPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(mode).Perm())
+	if err != nil {
+		return nil, nil, 0, fuseErrno(err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, nil, 0, fuseErrno(err)
+	}
+	info, err := fs.Lstat(userPath)
+	if err != nil {
+		return nil, nil, 0, fuseErrno(err)
+	}
+
+	child := n.newChild(ctx, name, info, out)
+	node := child.Operations().(*fuseNode)
+	node.mutex.Lock()
+	node.data = []byte{}
+	node.opens++
+	node.mutex.Unlock()
+	return child, nil, 0, 0
+}
+
+// Read reads the content of an open file at offset off
+func (n *fuseNode) Read(ctx context.Context, f fusefs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if off >= int64(len(n.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(n.data)) {
+		end = int64(len(n.data))
+	}
+	// Copy, as the content may change before the result is sent
+	return fuse.ReadResultData(append([]byte(nil), n.data[off:end]...)), 0
+}
+
+// Write writes data to an open file at offset off
+func (n *fuseNode) Write(ctx context.Context, f fusefs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.fs.IsReadOnly() {
+		return 0, syscall.EROFS
+	}
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	if end := off + int64(len(data)); end > int64(len(n.data)) {
+		n.data = resize(n.data, end)
+	}
+	copy(n.data[off:], data)
+	n.dirty = true
+	return uint32(len(data)), 0
+}
+
+// Flush stores the content of the file if it changed, as a file descriptor is closed
+func (n *fuseNode) Flush(ctx context.Context, f fusefs.FileHandle) syscall.Errno {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.store(ctx)
+}
+
+// Fsync stores the content of the file if it changed
+func (n *fuseNode) Fsync(ctx context.Context, f fusefs.FileHandle, flags uint32) syscall.Errno {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.store(ctx)
+}
+
+// Release stores the content of the file if it changed, and drops it once the last
+// handle is closed
+func (n *fuseNode) Release(ctx context.Context, f fusefs.FileHandle) syscall.Errno {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	errno := n.store(ctx)
+	if n.opens--; n.opens <= 0 {
+		n.opens, n.data, n.dirty = 0, nil, false
+		n.atime, n.mtime = nil, nil
+	}
+	return errno
+}
+
+// store writes the changed content of the node, then applies the times set meanwhile.
+// Content of unlinked files is dropped. The mutex must be held.
+func (n *fuseNode) store(ctx context.Context) syscall.Errno {
+	if !n.dirty {
+		return 0
+	}
+	if _, parent := n.Parent(); parent == nil {
+		n.dirty = false
+		return 0
+	}
+
+	fs := n.fs.WithContext(ctx)
+	userPath := n.userPath()
+	if err := util.WriteFile(fs, userPath, n.data, 0644); err != nil {
+		return fuseErrno(err)
+	}
+	n.dirty = false
+
+	if n.atime != nil || n.mtime != nil {
+		info, err := fs.Lstat(userPath)
+		if err != nil {
+			return fuseErrno(err)
+		}
+		atime, mtime := info.ModTime(), info.ModTime()
+		if n.atime != nil {
+			atime = *n.atime
+		}
+		if n.mtime != nil {
+			mtime = *n.mtime
+		}
+		n.atime, n.mtime = nil, nil
+		if err := fs.Chtimes(userPath, atime, mtime); err != nil {
+			return fuseErrno(err)
+		}
+	}
+	return 0
+}
+
+// Mkdir creates the directory name
+func (n *fuseNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	fs := n.fs.WithContext(ctx)
+	userPath := n.childPath(name)
+
+	if _, err := fs.Lstat(userPath); err == nil {
+		return nil, syscall.EEXIST
+	}
+	if err := fs.MkdirAll(userPath, os.FileMode(mode).Perm()); err != nil {
+		return nil, fuseErrno(err)
+	}
+	info, err := fs.Lstat(userPath)
+	if err != nil {
+		return nil, fuseErrno(err)
+	}
+	return n.newChild(ctx, name, info, out), 0
+}
+
+// Unlink removes the file or symbolic link name
+func (n *fuseNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	fs := n.fs.WithContext(ctx)
+	userPath := n.childPath(name)
+
+	info, err := fs.Lstat(userPath)
+	if err != nil {
+		return fuseErrno(err)
+	}
+	if info.IsDir() {
+		return syscall.EISDIR
+	}
+	return fuseErrno(fs.Remove(userPath))
+}
+
+// Rmdir removes the empty directory name
+func (n *fuseNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	fs := n.fs.WithContext(ctx)
+	userPath := n.childPath(name)
+
+	info, err := fs.Lstat(userPath)
+	if err != nil {
+		return fuseErrno(err)
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	infos, err := fs.ReadDir(userPath)
+	if err != nil {
+		return fuseErrno(err)
+	}
+	if len(infos) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	return fuseErrno(fs.Remove(userPath))
+}
+
+// Rename moves the child name to newName in newParent, replacing it unless flags
+// forbid it. Exchanging entries is not supported.
+func (n *fuseNode) Rename(ctx context.Context, name string, newParent fusefs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags&fusefs.RENAME_EXCHANGE != 0 {
+		return syscall.EINVAL
+	}
+
+	fs := n.fs.WithContext(ctx)
+	oldPath := n.childPath(name)
+	newPath := newParent.EmbeddedInode().Operations().(*fuseNode).childPath(newName)
+
+	if flags&fuseRenameNoReplace != 0 {
+		if _, err := fs.Lstat(newPath); err == nil {
+			return syscall.EEXIST
+		}
+	}
+	return fuseErrno(fs.Rename(oldPath, newPath))
+}
+
+// Symlink creates the symbolic link name to target
+func (n *fuseNode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	fs := n.fs.WithContext(ctx)
+	userPath := n.childPath(name)
+
+	if err := fs.Symlink(target, userPath); err != nil {
+		return nil, fuseErrno(err)
+	}
+	info, err := fs.Lstat(userPath)
+	if err != nil {
+		return nil, fuseErrno(err)
+	}
+	return n.newChild(ctx, name, info, out), 0
+}
+
+// Readlink returns the target of a symbolic link
+func (n *fuseNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := n.fs.WithContext(ctx).Readlink(n.userPath())
+	if err != nil {
+		return nil, fuseErrno(err)
+	}
+	return []byte(target), 0
+}
+
+// Link creates the hard link name to the file target
+func (n *fuseNode) Link(ctx context.Context, target fusefs.InodeEmbedder, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	fs := n.fs.WithContext(ctx)
+	targetNode := target.EmbeddedInode().Operations().(*fuseNode)
+	userPath := n.childPath(name)
+
+	if err := fs.Link(targetNode.userPath(), userPath); err != nil {
+		return nil, fuseErrno(err)
+	}
+	info, err := fs.Lstat(userPath)
+	if err != nil {
+		return nil, fuseErrno(err)
+	}
+	targetNode.fillAttr(info, &out.Attr)
+	return target.EmbeddedInode(), 0
+}
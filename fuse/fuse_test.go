@@ -0,0 +1,179 @@
+//go:build linux
+
+package fuse
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mountFUSE mounts fs in a temporary directory, skipping the test where FUSE is not
+// available
+func mountFUSE(t *testing.T, fs *grainfs.GrainFS) string {
+	t.Helper()
+	device, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("FUSE is not available: %v", err)
+	}
+	device.Close()
+
+	mountpoint := t.TempDir()
+	server, err := Mount(fs, mountpoint, &fusefs.Options{
+		MountOptions: fuse.MountOptions{DirectMount: true},
+	})
+	if err != nil {
+		t.Skipf("Failed to mount: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := server.Unmount(); err != nil {
+			t.Errorf("Unmount failed: %v", err)
+		}
+		server.Wait()
+	})
+	return mountpoint
+}
+
+func TestMount(t *testing.T) {
+	fs, err := grainfs.New(osfs.New(t.TempDir()), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "docs/hello.txt", []byte("hello, world"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	mnt := mountFUSE(t, fs)
+
+	if content, err := os.ReadFile(filepath.Join(mnt, "docs", "hello.txt")); err != nil || string(content) != "hello, world" {
+		t.Fatalf("Expected to read the decrypted content, got %q: %v", content, err)
+	}
+
+	// Writes at any offset are stored when the file is closed
+	if err := os.Mkdir(filepath.Join(mnt, "up"), 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(mnt, "up", "a.txt"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("hello,"), 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	if n, err := file.ReadAt(buf, 6); n != 5 || string(buf) != "world" {
+		t.Fatalf("Expected to read world at 6, got %q: %v", buf[:n], err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "up/a.txt"); err != nil || string(content) != "hello,world" {
+		t.Fatalf("Expected the written content, got %q: %v", content, err)
+	}
+	if info, err := fs.Stat("up/a.txt"); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected mode 0600, got %+v: %v", info, err)
+	}
+	if _, err := os.OpenFile(filepath.Join(mnt, "up", "a.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Fatalf("Expected an exclusive create of an existing file to fail with exist, got %v", err)
+	}
+
+	// Setattr maps to truncate, chmod and chtimes
+	if err := os.Truncate(filepath.Join(mnt, "up", "a.txt"), 5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(mnt, "up", "a.txt"), 0640); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(mnt, "up", "a.txt"), mtime, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "up/a.txt"); err != nil || string(content) != "hello" {
+		t.Fatalf("Expected the truncated content, got %q: %v", content, err)
+	}
+	info, err := fs.Stat("up/a.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mode 0640 and time %v, got %v %v", mtime, info.Mode(), info.ModTime())
+	}
+	if info, err := os.Stat(filepath.Join(mnt, "up", "a.txt")); err != nil || info.Size() != 5 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected 5 bytes modified at %v, got %+v: %v", mtime, info, err)
+	}
+
+	if err := os.Rename(filepath.Join(mnt, "docs", "hello.txt"), filepath.Join(mnt, "up", "b.txt")); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "up/b.txt"); err != nil || string(content) != "hello, world" {
+		t.Fatalf("Expected the renamed content, got %q: %v", content, err)
+	}
+	if err := os.Symlink("b.txt", filepath.Join(mnt, "up", "link")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(mnt, "up", "link")); err != nil || target != "b.txt" {
+		t.Fatalf("Expected the link to point at b.txt, got %q: %v", target, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(mnt, "up", "link")); err != nil || string(content) != "hello, world" {
+		t.Fatalf("Expected to read through the link, got %q: %v", content, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(mnt, "up"))
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "b.txt" || names[2] != "link" {
+		t.Fatalf("Expected a.txt, b.txt and link, got %v", names)
+	}
+
+	if err := os.Remove(filepath.Join(mnt, "up")); err == nil {
+		t.Fatalf("Expected removing a non-empty directory to fail")
+	}
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(mnt, "up", name)); err != nil {
+			t.Fatalf("Remove %s failed: %v", name, err)
+		}
+	}
+	if err := os.Remove(filepath.Join(mnt, "up")); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := fs.Stat("up"); !os.IsNotExist(err) {
+		t.Fatalf("Expected up to be removed, got %v", err)
+	}
+}
+
+func TestMountReadOnly(t *testing.T) {
+	fs, err := grainfs.New(osfs.New(t.TempDir()), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	mnt := mountFUSE(t, fs.ReadOnly())
+
+	if content, err := os.ReadFile(filepath.Join(mnt, "a.txt")); err != nil || string(content) != "alpha" {
+		t.Fatalf("Expected to read alpha, got %q: %v", content, err)
+	}
+	if err := os.WriteFile(filepath.Join(mnt, "b.txt"), []byte("beta"), 0644); err == nil {
+		t.Fatalf("Expected creating a file to fail")
+	}
+	if err := os.Remove(filepath.Join(mnt, "a.txt")); err == nil {
+		t.Fatalf("Expected removing a file to fail")
+	}
+}
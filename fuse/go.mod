@@ -0,0 +1,17 @@
+module github.com/NovaCove/grainfs/fuse
+
+go 1.24.3
+
+require (
+	github.com/NovaCove/grainfs v0.0.0
+	github.com/go-git/go-billy/v5 v5.6.2
+	github.com/hanwen/go-fuse/v2 v2.9.0
+)
+
+require (
+	github.com/cyphar/filepath-securejoin v0.4.1 // indirect
+	golang.org/x/crypto v0.32.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+)
+
+replace github.com/NovaCove/grainfs => ..
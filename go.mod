@@ -4,7 +4,7 @@ go 1.24.3
 
 require (
 	github.com/go-git/go-billy/v5 v5.6.2
-	golang.org/x/crypto v0.31.0
+	golang.org/x/crypto v0.32.0
 )
 
 require (
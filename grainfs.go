@@ -1,11 +1,12 @@
 package grainfs
 
 import (
+	"context"
+	"crypto/cipher"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"github.com/go-git/go-billy/v5"
 )
@@ -13,15 +14,34 @@ import (
 // GrainFS implements an encrypted filesystem that wraps any billy.Filesystem
 type GrainFS struct {
 	underlying     billy.Filesystem
-	masterKey      []byte
+	volume         billy.Filesystem
+	volumePrefix   string
+	aead           cipher.AEAD
+	config         *Config
 	filenameKey    []byte
 	rootPath       string
 	filemapManager *FilemapManager
-	mutex          sync.RWMutex
+	locks          *lockManager
+	ctx            context.Context
+	readOnly       bool
+}
+
+// Options configures how a GrainFS is opened
+type Options struct {
+	// ReadOnly opens the filesystem without ever writing to the underlying
+	// filesystem. The store must already exist, and all mutating operations fail with
+	// ErrReadOnly.
+	ReadOnly bool
 }
 
 // New creates a new GrainFS instance with the given underlying filesystem and password
 func New(underlying billy.Filesystem, password string) (*GrainFS, error) {
+	return NewWithOptions(underlying, password, Options{})
+}
+
+// NewWithOptions creates a new GrainFS instance with the given underlying filesystem,
+// password and options
+func NewWithOptions(underlying billy.Filesystem, password string, opts Options) (*GrainFS, error) {
 	if underlying == nil {
 		return nil, fmt.Errorf("underlying filesystem cannot be nil")
 	}
@@ -30,8 +50,12 @@ func New(underlying billy.Filesystem, password string) (*GrainFS, error) {
 	}
 
 	fs := &GrainFS{
-		underlying: underlying,
-		rootPath:   ".",
+		underlying:   underlying,
+		volume:       underlying,
+		volumePrefix: ".",
+		rootPath:     ".",
+		locks:        newLockManager(),
+		readOnly:     opts.ReadOnly,
 	}
 
 	// Load or create configuration
@@ -41,7 +65,12 @@ func New(underlying billy.Filesystem, password string) (*GrainFS, error) {
 	}
 
 	// Derive keys from password and salt
-	fs.masterKey, fs.filenameKey = deriveKeys(password, config.Salt, config.Iterations)
+	var masterKey []byte
+	masterKey, fs.filenameKey = deriveKeys(password, config)
+	if fs.aead, err = newAEAD(config.cipher(), masterKey); err != nil {
+		return nil, err
+	}
+	fs.config = config
 
 	// Initialize filemap manager
 	fs.filemapManager = NewFilemapManager(fs)
@@ -57,6 +86,7 @@ var (
 	_ billy.Symlink    = (*GrainFS)(nil)
 	_ billy.Chroot     = (*GrainFS)(nil)
 	_ billy.TempFile   = (*GrainFS)(nil)
+	_ billy.Change     = (*GrainFS)(nil)
 )
 
 // Basic interface implementation
@@ -72,11 +102,8 @@ func (fs *GrainFS) Open(filename string) (billy.File, error) {
 }
 
 func (fs *GrainFS) Write(filename string, data []byte) (n int, err error) {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
-
 	// Open the file in write mode
-	file, err := fs.openFileInternal(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	file, err := fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return 0, fmt.Errorf("failed to open file for writing: %w", err)
 	}
@@ -91,25 +118,32 @@ func (fs *GrainFS) Write(filename string, data []byte) (n int, err error) {
 	return n, nil
 }
 
-// OpenFile opens a file with the specified flag and perm
+// OpenFile opens a file with the specified flag and perm. Only opens that may create
+// the file lock its directory, and only until the file is opened.
 func (fs *GrainFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
-
-	return fs.openFileInternal(filename, flag, perm)
-}
-
-// openFileInternal is the internal implementation that doesn't acquire locks
-func (fs *GrainFS) openFileInternal(filename string, flag int, perm os.FileMode) (billy.File, error) {
 	if filename == "" {
 		return nil, fmt.Errorf("filename cannot be empty")
 	}
+	if flag&writeFlags != 0 {
+		if err := fs.checkWritable("open", filename); err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
+
+	// Follow symbolic links to the entry they designate
+	filename, err := fs.resolveSymlinks(filename, true)
+	if err != nil {
+		return nil, err
+	}
 
 	// For file creation, we need to ensure the filemap is updated
 	isCreating := (flag & os.O_CREATE) != 0
 
 	var obfuscatedPath string
-	var err error
+	var dir, obfuscatedBasename string
 
 	// NOTE(ttacon): Do we want to optimistically add directories? It's non-standard but might be
 	// nice.
@@ -129,25 +163,27 @@ func (fs *GrainFS) openFileInternal(filename string, flag int, perm os.FileMode)
 
 	if isCreating {
 		// When creating, use obfuscateFilename to update the filemap
-		dir := filepath.Dir(filename)
-		if dir == filename {
-			dir = "."
-		}
-		basename := filepath.Base(filename)
+		var basename string
+		dir, basename = splitUserPath(filename)
 
-		// Ensure directory exists and get its obfuscated path
-		if dir != "." {
-			if err := fs.mkdirAllInternal(dir, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create directory: %w", err)
-			}
+		// Ensure directory exists and lock it while the file is added
+		unlock, err := fs.lockDirForCreate(dir)
+		if err != nil {
+			return nil, err
 		}
+		defer unlock()
 
-		obfuscatedDir, err := fs.getObfuscatedPath(dir)
+		obfuscatedDir, err := fs.getObfuscatedPathCtx(dir)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get obfuscated directory path: %w", err)
 		}
 
-		obfuscatedBasename, err := fs.obfuscateFilename(dir, basename)
+		// Once the file is added, it is opened regardless of the context
+		if err := fs.contextErr(); err != nil {
+			return nil, err
+		}
+
+		obfuscatedBasename, err = fs.obfuscateFilename(dir, basename)
 		if err != nil {
 			return nil, fmt.Errorf("failed to obfuscate filename: %w", err)
 		}
@@ -157,7 +193,7 @@ func (fs *GrainFS) openFileInternal(filename string, flag int, perm os.FileMode)
 		// DIDNTDO(ttacon): this should fail if the directory doesn't exist
 
 		// For opening existing files, just get the path without updating filemap
-		obfuscatedPath, err = fs.getObfuscatedPath(filename)
+		obfuscatedPath, err = fs.getObfuscatedPathCtx(filename)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
 		}
@@ -169,14 +205,49 @@ func (fs *GrainFS) openFileInternal(filename string, flag int, perm os.FileMode)
 		return nil, err
 	}
 
+	if !isCreating {
+		dir, _ = splitUserPath(filename)
+		obfuscatedBasename = filepath.Base(obfuscatedPath)
+	}
+
+	md, err := fs.getMetadata(dir, obfuscatedBasename)
+	if err != nil {
+		underlyingFile.Close()
+		return nil, fmt.Errorf("failed to load file metadata: %w", err)
+	}
+
+	// Record metadata for newly created files
+	if isCreating && md == nil {
+		if err := fs.setMetadata(dir, obfuscatedBasename, newFileMetadata(perm&^os.ModeType)); err != nil {
+			underlyingFile.Close()
+			return nil, fmt.Errorf("failed to record file metadata: %w", err)
+		}
+	}
+
+	// Linked files keep their content in the shared object store; the entry itself is
+	// only a placeholder
+	storage := fs.underlying
+	if md != nil && md.Object != "" {
+		underlyingFile.Close()
+
+		storage = fs.volume
+		obfuscatedPath = objectPath(md.Object)
+		underlyingFile, err = storage.OpenFile(obfuscatedPath, flag&^(os.O_CREATE|os.O_EXCL), perm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Create encrypted file wrapper
 	encFile := &EncryptedFile{
 		underlying:  underlyingFile,
+		storage:     storage,
 		fs:          fs,
 		filename:    filename,
 		obfuscated:  obfuscatedPath,
 		flag:        flag,
 		isWriteMode: (flag&os.O_WRONLY) != 0 || (flag&os.O_RDWR) != 0,
+		modified:    flag&os.O_TRUNC != 0,
 	}
 
 	return encFile, nil
@@ -184,10 +255,16 @@ func (fs *GrainFS) openFileInternal(filename string, flag int, perm os.FileMode)
 
 // Stat returns file information
 func (fs *GrainFS) Stat(filename string) (os.FileInfo, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
 
-	obfuscatedPath, err := fs.getObfuscatedPath(filename)
+	resolved, err := fs.resolveSymlinks(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPathCtx(resolved)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
 	}
@@ -197,34 +274,59 @@ func (fs *GrainFS) Stat(filename string) (os.FileInfo, error) {
 		return nil, err
 	}
 
-	// Return a wrapped FileInfo that shows the original filename
-	return &FileInfoWrapper{
-		FileInfo:     info,
-		originalName: filepath.Base(filename),
-	}, nil
+	// Return a wrapped FileInfo that shows the original filename and metadata
+	dir, _ := splitUserPath(resolved)
+	_, base := splitUserPath(filename)
+	return fs.wrapFileInfo(info, base, dir, filepath.Base(obfuscatedPath))
 }
 
 // Rename renames a file
 func (fs *GrainFS) Rename(oldpath, newpath string) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
-
 	if oldpath == "" || newpath == "" {
 		return fmt.Errorf("paths cannot be empty")
 	}
+	if fs.readOnly {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrReadOnly}
+	}
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+
+	// Rename links themselves, not what they point to
+	oldpath, err := fs.resolveSymlinks(oldpath, false)
+	if err != nil {
+		return err
+	}
+	newpath, err = fs.resolveSymlinks(newpath, false)
+	if err != nil {
+		return err
+	}
+
+	oldDir, _ := splitUserPath(oldpath)
+	newDir, newBaseName := splitUserPath(newpath)
+
+	// Lock both directories, and both paths so that nothing below them changes while
+	// they are moved
+	unlock := fs.lockPaths([]string{oldDir, newDir}, []string{oldpath, newpath})
+	defer unlock()
 
 	// Get obfuscated paths
-	oldObfuscated, err := fs.getObfuscatedPath(oldpath)
+	oldObfuscated, err := fs.getObfuscatedPathCtx(oldpath)
 	if err != nil {
 		return fmt.Errorf("failed to get old obfuscated path: %w", err)
 	}
 
-	// For the new path, we need to handle the case where it might not exist yet
-	newDir := filepath.Dir(newpath)
-	if newDir == newpath {
-		newDir = "."
+	// Get the obfuscated directory for the new file
+	newObfuscatedDir, err := fs.getObfuscatedPathCtx(newDir)
+	if err != nil {
+		return fmt.Errorf("failed to get new obfuscated directory: %w", err)
+	}
+
+	// Once the new name is registered, the rename is carried through regardless of the
+	// context
+	if err := fs.contextErr(); err != nil {
+		return err
 	}
-	newBaseName := filepath.Base(newpath)
 
 	// Get obfuscated name for the new file
 	newObfuscated, err := fs.obfuscateFilename(newDir, newBaseName)
@@ -232,12 +334,6 @@ func (fs *GrainFS) Rename(oldpath, newpath string) error {
 		return fmt.Errorf("failed to obfuscate new filename: %w", err)
 	}
 
-	// Get the full obfuscated path for the new file
-	newObfuscatedDir, err := fs.getObfuscatedPath(newDir)
-	if err != nil {
-		return fmt.Errorf("failed to get new obfuscated directory: %w", err)
-	}
-
 	newObfuscatedPath := filepath.Join(newObfuscatedDir, newObfuscated)
 
 	// Perform the rename on the underlying filesystem
@@ -245,32 +341,68 @@ func (fs *GrainFS) Rename(oldpath, newpath string) error {
 		return err
 	}
 
-	// Update filemaps
-	oldDir := filepath.Dir(oldpath)
-	if oldDir == oldpath {
-		oldDir = "."
-	}
-
 	// Remove from old filemap
 	oldObfuscatedBase := filepath.Base(oldObfuscated)
-	if err := fs.removeFromFilemap(oldDir, oldObfuscatedBase); err != nil {
-		// Try to revert the rename if filemap update fails
-		fs.underlying.Rename(newObfuscatedPath, oldObfuscated)
-		return fmt.Errorf("failed to update old filemap: %w", err)
+	if oldDir != newDir || oldObfuscatedBase != newObfuscated {
+		if err := fs.removeFromFilemap(oldDir, oldObfuscatedBase); err != nil {
+			// Try to revert the rename if filemap update fails
+			fs.underlying.Rename(newObfuscatedPath, oldObfuscated)
+			return fmt.Errorf("failed to update old filemap: %w", err)
+		}
 	}
 
-	// Add to new filemap (this was already done in obfuscateFilename)
-	return nil
+	// Add to new filemap (this was already done in obfuscateFilename), and forget any
+	// cached state for the subtrees that moved
+	fs.filemapManager.invalidate(fs.cacheKey(oldpath))
+	fs.filemapManager.invalidate(fs.cacheKey(newpath))
+
+	return fs.moveMetadata(oldDir, oldObfuscatedBase, newDir, newObfuscated)
+}
+
+// moveMetadata moves the metadata record of a renamed entry to its new location
+func (fs *GrainFS) moveMetadata(oldDir, oldObfuscated, newDir, newObfuscated string) error {
+	if oldDir == newDir && oldObfuscated == newObfuscated {
+		return nil
+	}
+
+	md, err := fs.getMetadata(oldDir, oldObfuscated)
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	// Drop any record left by an entry the rename replaced
+	if err := fs.dropMetadata(newDir, newObfuscated); err != nil {
+		return err
+	}
+
+	if md == nil {
+		return nil
+	}
+
+	if err := fs.setMetadata(newDir, newObfuscated, md); err != nil {
+		return fmt.Errorf("failed to update new metadata: %w", err)
+	}
+	return fs.removeMetadata(oldDir, oldObfuscated)
 }
 
 // Remove removes a file
 func (fs *GrainFS) Remove(filename string) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
-
 	if filename == "" {
 		return fmt.Errorf("filename cannot be empty")
 	}
+	if err := fs.checkWritable("remove", filename); err != nil {
+		return err
+	}
+
+	// Remove links themselves, not what they point to
+	filename, err := fs.resolveSymlinks(filename, false)
+	if err != nil {
+		return err
+	}
+
+	dir, _ := splitUserPath(filename)
+	unlock := fs.lockPaths([]string{dir}, []string{filename})
+	defer unlock()
 
 	obfuscatedPath, err := fs.getObfuscatedPath(filename)
 	if err != nil {
@@ -282,12 +414,12 @@ func (fs *GrainFS) Remove(filename string) error {
 		// Special case for removing directories - if the directory is not empty, it will fail
 		// But this will always happen due to our filemap management. So see if this is a directory
 		// that is empty besides the .grainfs directory.
-		if !strings.Contains(err.Error(), "directory not empty") {
-			return err
+		origErr := err
+		if info, err := fs.underlying.Stat(obfuscatedPath); err != nil || !info.IsDir() {
+			return origErr
 		}
 
 		// Check if the directory is empty except for the .grainfs directory
-		origErr := err
 		infos, err := fs.underlying.ReadDir(obfuscatedPath)
 		if err != nil {
 			return origErr
@@ -309,18 +441,35 @@ func (fs *GrainFS) Remove(filename string) error {
 			// If it still fails, return the error
 			return err
 		}
+	}
 
-		return nil
+	// Update filemap and metadata
+	obfuscatedBase := filepath.Base(obfuscatedPath)
+
+	if err := fs.removeFromFilemap(dir, obfuscatedBase); err != nil {
+		return err
 	}
+	fs.filemapManager.invalidate(fs.cacheKey(filename))
 
-	// Update filemap
-	dir := filepath.Dir(filename)
-	if dir == filename {
-		dir = "."
+	return fs.dropMetadata(dir, obfuscatedBase)
+}
+
+// dropMetadata removes the metadata record of a removed entry, releasing its reference
+// to a shared content object if it was linked
+func (fs *GrainFS) dropMetadata(dir, obfuscated string) error {
+	md, err := fs.getMetadata(dir, obfuscated)
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	if err := fs.removeMetadata(dir, obfuscated); err != nil {
+		return err
 	}
-	obfuscatedBase := filepath.Base(obfuscatedPath)
 
-	return fs.removeFromFilemap(dir, obfuscatedBase)
+	if md != nil && md.Object != "" {
+		return fs.unlinkObject(md.Object)
+	}
+	return nil
 }
 
 func (fs *GrainFS) purgeGrainFSSubDir(path string) error {
@@ -349,26 +498,35 @@ func (fs *GrainFS) Join(elem ...string) string {
 
 // ReadDir reads the directory and returns file information
 func (fs *GrainFS) ReadDir(path string) ([]os.FileInfo, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
-
 	if path == "" {
 		path = "."
 	}
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
 
-	obfuscatedPath, err := fs.getObfuscatedPath(path)
+	path, err := fs.resolveSymlinks(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPathCtx(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
 	}
 
 	// Read the underlying directory
-	infos, err := fs.underlying.ReadDir(obfuscatedPath)
+	infos, err := fs.readUnderlyingDir(obfuscatedPath)
 	if err != nil {
 		return nil, err
 	}
 
 	var result []os.FileInfo
 	for _, info := range infos {
+		if err := fs.contextErr(); err != nil {
+			return nil, err
+		}
+
 		// Skip .grainfs directories
 		if info.Name() == GrainFSDir {
 			continue
@@ -381,10 +539,10 @@ func (fs *GrainFS) ReadDir(path string) ([]os.FileInfo, error) {
 			continue
 		}
 
-		// Wrap the FileInfo to show the original name
-		wrappedInfo := &FileInfoWrapper{
-			FileInfo:     info,
-			originalName: originalName,
+		// Wrap the FileInfo to show the original name and metadata
+		wrappedInfo, err := fs.wrapFileInfo(info, originalName, path, info.Name())
+		if err != nil {
+			return nil, err
 		}
 		result = append(result, wrappedInfo)
 	}
@@ -392,15 +550,40 @@ func (fs *GrainFS) ReadDir(path string) ([]os.FileInfo, error) {
 	return result, nil
 }
 
+// maxReadDirAttempts bounds how often listing a directory is retried
+const maxReadDirAttempts = 10
+
+// readUnderlyingDir lists an obfuscated directory. Backends such as osfs stat each entry
+// after listing the directory and fail if one was removed in between, so the listing is
+// retried as long as the directory itself still exists.
+func (fs *GrainFS) readUnderlyingDir(obfuscatedPath string) ([]os.FileInfo, error) {
+	for attempt := 1; ; attempt++ {
+		infos, err := fs.underlying.ReadDir(obfuscatedPath)
+		if err == nil || !os.IsNotExist(err) || attempt == maxReadDirAttempts {
+			return infos, err
+		}
+		if _, statErr := fs.underlying.Stat(obfuscatedPath); statErr != nil {
+			return nil, err
+		}
+	}
+}
+
 // MkdirAll creates directories recursively
 func (fs *GrainFS) MkdirAll(path string, perm os.FileMode) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
+	if err := fs.checkWritable("mkdir", path); err != nil {
+		return err
+	}
+
+	path, err := fs.resolveSymlinks(path, true)
+	if err != nil {
+		return err
+	}
 
 	return fs.mkdirAllInternal(path, perm)
 }
 
-// mkdirAllInternal is the internal implementation that doesn't acquire locks
+// mkdirAllInternal creates the directories of a resolved user path, locking each
+// parent while the next level is added to it
 func (fs *GrainFS) mkdirAllInternal(path string, perm os.FileMode) error {
 	if path == "" || path == "." {
 		return nil
@@ -410,6 +593,7 @@ func (fs *GrainFS) mkdirAllInternal(path string, perm os.FileMode) error {
 	// Split the path and create each directory level
 	parts := strings.Split(filepath.Clean(path), string(filepath.Separator))
 	currentPath := ""
+	parentPath := "."
 
 	for _, part := range parts {
 		if part == "" || part == "." {
@@ -422,110 +606,159 @@ func (fs *GrainFS) mkdirAllInternal(path string, perm os.FileMode) error {
 			currentPath = filepath.Join(currentPath, part)
 		}
 
-		// Get obfuscated path for this level
-		obfuscatedPath, err := fs.getObfuscatedPath(currentPath)
-		if err != nil {
-			return fmt.Errorf("failed to get obfuscated path for %s: %w", currentPath, err)
+		if err := fs.mkdirLevel(parentPath, part, perm); err != nil {
+			return err
 		}
 
-		// Create the directory if it doesn't exist
-		if err := fs.underlying.MkdirAll(obfuscatedPath, perm); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", obfuscatedPath, err)
-		}
+		parentPath = currentPath
+	}
+
+	return nil
+}
+
+// mkdirLevel creates the directory name inside the existing user directory parent if
+// it doesn't exist yet
+func (fs *GrainFS) mkdirLevel(parent, name string, perm os.FileMode) error {
+	unlock := fs.lockDir(parent)
+	defer unlock()
+
+	return fs.mkdirLevelLocked(parent, name, perm)
+}
+
+// mkdirLevelLocked is mkdirLevel for callers already holding the lock of parent
+func (fs *GrainFS) mkdirLevelLocked(parent, name string, perm os.FileMode) error {
+	currentPath := filepath.Join(parent, name)
+
+	// Get obfuscated path for this level
+	obfuscatedPath, err := fs.getObfuscatedPath(currentPath)
+	if err != nil {
+		return fmt.Errorf("failed to get obfuscated path for %s: %w", currentPath, err)
+	}
+
+	// Create the directory if it doesn't exist
+	if err := fs.underlying.MkdirAll(obfuscatedPath, perm); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", obfuscatedPath, err)
+	}
 
-		// Initialize .grainfs directory for this level
-		if err := fs.ensureGrainFSDir(currentPath); err != nil {
-			return fmt.Errorf("failed to ensure .grainfs directory for %s: %w", currentPath, err)
+	// Initialize .grainfs directory for this level
+	if err := fs.ensureGrainFSDir(currentPath); err != nil {
+		return fmt.Errorf("failed to ensure .grainfs directory for %s: %w", currentPath, err)
+	}
+
+	// Register this level in its parent's filemap and record its metadata
+	obfuscatedName, err := fs.obfuscateFilename(parent, name)
+	if err != nil {
+		return fmt.Errorf("failed to register directory %s: %w", currentPath, err)
+	}
+
+	md, err := fs.getMetadata(parent, obfuscatedName)
+	if err == nil && md == nil {
+		err = fs.setMetadata(parent, obfuscatedName, newFileMetadata(os.ModeDir|perm.Perm()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record metadata for %s: %w", currentPath, err)
+	}
+
+	return nil
+}
+
+// dirExists reports whether the user path dir is an existing directory
+func (fs *GrainFS) dirExists(dir string) (bool, error) {
+	obfuscatedPath, err := fs.getObfuscatedPath(dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+
+	info, err := fs.underlying.Stat(obfuscatedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
 		}
+		return false, err
+	}
+	if !info.IsDir() {
+		return false, fmt.Errorf("not a directory: %s", dir)
 	}
 
-	pathName := filepath.Dir(path)
-	dirName := filepath.Base(path)
-	_, err := fs.obfuscateFilename(pathName, dirName)
-	return err
+	return true, nil
 }
 
 // Symlink interface implementation
+//
+// Symbolic links are stored as small encrypted objects holding the verbatim target,
+// flagged as links in their metadata record, and resolved by GrainFS itself. See
+// symlink.go.
 
 // Lstat returns file info without following symlinks
 func (fs *GrainFS) Lstat(filename string) (os.FileInfo, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
 
-	obfuscatedPath, err := fs.getObfuscatedPath(filename)
+	resolved, err := fs.resolveSymlinks(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPathCtx(resolved)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
 	}
 
-	if symlinkFS, ok := fs.underlying.(billy.Symlink); ok {
-		info, err := symlinkFS.Lstat(obfuscatedPath)
-		if err != nil {
-			return nil, err
-		}
-		return &FileInfoWrapper{
-			FileInfo:     info,
-			originalName: filepath.Base(filename),
-		}, nil
+	info, err := fs.lstatUnderlying(obfuscatedPath)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fallback to regular Stat if Symlink interface not supported
-	return fs.Stat(filename)
+	dir, base := splitUserPath(resolved)
+	return fs.wrapFileInfo(info, base, dir, filepath.Base(obfuscatedPath))
 }
 
-// Symlink creates a symbolic link
+// Symlink creates a symbolic link. The target is stored verbatim, may be absolute or
+// relative to the link's directory, and does not need to exist.
 func (fs *GrainFS) Symlink(target, link string) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
-
-	symlinkFS, ok := fs.underlying.(billy.Symlink)
-	if !ok {
-		return fmt.Errorf("underlying filesystem does not support symlinks")
+	if target == "" || link == "" {
+		return fmt.Errorf("paths cannot be empty")
 	}
-
-	// Get obfuscated paths
-	obfuscatedTarget, err := fs.getObfuscatedPath(target)
-	if err != nil {
-		return fmt.Errorf("failed to get obfuscated target path: %w", err)
+	if fs.readOnly {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: ErrReadOnly}
 	}
 
-	obfuscatedLink, err := fs.getObfuscatedPath(link)
+	resolved, err := fs.resolveSymlinks(link, false)
 	if err != nil {
-		return fmt.Errorf("failed to get obfuscated link path: %w", err)
+		return err
 	}
 
-	return symlinkFS.Symlink(obfuscatedTarget, obfuscatedLink)
+	return fs.createSymlink(target, resolved)
 }
 
-// Readlink reads the target of a symbolic link
+// Readlink returns the target of a symbolic link exactly as it was given to Symlink
 func (fs *GrainFS) Readlink(link string) (string, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
-
-	symlinkFS, ok := fs.underlying.(billy.Symlink)
-	if !ok {
-		return "", fmt.Errorf("underlying filesystem does not support symlinks")
-	}
-
-	obfuscatedLink, err := fs.getObfuscatedPath(link)
+	resolved, err := fs.resolveSymlinks(link, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to get obfuscated link path: %w", err)
+		return "", err
 	}
 
-	obfuscatedTarget, err := symlinkFS.Readlink(obfuscatedLink)
+	dir, base := splitUserPath(resolved)
+	target, isLink, err := fs.symlinkTarget(dir, base)
 	if err != nil {
 		return "", err
 	}
+	if isLink {
+		return target, nil
+	}
 
-	// Convert back to user path
-	return fs.getUserPath(obfuscatedTarget)
+	return fs.readLegacySymlink(link, resolved)
 }
 
 // Chroot interface implementation
 
 // Chroot creates a new filesystem rooted at the given path
 func (fs *GrainFS) Chroot(path string) (billy.Filesystem, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	path, err := fs.resolveSymlinks(path, true)
+	if err != nil {
+		return nil, err
+	}
 
 	if path == "" {
 		path = "."
@@ -548,12 +781,19 @@ func (fs *GrainFS) Chroot(path string) (billy.Filesystem, error) {
 
 	// Create a new GrainFS instance with the chrooted filesystem
 	newFS := &GrainFS{
-		underlying:  underlyingChroot,
-		masterKey:   fs.masterKey,
-		filenameKey: fs.filenameKey,
-		rootPath:    filepath.Join(fs.rootPath, path),
+		underlying:   underlyingChroot,
+		volume:       fs.volume,
+		volumePrefix: filepath.Join(fs.volumePrefix, obfuscatedPath),
+		aead:         fs.aead,
+		config:       fs.config,
+		filenameKey:  fs.filenameKey,
+		rootPath:     filepath.Join(fs.rootPath, path),
+		// Views of the same volume share cached filemaps and locks, keyed by volume path
+		filemapManager: fs.filemapManager,
+		locks:          fs.locks,
+		ctx:            fs.ctx,
+		readOnly:       fs.readOnly,
 	}
-	newFS.filemapManager = NewFilemapManager(newFS)
 
 	return newFS, nil
 }
@@ -567,8 +807,9 @@ func (fs *GrainFS) Root() string {
 
 // TempFile creates a temporary file
 func (fs *GrainFS) TempFile(dir, prefix string) (billy.File, error) {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
+	if err := fs.checkWritable("open", dir); err != nil {
+		return nil, err
+	}
 
 	tempFS, ok := fs.underlying.(billy.TempFile)
 	if !ok {
@@ -579,6 +820,11 @@ func (fs *GrainFS) TempFile(dir, prefix string) (billy.File, error) {
 		dir = "."
 	}
 
+	dir, err := fs.resolveSymlinks(dir, true)
+	if err != nil {
+		return nil, err
+	}
+
 	obfuscatedDir, err := fs.getObfuscatedPath(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get obfuscated directory: %w", err)
@@ -600,6 +846,7 @@ func (fs *GrainFS) TempFile(dir, prefix string) (billy.File, error) {
 	// Create encrypted file wrapper
 	encFile := &EncryptedFile{
 		underlying:  underlyingFile,
+		storage:     fs.underlying,
 		fs:          fs,
 		filename:    filepath.Join(dir, originalTempName),
 		obfuscated:  underlyingFile.Name(),
@@ -0,0 +1,423 @@
+package grainfs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxDownloadMemory is the memory downloads may hold at once, unless
+// HTTPOptions.MaxDownloadMemory says otherwise
+const DefaultMaxDownloadMemory = 256 << 20
+
+// HTTPOptions configures the handler returned by HTTPHandler
+type HTTPOptions struct {
+	// AllowWrite accepts PUT requests, which store the request body as a file, creating
+	// its parent directories, or create a directory for paths ending in a slash, and
+	// DELETE requests, which remove files and empty directories
+	AllowWrite bool
+
+	// MaxUploadSize limits the size of PUT bodies, which are held in memory while they
+	// are encrypted; 0 means no limit
+	MaxUploadSize int64
+
+	// MaxDownloadMemory limits the memory held by the downloads in progress, counted as
+	// the size of their files, since file content is encrypted as a whole: every
+	// download, Range requests included, decrypts the entire file into memory.
+	// Downloads wait while the limit is reached, and files larger than it are refused
+	// with 507 Insufficient Storage. 0 means DefaultMaxDownloadMemory, and a negative
+	// value no limit.
+	MaxDownloadMemory int64
+
+	// Username and Password, if Username is set, require HTTP basic authentication, as
+	// with BasicAuth
+	Username string
+	Password string
+
+	// OnError, if set, is called with the errors answered with 500 Internal Server
+	// Error, whose details are not sent to the client
+	OnError func(r *http.Request, err error)
+}
+
+// httpEntry is an entry of a directory listing in JSON
+type httpEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// httpHandler serves a GrainFS over HTTP, see HTTPHandler
+type httpHandler struct {
+	fs     *GrainFS
+	opts   HTTPOptions
+	memory *memoryLimit // nil without a limit
+}
+
+// memoryLimit counts the bytes held by downloads against a maximum
+type memoryLimit struct {
+	mutex    sync.Mutex
+	max      int64
+	used     int64
+	released chan struct{} // closed and replaced whenever bytes are released
+}
+
+// acquire waits until size bytes are available and takes them, or until ctx is done
+func (l *memoryLimit) acquire(ctx context.Context, size int64) error {
+	for {
+		l.mutex.Lock()
+		if l.used+size <= l.max {
+			l.used += size
+			l.mutex.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release gives back size bytes taken by acquire
+func (l *memoryLimit) release(size int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.used -= size
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// HTTPHandler returns an http.Handler serving the filesystem: GET and HEAD download
+// files, with support for Range and conditional requests, and list directories, as HTML
+// or as JSON for clients accepting application/json. A download decrypts the whole file
+// into memory, even to serve a small range, within opts.MaxDownloadMemory. Writes are
+// only accepted with opts.AllowWrite, and never on a read-only filesystem. Request
+// paths are relative to the root of the filesystem; use http.StripPrefix to serve it
+// under a prefix.
+func (fs *GrainFS) HTTPHandler(opts HTTPOptions) http.Handler {
+	h := &httpHandler{fs: fs, opts: opts}
+	if opts.MaxDownloadMemory >= 0 {
+		max := opts.MaxDownloadMemory
+		if max == 0 {
+			max = DefaultMaxDownloadMemory
+		}
+		h.memory = &memoryLimit{max: max, released: make(chan struct{})}
+	}
+	if opts.Username != "" {
+		return BasicAuth(h, opts.Username, opts.Password)
+	}
+	return h
+}
+
+// ServeHTTP serves a request
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveGet(w, r, name)
+	case http.MethodPut, http.MethodDelete:
+		if !h.opts.AllowWrite || h.fs.IsReadOnly() {
+			h.methodNotAllowed(w)
+		} else if r.Method == http.MethodPut {
+			h.servePut(w, r, name)
+		} else {
+			h.serveDelete(w, r, name)
+		}
+	default:
+		h.methodNotAllowed(w)
+	}
+}
+
+// BasicAuth wraps handler to require HTTP basic authentication as username with
+// password, answering other requests with 401 Unauthorized
+func BasicAuth(handler http.Handler, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		// Both are compared, so that the time taken does not tell which one is wrong
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username))
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password))
+		if !ok || userOK&passOK != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="grainfs", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// methodNotAllowed answers a request with a method the handler does not accept
+func (h *httpHandler) methodNotAllowed(w http.ResponseWriter) {
+	allow := "GET, HEAD"
+	if h.opts.AllowWrite && !h.fs.IsReadOnly() {
+		allow += ", PUT, DELETE"
+	}
+	w.Header().Set("Allow", allow)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// serveError answers a request with the status matching err
+func (h *httpHandler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	var status int
+	switch {
+	case errors.Is(err, iofs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, iofs.ErrPermission):
+		status = http.StatusForbidden
+	case errors.Is(err, iofs.ErrExist):
+		status = http.StatusConflict
+	case errors.Is(err, iofs.ErrInvalid):
+		status = http.StatusBadRequest
+	default:
+		status = http.StatusInternalServerError
+		if h.opts.OnError != nil {
+			h.opts.OnError(r, err)
+		}
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// serveGet serves the file or directory name
+func (h *httpHandler) serveGet(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := h.fs.IOFS().Open(name)
+	if err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+
+	if !info.IsDir() {
+		// HEAD requests never read the content, so they hold no memory
+		if h.memory != nil && r.Method != http.MethodHead {
+			size := info.Size()
+			if size > h.memory.max {
+				http.Error(w, "file too large to download", http.StatusInsufficientStorage)
+				return
+			}
+			if err := h.memory.acquire(r.Context(), size); err != nil {
+				// The client went away while waiting
+				return
+			}
+			defer h.memory.release(size)
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file.(io.ReadSeeker))
+		return
+	}
+
+	// Relative links in the listing need the directory to end in a slash. The redirect
+	// is relative too, so that it works under http.StripPrefix.
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := path.Base(r.URL.Path) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	entries, err := file.(iofs.ReadDirFile).ReadDir(-1)
+	if err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	listing := make([]httpEntry, 0, len(entries))
+	for _, entry := range entries {
+		entryInfo, err := entry.Info()
+		if err != nil {
+			// Entries that cannot be read are left out
+			continue
+		}
+		listing = append(listing, httpEntry{
+			Name:    entry.Name(),
+			Size:    entryInfo.Size(),
+			Mode:    entryInfo.Mode().String(),
+			ModTime: entryInfo.ModTime(),
+			IsDir:   entry.IsDir(),
+		})
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	title := "/"
+	if name != "." {
+		title += name + "/"
+	}
+	listingTemplate.Execute(w, struct {
+		Path    string
+		Root    bool
+		Entries []httpEntry
+	}{title, name == ".", listing})
+}
+
+// listingTemplate renders directory listings in HTML
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href": func(entry httpEntry) string {
+		// A URL with only a path keeps names such as "a:b" from reading as a scheme
+		href := (&url.URL{Path: entry.Name}).String()
+		if entry.IsDir {
+			href += "/"
+		}
+		return href
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if not .Root}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{href .}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{time .ModTime}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// servePut stores the request body as the file name, or creates the directory name if
+// the request path ends in a slash
+func (h *httpHandler) servePut(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "." {
+		h.methodNotAllowed(w)
+		return
+	}
+	osName := filepath.FromSlash(name)
+
+	if strings.HasSuffix(r.URL.Path, "/") {
+		if info, err := h.fs.Stat(osName); err == nil && !info.IsDir() {
+			h.serveError(w, r, os.ErrExist)
+			return
+		}
+		if err := h.fs.MkdirAll(osName, 0755); err != nil {
+			h.serveError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	status := http.StatusCreated
+	if info, err := h.fs.Stat(osName); err == nil {
+		if info.IsDir() {
+			h.serveError(w, r, os.ErrExist)
+			return
+		}
+		status = http.StatusNoContent
+	}
+
+	body := r.Body
+	if h.opts.MaxUploadSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, h.opts.MaxUploadSize)
+	}
+	if err := h.upload(osName, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.serveError(w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// upload writes body to the file name through a temporary file, so that an interrupted
+// upload leaves any previous content in place
+func (h *httpHandler) upload(name string, body io.Reader) error {
+	dir := filepath.Dir(name)
+	if err := h.fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+filepath.Base(name)+".upload-"+hex.EncodeToString(suffix))
+
+	file, err := h.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		h.fs.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		h.fs.Remove(tmp)
+		return err
+	}
+	if err := h.fs.Rename(tmp, name); err != nil {
+		h.fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// serveDelete removes the file or empty directory name
+func (h *httpHandler) serveDelete(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "." {
+		h.serveError(w, r, os.ErrPermission)
+		return
+	}
+	osName := filepath.FromSlash(name)
+
+	info, err := h.fs.Lstat(osName)
+	if err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	if info.IsDir() {
+		entries, err := h.fs.ReadDir(osName)
+		if err != nil {
+			h.serveError(w, r, err)
+			return
+		}
+		if len(entries) > 0 {
+			http.Error(w, "directory not empty", http.StatusConflict)
+			return
+		}
+	}
+
+	if err := h.fs.Remove(osName); err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
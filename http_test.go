@@ -0,0 +1,215 @@
+package grainfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// httpDo sends a request to handler, returning the response and its body
+func httpDo(t *testing.T, handler http.Handler, method, target, body string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp, string(data)
+}
+
+func TestGrainFSHTTPHandler(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	for name, content := range map[string]string{"hello.txt": "hello, world", "docs/a b.txt": "alpha", "docs/<x>.txt": "x"} {
+		if err := util.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	handler := fs.HTTPHandler(HTTPOptions{})
+
+	resp, body := httpDo(t, handler, "GET", "/hello.txt", "", nil)
+	if resp.StatusCode != http.StatusOK || body != "hello, world" {
+		t.Fatalf("Expected the file content, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = httpDo(t, handler, "GET", "/hello.txt", "", http.Header{"Range": {"bytes=7-"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "world" {
+		t.Fatalf("Expected a partial response, got %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 7-11/12" {
+		t.Fatalf("Expected Content-Range bytes 7-11/12, got %q", got)
+	}
+
+	// Directories redirect to their slashed path, and list their entries escaped
+	if resp, _ := httpDo(t, handler, "GET", "/docs", "", nil); resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "docs/" {
+		t.Fatalf("Expected a redirect to docs/, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, body = httpDo(t, handler, "GET", "/docs/", "", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="a%20b.txt"`) || !strings.Contains(body, "&lt;x&gt;.txt") ||
+		strings.Contains(body, "<x>") {
+		t.Fatalf("Unexpected listing %d:\n%s", resp.StatusCode, body)
+	}
+	resp, body = httpDo(t, handler, "GET", "/docs/", "", http.Header{"Accept": {"application/json"}})
+	var entries []httpEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a JSON listing, got %d %q: %v", resp.StatusCode, body, err)
+	}
+	if len(entries) != 2 || entries[0].Name != "<x>.txt" || entries[1].Name != "a b.txt" || entries[1].Size != 5 {
+		t.Fatalf("Unexpected JSON listing %+v", entries)
+	}
+
+	// Nothing leaks about missing paths, and writes are refused by default
+	if resp, body := httpDo(t, handler, "GET", "/missing", "", nil); resp.StatusCode != http.StatusNotFound || strings.Contains(body, "missing") {
+		t.Fatalf("Expected a bare 404, got %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := httpDo(t, handler, "PUT", "/new.txt", "data", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected PUT to be refused, got %d", resp.StatusCode)
+	}
+	if resp, _ := httpDo(t, fs.ReadOnly().HTTPHandler(HTTPOptions{AllowWrite: true}), "DELETE", "/hello.txt", "", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected DELETE on a read-only filesystem to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestGrainFSHTTPHandlerWrite(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	handler := fs.HTTPHandler(HTTPOptions{AllowWrite: true, MaxUploadSize: 16, Username: "alice", Password: "secret"})
+	auth := http.Header{"Authorization": {"Basic YWxpY2U6c2VjcmV0"}}
+
+	if resp, _ := httpDo(t, handler, "GET", "/", "", nil); resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected a challenge without credentials, got %d", resp.StatusCode)
+	}
+	wrong := http.Header{"Authorization": {"Basic YWxpY2U6d3Jvbmc="}}
+	if resp, _ := httpDo(t, handler, "GET", "/", "", wrong); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a wrong password to be refused, got %d", resp.StatusCode)
+	}
+
+	// Uploads create their parents, and replace existing files
+	if resp, _ := httpDo(t, handler, "PUT", "/docs/new.txt", "first", auth); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected PUT to create the file, got %d", resp.StatusCode)
+	}
+	if resp, _ := httpDo(t, handler, "PUT", "/docs/new.txt", "second", auth); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected PUT to replace the file, got %d", resp.StatusCode)
+	}
+	if content, err := util.ReadFile(fs, "docs/new.txt"); err != nil || string(content) != "second" {
+		t.Fatalf("Expected the uploaded content, got %q: %v", content, err)
+	}
+	if resp, _ := httpDo(t, handler, "PUT", "/docs/big.txt", strings.Repeat("x", 17), auth); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected an oversized upload to be refused, got %d", resp.StatusCode)
+	}
+	if resp, _ := httpDo(t, handler, "PUT", "/docs", "data", auth); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected PUT over a directory to conflict, got %d", resp.StatusCode)
+	}
+	if resp, _ := httpDo(t, handler, "PUT", "/empty/", "", auth); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected PUT with a slash to create a directory, got %d", resp.StatusCode)
+	}
+	// Failed and finished uploads leave no temporary files behind
+	if entries, err := fs.ReadDir("docs"); err != nil || len(entries) != 1 {
+		t.Fatalf("Expected only new.txt in docs, got %d entries: %v", len(entries), err)
+	}
+
+	if resp, _ := httpDo(t, handler, "DELETE", "/docs", "", auth); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected DELETE of a non-empty directory to conflict, got %d", resp.StatusCode)
+	}
+	for _, target := range []string{"/docs/new.txt", "/docs", "/empty"} {
+		if resp, _ := httpDo(t, handler, "DELETE", target, "", auth); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected DELETE %s to succeed, got %d", target, resp.StatusCode)
+		}
+	}
+	if resp, _ := httpDo(t, handler, "DELETE", "/docs", "", auth); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected DELETE of a missing path to fail with 404, got %d", resp.StatusCode)
+	}
+	if resp, _ := httpDo(t, handler, "POST", "/", "", auth); resp.StatusCode != http.StatusMethodNotAllowed ||
+		resp.Header.Get("Allow") != "GET, HEAD, PUT, DELETE" {
+		t.Fatalf("Expected POST to be refused, got %d %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestGrainFSHTTPHandlerDownloadMemory(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	for name, content := range map[string]string{"small.txt": "0123456789", "big.txt": strings.Repeat("x", 17)} {
+		if err := util.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	handler := fs.HTTPHandler(HTTPOptions{MaxDownloadMemory: 16})
+	limit := handler.(*httpHandler).memory
+
+	// Ranges decrypt the whole file too, so a file over the limit is refused whatever
+	// the range, but its headers can still be read
+	if resp, _ := httpDo(t, handler, "GET", "/big.txt", "", http.Header{"Range": {"bytes=0-0"}}); resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("Expected a file over the limit to be refused, got %d", resp.StatusCode)
+	}
+	if resp, _ := httpDo(t, handler, "HEAD", "/big.txt", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected HEAD to succeed, got %d", resp.StatusCode)
+	}
+
+	// A download waits for the memory held by others
+	if err := limit.acquire(context.Background(), 10); err != nil {
+		t.Fatalf("Failed to take memory: %v", err)
+	}
+	done := make(chan string)
+	go func() {
+		req := httptest.NewRequest("GET", "/small.txt", nil)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		done <- recorder.Body.String()
+	}()
+	select {
+	case body := <-done:
+		t.Fatalf("Expected the download to wait, got %q", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+	limit.release(10)
+	if body := <-done; body != "0123456789" {
+		t.Fatalf("Expected the file content once memory was released, got %q", body)
+	}
+	if limit.used != 0 {
+		t.Fatalf("Expected the download to release its memory, %d bytes are held", limit.used)
+	}
+
+	// Waiting stops with the request
+	if err := limit.acquire(context.Background(), 10); err != nil {
+		t.Fatalf("Failed to take memory: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/small.txt", nil).WithContext(ctx)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("Expected a cancelled download to send nothing, got %q", recorder.Body.String())
+	}
+	limit.release(10)
+
+	// A negative limit disables it
+	if handler := fs.HTTPHandler(HTTPOptions{MaxDownloadMemory: -1}); handler.(*httpHandler).memory != nil {
+		t.Fatalf("Expected no limit")
+	}
+}
@@ -0,0 +1,74 @@
+package grainfs
+
+import (
+	"fmt"
+	"os"
+)
+
+// ObfuscatedPath returns the path on the underlying filesystem of the file or directory
+// at the user path path. Symbolic links are not followed. It fails with an error
+// satisfying os.IsNotExist if there is nothing at path.
+func (fs *GrainFS) ObfuscatedPath(path string) (string, error) {
+	if err := fs.contextErr(); err != nil {
+		return "", err
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPathCtx(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+	if _, err := fs.lstatUnderlying(obfuscatedPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", &os.PathError{Op: "obfuscate", Path: path, Err: os.ErrNotExist}
+		}
+		return "", err
+	}
+	return obfuscatedPath, nil
+}
+
+// UserPath returns the user path of the path obfuscated on the underlying filesystem,
+// the reverse of ObfuscatedPath
+func (fs *GrainFS) UserPath(obfuscatedPath string) (string, error) {
+	if err := fs.contextErr(); err != nil {
+		return "", err
+	}
+
+	path, err := fs.getUserPath(obfuscatedPath)
+	if err != nil {
+		return "", &os.PathError{Op: "deobfuscate", Path: obfuscatedPath, Err: err}
+	}
+	return path, nil
+}
+
+// Filemap returns the filemap of the directory at the user path dir, which maps the
+// obfuscated names of its entries to their original names. It is read from disk and
+// may be changed freely.
+func (fs *GrainFS) Filemap(dir string) (FilenameMap, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
+
+	obfuscatedDir, err := fs.getObfuscatedPathCtx(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+	info, err := fs.underlying.Stat(obfuscatedDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &os.PathError{Op: "filemap", Path: dir, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "filemap", Path: dir, Err: fmt.Errorf("not a directory")}
+	}
+
+	filemap, _, err := fs.readFilemap(obfuscatedDir)
+	if err != nil {
+		return nil, err
+	}
+	return filemap, nil
+}
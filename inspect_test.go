@@ -0,0 +1,64 @@
+package grainfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestGrainFSInspect(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if err := util.WriteFile(fs, "docs/nested/report.txt", []byte("secret"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, path := range []string{"docs", "docs/nested", "docs/nested/report.txt"} {
+		obfuscated, err := fs.ObfuscatedPath(path)
+		if err != nil {
+			t.Fatalf("ObfuscatedPath(%s) failed: %v", path, err)
+		}
+		if _, err := underlying.Stat(obfuscated); err != nil {
+			t.Fatalf("Expected %s to exist on disk: %v", obfuscated, err)
+		}
+		user, err := fs.UserPath(obfuscated)
+		if err != nil {
+			t.Fatalf("UserPath(%s) failed: %v", obfuscated, err)
+		}
+		if user != path {
+			t.Fatalf("Expected %s to map back to %s, got %s", obfuscated, path, user)
+		}
+	}
+
+	if _, err := fs.ObfuscatedPath("docs/missing.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Expected a missing path to fail with not exist, got %v", err)
+	}
+	if _, err := fs.UserPath("not-an-obfuscated-name"); err == nil {
+		t.Fatalf("Expected an unknown obfuscated name to fail")
+	}
+
+	filemap, err := fs.Filemap("docs/nested")
+	if err != nil {
+		t.Fatalf("Filemap failed: %v", err)
+	}
+	obfuscated, err := fs.ObfuscatedPath("docs/nested/report.txt")
+	if err != nil {
+		t.Fatalf("ObfuscatedPath failed: %v", err)
+	}
+	if len(filemap) != 1 || filemap[filepath.Base(obfuscated)] != "report.txt" {
+		t.Fatalf("Expected the filemap to hold report.txt, got %v", filemap)
+	}
+	if _, err := fs.Filemap("docs/nested/report.txt"); err == nil {
+		t.Fatalf("Expected Filemap of a file to fail")
+	}
+	if _, err := fs.Filemap("nowhere"); !os.IsNotExist(err) {
+		t.Fatalf("Expected Filemap of a missing directory to fail with not exist, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package grainfs
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// IOFS adapts a GrainFS to the standard library io/fs interfaces, so that it can be
+// served with http.FS, parsed with template.ParseFS or walked with fs.WalkDir. Names
+// follow the io/fs conventions: slash-separated and unrooted, with "." for the root.
+// Errors never reveal obfuscated names.
+type IOFS struct {
+	fs *GrainFS
+}
+
+// Ensure IOFS implements the io/fs interfaces
+var (
+	_ iofs.FS         = (*IOFS)(nil)
+	_ iofs.ReadDirFS  = (*IOFS)(nil)
+	_ iofs.StatFS     = (*IOFS)(nil)
+	_ iofs.ReadFileFS = (*IOFS)(nil)
+	_ iofs.GlobFS     = (*IOFS)(nil)
+)
+
+// IOFS returns an io/fs view of the filesystem
+func (fs *GrainFS) IOFS() *IOFS {
+	return &IOFS{fs: fs}
+}
+
+// Open opens the named file or directory for reading
+func (f *IOFS) Open(name string) (iofs.File, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	info, err := f.fs.Stat(filepath.FromSlash(name))
+	if err != nil {
+		return nil, ioError("open", name, err)
+	}
+	if info.IsDir() {
+		return &ioDir{fs: f.fs, name: name, info: info}, nil
+	}
+
+	file, err := f.fs.Open(filepath.FromSlash(name))
+	if err != nil {
+		return nil, ioError("open", name, err)
+	}
+	return &ioFile{file: file, name: name, info: info}, nil
+}
+
+// ReadDir reads the named directory, returning its entries sorted by name
+func (f *IOFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	entries, err := f.fs.ioDirEntries(name)
+	if err != nil {
+		return nil, ioError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+// Stat returns information about the named file
+func (f *IOFS) Stat(name string) (iofs.FileInfo, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "stat", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	info, err := f.fs.Stat(filepath.FromSlash(name))
+	if err != nil {
+		return nil, ioError("stat", name, err)
+	}
+	return info, nil
+}
+
+// ReadFile reads and returns the decrypted content of the named file
+func (f *IOFS) ReadFile(name string) ([]byte, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "readfile", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	file, err := f.fs.Open(filepath.FromSlash(name))
+	if err != nil {
+		return nil, ioError("readfile", name, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, ioError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// Glob returns the names of all files matching pattern
+func (f *IOFS) Glob(pattern string) ([]string, error) {
+	matches, err := f.fs.Glob(filepath.FromSlash(pattern))
+	for i := range matches {
+		matches[i] = filepath.ToSlash(matches[i])
+	}
+	return matches, err
+}
+
+// ioDirEntries lists the directory with the io/fs name name, sorted by name
+func (fs *GrainFS) ioDirEntries(name string) ([]iofs.DirEntry, error) {
+	infos, err := fs.ReadDir(filepath.FromSlash(name))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]iofs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = iofs.FileInfoToDirEntry(info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// ioError converts an error of a GrainFS operation into an io/fs error for name,
+// dropping the obfuscated path of errors from the underlying filesystem
+func ioError(op, name string, err error) error {
+	var pathErr *iofs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &iofs.PathError{Op: op, Path: name, Err: err}
+}
+
+// ioFile is an open file of an IOFS. It supports seeking to any offset, so that it can
+// serve range requests.
+type ioFile struct {
+	file   billy.File
+	name   string
+	info   iofs.FileInfo
+	offset int64
+}
+
+// Stat returns information about the file
+func (f *ioFile) Stat() (iofs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read reads decrypted data from the current offset
+func (f *ioFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads decrypted data from offset off
+func (f *ioFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.file.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		return n, ioError("read", f.name, err)
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, &iofs.PathError{Op: "seek", Path: f.name, Err: iofs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &iofs.PathError{Op: "seek", Path: f.name, Err: iofs.ErrInvalid}
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Close closes the file
+func (f *ioFile) Close() error {
+	return f.file.Close()
+}
+
+// ioDir is an open directory of an IOFS
+type ioDir struct {
+	fs      *GrainFS
+	name    string
+	info    iofs.FileInfo
+	entries []iofs.DirEntry
+	listed  bool
+}
+
+// Stat returns information about the directory
+func (d *ioDir) Stat() (iofs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read fails, as directories have no content
+func (d *ioDir) Read(p []byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining ones if n <= 0
+func (d *ioDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fs.ioDirEntries(d.name)
+		if err != nil {
+			return nil, ioError("readdir", d.name, err)
+		}
+		d.entries = entries
+		d.listed = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// Close closes the directory
+func (d *ioDir) Close() error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package grainfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestGrainFSIOFS(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	files := map[string]string{
+		"index.html":           "<h1>hello</h1>",
+		"static/app.js":        "console.log(1)",
+		"static/css/site.css":  "body {}",
+		"templates/page.tmpl":  "Hello, {{.}}!",
+		"templates/empty.tmpl": "",
+	}
+	for name, content := range files {
+		if _, err := fs.Write(name, []byte(content)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+
+	fsys := fs.IOFS()
+	if err := fstest.TestFS(fsys, "index.html", "static/app.js", "static/css/site.css", "templates/page.tmpl"); err != nil {
+		t.Fatalf("TestFS failed: %v", err)
+	}
+
+	// Sizes are those of the decrypted content
+	info, err := iofs.Stat(fsys, "static/app.js")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != int64(len(files["static/app.js"])) {
+		t.Fatalf("Expected size %d, got %d", len(files["static/app.js"]), info.Size())
+	}
+
+	// Errors follow io/fs conventions and do not reveal obfuscated names
+	_, err = fsys.Open("static/missing.js")
+	var pathErr *iofs.PathError
+	if !errors.Is(err, iofs.ErrNotExist) || !errors.As(err, &pathErr) || pathErr.Path != "static/missing.js" {
+		t.Fatalf("Expected not-exist error for the plaintext path, got %v", err)
+	}
+	if _, err := fsys.Open("/index.html"); !errors.Is(err, iofs.ErrInvalid) {
+		t.Fatalf("Expected invalid path error, got %v", err)
+	}
+
+	// fs.WalkDir sees the whole tree
+	var walked []string
+	err = iofs.WalkDir(fsys, ".", func(path string, d iofs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			walked = append(walked, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WalkDir failed: %v", err)
+	}
+	if len(walked) != len(files) {
+		t.Fatalf("Expected %d files, walked %v", len(files), walked)
+	}
+
+	// template.ParseFS
+	tmpl, err := template.ParseFS(fsys, "templates/*.tmpl")
+	if err != nil {
+		t.Fatalf("ParseFS failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&out, "page.tmpl", "world"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out.String() != "Hello, world!" {
+		t.Fatalf("Unexpected template output %q", out.String())
+	}
+}
+
+func TestGrainFSIOFSHTTPFileServer(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	content := strings.Repeat("0123456789", 100)
+	if _, err := fs.Write("files/data.txt", []byte(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.FS(fs.IOFS())))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/files/data.txt")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != content {
+		t.Fatalf("Unexpected response %d with %d bytes", resp.StatusCode, len(body))
+	}
+
+	// Range requests seek into the decrypted content
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/files/data.txt", nil)
+	req.Header.Set("Range", "bytes=105-109")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "56789" {
+		t.Fatalf("Unexpected range response %d: %q", resp.StatusCode, body)
+	}
+
+	// Directory listings show plaintext names
+	resp, err = http.Get(server.URL + "/files/")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "data.txt") {
+		t.Fatalf("Expected listing to contain data.txt, got %q", body)
+	}
+}
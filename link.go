@@ -0,0 +1,386 @@
+package grainfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// Hard links
+//
+// A regular file's content lives in the obfuscated object named after it. When a file
+// is linked for the first time its content is moved into a volume-wide object store
+// (.grainfs/objects at the volume root) under a random, stable ID, and every name
+// referencing it keeps an empty placeholder on disk plus a metadata record pointing at
+// the object. Link counts and the metadata shared by all names live in the encrypted
+// link table (.grainfs/links.json).
+
+// objectRecord describes a shared content object in the link table
+type objectRecord struct {
+	Links    int           `json:"links"`
+	Metadata *FileMetadata `json:"metadata"`
+}
+
+// linkTable maps object IDs to their records
+type linkTable map[string]*objectRecord
+
+// objectPath returns the path of a shared content object relative to the volume root
+func objectPath(id string) string {
+	return filepath.Join(GrainFSDir, ObjectsDir, id)
+}
+
+// newObjectID returns a random identifier for a shared content object
+func newObjectID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate object id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// loadLinkTable loads the link table from the volume root
+func (fs *GrainFS) loadLinkTable() (linkTable, error) {
+	table, _, err := fs.readLinkTable()
+	return table, err
+}
+
+// readLinkTable reads and decrypts the link table, returning it with its generation
+func (fs *GrainFS) readLinkTable() (linkTable, uint64, error) {
+	data, generation, err := fs.readStoreFile(fs.volume, filepath.Join(GrainFSDir, LinksFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(linkTable), 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read link table: %w", err)
+	}
+
+	var table linkTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal link table: %w", err)
+	}
+	if table == nil {
+		table = make(linkTable)
+	}
+
+	return table, generation, nil
+}
+
+// modifyLinkTable applies update to the link table under the volume's store lock and
+// saves the result. Nothing is saved if update fails.
+func (fs *GrainFS) modifyLinkTable(update func(table linkTable) error) error {
+	fs.filemapManager.linksMutex.Lock()
+	defer fs.filemapManager.linksMutex.Unlock()
+
+	unlock, err := lockStore(fs.volume, GrainFSDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	table, generation, err := fs.readLinkTable()
+	if err != nil {
+		return err
+	}
+
+	if err := update(table); err != nil {
+		return err
+	}
+
+	if err := fs.writeStoreFile(fs.volume, filepath.Join(GrainFSDir, LinksFile), table, generation); err != nil {
+		return fmt.Errorf("failed to write link table: %w", err)
+	}
+
+	return nil
+}
+
+// resolveMetadata returns the effective metadata for a per-name record, following it to
+// the shared object record for linked files. The result carries the link count.
+func (fs *GrainFS) resolveMetadata(md *FileMetadata) (*FileMetadata, error) {
+	if md == nil {
+		return nil, nil
+	}
+	if md.Object == "" {
+		resolved := md.clone()
+		resolved.Links = 1
+		return resolved, nil
+	}
+
+	table, err := fs.loadLinkTable()
+	if err != nil {
+		return nil, err
+	}
+
+	record, exists := table[md.Object]
+	if !exists || record.Metadata == nil {
+		return nil, fmt.Errorf("object %s missing from link table", md.Object)
+	}
+
+	resolved := record.Metadata.clone()
+	resolved.Object = md.Object
+	resolved.Links = record.Links
+	return resolved, nil
+}
+
+// updateObjectMetadata applies fn to the metadata shared by all names of a linked object
+func (fs *GrainFS) updateObjectMetadata(id string, fn func(md *FileMetadata)) error {
+	return fs.modifyLinkTable(func(table linkTable) error {
+		record, exists := table[id]
+		if !exists || record.Metadata == nil {
+			return fmt.Errorf("object %s missing from link table", id)
+		}
+
+		fn(record.Metadata)
+		record.Metadata.Object = ""
+		record.Metadata.Links = 0
+		return nil
+	})
+}
+
+// unlinkObject drops one reference to a shared object, deleting its content when the
+// last name referencing it is gone.
+func (fs *GrainFS) unlinkObject(id string) error {
+	return fs.modifyLinkTable(func(table linkTable) error {
+		record, exists := table[id]
+		if !exists {
+			return nil
+		}
+
+		record.Links--
+		if record.Links > 0 {
+			return nil
+		}
+
+		if err := fs.volume.Remove(objectPath(id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove object %s: %w", id, err)
+		}
+
+		delete(table, id)
+		return nil
+	})
+}
+
+// contentLocation returns the filesystem and path holding the content of the named
+// entry: the shared object for linked files, the obfuscated path otherwise.
+func (fs *GrainFS) contentLocation(name string) (billy.Filesystem, string, error) {
+	obfuscatedPath, err := fs.getObfuscatedPath(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+
+	dir, _ := splitUserPath(name)
+	md, err := fs.getMetadata(dir, filepath.Base(obfuscatedPath))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	if md != nil && md.Object != "" {
+		return fs.volume, objectPath(md.Object), nil
+	}
+
+	return fs.underlying, obfuscatedPath, nil
+}
+
+// Link creates newname as a hard link to the oldname file
+func (fs *GrainFS) Link(oldname, newname string) error {
+	if oldname == "" || newname == "" {
+		return fmt.Errorf("paths cannot be empty")
+	}
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if fs.readOnly {
+		return linkErr(ErrReadOnly)
+	}
+
+	// Like link(2) on Linux, symbolic links are linked themselves rather than followed
+	oldname, err := fs.resolveSymlinks(oldname, false)
+	if err != nil {
+		return err
+	}
+	newname, err = fs.resolveSymlinks(newname, false)
+	if err != nil {
+		return err
+	}
+
+	oldDir, _ := splitUserPath(oldname)
+	newDir, newBase := splitUserPath(newname)
+
+	unlock := fs.lockPaths([]string{oldDir, newDir}, nil)
+	defer unlock()
+
+	oldObfuscated, err := fs.getObfuscatedPath(oldname)
+	if err != nil {
+		return fmt.Errorf("failed to get old obfuscated path: %w", err)
+	}
+
+	info, err := fs.underlying.Stat(oldObfuscated)
+	if err != nil {
+		return linkErr(err)
+	}
+	if info.IsDir() {
+		return linkErr(fmt.Errorf("cannot link directories"))
+	}
+
+	// The new name must not exist yet, but its directory must
+	newObfuscatedDir, err := fs.getObfuscatedPath(newDir)
+	if err != nil {
+		return fmt.Errorf("failed to get new obfuscated directory: %w", err)
+	}
+	if dirInfo, err := fs.underlying.Stat(newObfuscatedDir); err != nil {
+		return linkErr(err)
+	} else if !dirInfo.IsDir() {
+		return linkErr(fmt.Errorf("not a directory: %s", newDir))
+	}
+
+	existingObfuscated, err := fs.getObfuscatedPath(newname)
+	if err != nil {
+		return fmt.Errorf("failed to get new obfuscated path: %w", err)
+	}
+	if _, err := fs.underlying.Stat(existingObfuscated); err == nil {
+		return linkErr(os.ErrExist)
+	}
+
+	// Promote the file into the object store on its first link
+	oldBase := filepath.Base(oldObfuscated)
+
+	oldRecord, err := fs.getMetadata(oldDir, oldBase)
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	id := ""
+	if oldRecord != nil {
+		id = oldRecord.Object
+	}
+	if id == "" {
+		if id, err = fs.promoteToObject(oldname, oldDir, oldBase, oldObfuscated, oldRecord, info); err != nil {
+			return err
+		}
+	}
+
+	// Create the new name as a placeholder referencing the object
+	newObfuscated, err := fs.obfuscateFilename(newDir, newBase)
+	if err != nil {
+		return fmt.Errorf("failed to obfuscate new filename: %w", err)
+	}
+
+	if err := fs.addObjectLink(id); err != nil {
+		fs.removeFromFilemap(newDir, newObfuscated)
+		return err
+	}
+
+	// Undo the reference and the registration on failure, so that the object can still
+	// be freed once its other names are removed
+	placeholder := filepath.Join(newObfuscatedDir, newObfuscated)
+	if err := fs.createPlaceholder(placeholder); err != nil {
+		fs.unlinkObject(id)
+		fs.removeFromFilemap(newDir, newObfuscated)
+		return err
+	}
+	if err := fs.setMetadata(newDir, newObfuscated, &FileMetadata{Object: id}); err != nil {
+		fs.underlying.Remove(placeholder)
+		fs.unlinkObject(id)
+		fs.removeFromFilemap(newDir, newObfuscated)
+		return err
+	}
+	return nil
+}
+
+// addObjectLink adds a reference to a shared object
+func (fs *GrainFS) addObjectLink(id string) error {
+	return fs.modifyLinkTable(func(table linkTable) error {
+		record, exists := table[id]
+		if !exists {
+			return fmt.Errorf("object %s missing from link table", id)
+		}
+		record.Links++
+		return nil
+	})
+}
+
+// promoteToObject moves the content of a regular file into the object store, leaving a
+// placeholder and a metadata record pointing at the new object behind.
+func (fs *GrainFS) promoteToObject(name, dir, obfuscatedBase, obfuscatedPath string, record *FileMetadata, info os.FileInfo) (string, error) {
+	id, err := newObjectID()
+	if err != nil {
+		return "", err
+	}
+
+	md := record
+	if md == nil {
+		md = metadataFromInfo(info)
+	}
+
+	if err := fs.createObject(id, md, obfuscatedPath); err != nil {
+		return "", fmt.Errorf("failed to move %s into object store: %w", name, err)
+	}
+
+	// Put the content back under its name on failure, so that it neither reads as empty
+	// nor leaves the object orphaned
+	if err := fs.createPlaceholder(obfuscatedPath); err != nil {
+		fs.restoreObject(id, obfuscatedPath)
+		return "", err
+	}
+
+	if err := fs.setMetadata(dir, obfuscatedBase, &FileMetadata{Object: id}); err != nil {
+		fs.underlying.Remove(obfuscatedPath)
+		fs.restoreObject(id, obfuscatedPath)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// createObject moves the content at obfuscatedPath into the object store as object id,
+// recording md as its metadata
+func (fs *GrainFS) createObject(id string, md *FileMetadata, obfuscatedPath string) error {
+	volumePath := filepath.Join(fs.volumePrefix, obfuscatedPath)
+	moved := false
+
+	err := fs.modifyLinkTable(func(table linkTable) error {
+		if err := fs.volume.MkdirAll(filepath.Join(GrainFSDir, ObjectsDir), 0755); err != nil {
+			return fmt.Errorf("failed to create object store: %w", err)
+		}
+
+		if err := fs.volume.Rename(volumePath, objectPath(id)); err != nil {
+			return err
+		}
+		moved = true
+
+		table[id] = &objectRecord{Links: 1, Metadata: md.clone()}
+		return nil
+	})
+	if err != nil && moved {
+		// The link table could not be saved, put the content back
+		fs.volume.Rename(objectPath(id), volumePath)
+	}
+
+	return err
+}
+
+// restoreObject undoes createObject, moving the content of object id back to
+// obfuscatedPath and dropping its link table record
+func (fs *GrainFS) restoreObject(id, obfuscatedPath string) error {
+	return fs.modifyLinkTable(func(table linkTable) error {
+		if err := fs.volume.Rename(objectPath(id), filepath.Join(fs.volumePrefix, obfuscatedPath)); err != nil {
+			return err
+		}
+		delete(table, id)
+		return nil
+	})
+}
+
+// createPlaceholder creates the empty on-disk entry standing in for a linked name
+func (fs *GrainFS) createPlaceholder(obfuscatedPath string) error {
+	file, err := fs.underlying.OpenFile(obfuscatedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create link placeholder: %w", err)
+	}
+	return file.Close()
+}
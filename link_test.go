@@ -0,0 +1,300 @@
+package grainfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func readAll(t *testing.T, fs billy.Basic, filename string) []byte {
+	t.Helper()
+
+	file, err := fs.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", filename, err)
+	}
+	return data
+}
+
+func linkCount(t *testing.T, fs *GrainFS, filename string) int {
+	t.Helper()
+
+	info, err := fs.Stat(filename)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", filename, err)
+	}
+	md, ok := info.Sys().(*FileMetadata)
+	if !ok {
+		t.Fatalf("Expected *FileMetadata for %s, got %T", filename, info.Sys())
+	}
+	return md.Links
+}
+
+// placeholderFailingFS is a backend failing exclusive creates outside of the GrainFS
+// directory, as of link placeholders, while fail is set
+type placeholderFailingFS struct {
+	billy.Filesystem
+	fail *bool
+}
+
+func (p placeholderFailingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if *p.fail && flag&os.O_EXCL != 0 && !strings.HasPrefix(filename, GrainFSDir) {
+		return nil, errors.New("placeholder creation failed")
+	}
+	return p.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func TestGrainFSLink(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	original := []byte("shared content")
+	if _, err := fs.Write("a/original.txt", original); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Chmod("a/original.txt", 0640); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.MkdirAll("b", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	if err := fs.Link("a/original.txt", "b/alias.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	if data := readAll(t, fs, "b/alias.txt"); !bytes.Equal(data, original) {
+		t.Fatalf("Expected linked content %q, got %q", original, data)
+	}
+	if n := linkCount(t, fs, "a/original.txt"); n != 2 {
+		t.Fatalf("Expected link count 2, got %d", n)
+	}
+
+	// Both names share metadata and content
+	info, err := fs.Stat("b/alias.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat link: %v", err)
+	}
+	if info.Mode() != 0640 {
+		t.Fatalf("Expected shared mode 0640, got %v", info.Mode())
+	}
+
+	updated := []byte("updated through the alias")
+	if _, err := fs.Write("b/alias.txt", updated); err != nil {
+		t.Fatalf("Failed to write through link: %v", err)
+	}
+	if data := readAll(t, fs, "a/original.txt"); !bytes.Equal(data, updated) {
+		t.Fatalf("Expected %q through original name, got %q", updated, data)
+	}
+
+	// Linking onto an existing name fails
+	if err := fs.Link("a/original.txt", "b/alias.txt"); !os.IsExist(err) {
+		t.Fatalf("Expected exist error, got %v", err)
+	}
+
+	// Removing one name keeps the content for the other
+	if err := fs.Remove("a/original.txt"); err != nil {
+		t.Fatalf("Failed to remove original: %v", err)
+	}
+	if data := readAll(t, fs, "b/alias.txt"); !bytes.Equal(data, updated) {
+		t.Fatalf("Expected content to survive removal of one link, got %q", data)
+	}
+	if n := linkCount(t, fs, "b/alias.txt"); n != 1 {
+		t.Fatalf("Expected link count 1, got %d", n)
+	}
+
+	// Removing the last name deletes the content object
+	if err := fs.Remove("b/alias.txt"); err != nil {
+		t.Fatalf("Failed to remove last link: %v", err)
+	}
+	objects, err := underlying.ReadDir(filepath.Join(GrainFSDir, ObjectsDir))
+	if err != nil {
+		t.Fatalf("Failed to read object store: %v", err)
+	}
+	if len(objects) != 0 {
+		t.Fatalf("Expected empty object store, found %d objects", len(objects))
+	}
+}
+
+func TestGrainFSLinkRenameAndReopen(t *testing.T) {
+	underlying := memfs.New()
+	password := "test-password-123"
+
+	fs, err := New(underlying, password)
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	content := []byte("linked data")
+	if _, err := fs.Write("one.txt", content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Link("one.txt", "two.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := fs.Link("two.txt", "three.txt"); err != nil {
+		t.Fatalf("Second link failed: %v", err)
+	}
+	if err := fs.Rename("three.txt", "renamed.txt"); err != nil {
+		t.Fatalf("Rename of link failed: %v", err)
+	}
+
+	// Renaming one link over another drops the replaced reference
+	if err := fs.Rename("renamed.txt", "two.txt"); err != nil {
+		t.Fatalf("Rename over link failed: %v", err)
+	}
+
+	fs2, err := New(underlying, password)
+	if err != nil {
+		t.Fatalf("Failed to reopen GrainFS: %v", err)
+	}
+	if n := linkCount(t, fs2, "one.txt"); n != 2 {
+		t.Fatalf("Expected link count 2 after reopen, got %d", n)
+	}
+	if data := readAll(t, fs2, "two.txt"); !bytes.Equal(data, content) {
+		t.Fatalf("Expected %q, got %q", content, data)
+	}
+
+	info, err := fs2.Stat("two.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat link: %v", err)
+	}
+	if info.Size() == 0 {
+		t.Fatalf("Expected link to report the size of its content object")
+	}
+}
+
+func TestGrainFSLinkInChroot(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	content := []byte("chrooted")
+	if _, err := fs.Write("jail/file.txt", content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	chrooted, err := fs.Chroot("jail")
+	if err != nil {
+		t.Fatalf("Chroot failed: %v", err)
+	}
+	jail := chrooted.(*GrainFS)
+
+	if err := jail.Link("file.txt", "copy.txt"); err != nil {
+		t.Fatalf("Link in chroot failed: %v", err)
+	}
+
+	if data := readAll(t, jail, "copy.txt"); !bytes.Equal(data, content) {
+		t.Fatalf("Expected %q in chroot, got %q", content, data)
+	}
+	if data := readAll(t, fs, "jail/copy.txt"); !bytes.Equal(data, content) {
+		t.Fatalf("Expected %q from the parent view, got %q", content, data)
+	}
+}
+
+func TestGrainFSLinkFailureRollback(t *testing.T) {
+	fail := false
+	fs, err := New(placeholderFailingFS{Filesystem: memfs.New(), fail: &fail}, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("original.txt", []byte("shared content")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Link("original.txt", "first.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	fail = true
+	if err := fs.Link("original.txt", "second.txt"); err == nil {
+		t.Fatalf("Expected Link to fail when the placeholder cannot be created")
+	}
+	fail = false
+
+	// The failed link left neither a reference nor a filemap entry behind
+	if n := linkCount(t, fs, "original.txt"); n != 2 {
+		t.Fatalf("Expected link count 2 after the failed link, got %d", n)
+	}
+	filemap, err := fs.loadFilemap(".")
+	if err != nil {
+		t.Fatalf("Failed to load filemap: %v", err)
+	}
+	for _, name := range filemap {
+		if name == "second.txt" {
+			t.Fatalf("Expected second.txt to be dropped from the filemap")
+		}
+	}
+
+	for _, name := range []string{"original.txt", "first.txt"} {
+		if err := fs.Remove(name); err != nil {
+			t.Fatalf("Remove %s failed: %v", name, err)
+		}
+	}
+	table, err := fs.loadLinkTable()
+	if err != nil {
+		t.Fatalf("Failed to load link table: %v", err)
+	}
+	if len(table) != 0 {
+		t.Fatalf("Expected the object to be freed, got %d objects", len(table))
+	}
+}
+
+func TestGrainFSLinkPromotionFailureRollback(t *testing.T) {
+	fail := false
+	fs, err := New(placeholderFailingFS{Filesystem: memfs.New(), fail: &fail}, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	original := []byte("original content")
+	if _, err := fs.Write("original.txt", original); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// The first link moves the content into the object store before the placeholder
+	// standing in for the original name is created
+	fail = true
+	if err := fs.Link("original.txt", "alias.txt"); err == nil {
+		t.Fatalf("Expected Link to fail when the placeholder cannot be created")
+	}
+	fail = false
+
+	// The content is back under its name, and no object is left behind
+	if data := readAll(t, fs, "original.txt"); !bytes.Equal(data, original) {
+		t.Fatalf("Expected %q after the failed link, got %q", original, data)
+	}
+	table, err := fs.loadLinkTable()
+	if err != nil {
+		t.Fatalf("Failed to load link table: %v", err)
+	}
+	if len(table) != 0 {
+		t.Fatalf("Expected no objects after the failed link, got %d", len(table))
+	}
+	if objects, err := fs.volume.ReadDir(filepath.Join(GrainFSDir, ObjectsDir)); err == nil && len(objects) != 0 {
+		t.Fatalf("Expected the object store to be empty, found %d objects", len(objects))
+	}
+
+	// Linking works once the backend recovers
+	if err := fs.Link("original.txt", "alias.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if data := readAll(t, fs, "alias.txt"); !bytes.Equal(data, original) {
+		t.Fatalf("Expected linked content %q, got %q", original, data)
+	}
+}
@@ -0,0 +1,108 @@
+package grainfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+var (
+	// ErrNotInFilemap is reported for entries on disk whose name is missing from the
+	// filemap of their directory, which ReadDir cannot show
+	ErrNotInFilemap = errors.New("entry missing from filemap")
+
+	// ErrMissingOnDisk is reported for filemap entries without an entry on disk
+	ErrMissingOnDisk = errors.New("entry missing on disk")
+)
+
+// DirEntryDetail is an entry of a detailed directory listing
+type DirEntryDetail struct {
+	// Name is the original name of the entry, empty if it is unknown
+	Name string
+	// ObfuscatedName is the name of the entry on disk
+	ObfuscatedName string
+	// Info describes the entry; it is nil if Err is set
+	Info os.FileInfo
+	// Err is why the entry cannot be read, if it cannot
+	Err error
+}
+
+// ReadDirDetailed lists a directory like ReadDir, but instead of hiding the entries it
+// cannot read, it reports them with the error that prevents reading them: entries on
+// disk missing from the filemap fail with ErrNotInFilemap, filemap entries missing on
+// disk with ErrMissingOnDisk. Entries are sorted by name.
+func (fs *GrainFS) ReadDirDetailed(path string) ([]DirEntryDetail, error) {
+	if path == "" {
+		path = "."
+	}
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
+
+	path, err := fs.resolveSymlinks(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPathCtx(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+
+	infos, err := fs.readUnderlyingDir(obfuscatedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare with the filemap on disk, not a cached one that may be outdated
+	filemap, err := fs.reloadFilemap(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []DirEntryDetail
+	onDisk := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		if err := fs.contextErr(); err != nil {
+			return nil, err
+		}
+		if info.Name() == GrainFSDir {
+			continue
+		}
+		onDisk[info.Name()] = true
+
+		entry := DirEntryDetail{ObfuscatedName: info.Name()}
+		originalName, exists := filemap[info.Name()]
+		if !exists {
+			entry.Err = ErrNotInFilemap
+			entries = append(entries, entry)
+			continue
+		}
+
+		entry.Name = originalName
+		if entry.Info, err = fs.wrapFileInfo(info, originalName, path, info.Name()); err != nil {
+			entry.Info = nil
+			entry.Err = err
+		}
+		entries = append(entries, entry)
+	}
+
+	for obfuscated, original := range filemap {
+		if !onDisk[obfuscated] {
+			entries = append(entries, DirEntryDetail{
+				Name:           original,
+				ObfuscatedName: obfuscated,
+				Err:            ErrMissingOnDisk,
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].ObfuscatedName < entries[j].ObfuscatedName
+	})
+	return entries, nil
+}
@@ -0,0 +1,86 @@
+package grainfs
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestGrainFSReadDirDetailed(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	for _, name := range []string{"dir/fine.txt", "dir/gone.txt", "dir/linked.txt"} {
+		if _, err := fs.Write(name, []byte(name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	if err := fs.Link("dir/linked.txt", "other.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	obfuscatedDir, err := fs.getObfuscatedPath("dir")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+
+	// An object nobody knows the name of
+	orphan, err := underlying.Create(filepath.Join(obfuscatedDir, "orphaned-object"))
+	if err != nil {
+		t.Fatalf("Failed to create orphan: %v", err)
+	}
+	orphan.Close()
+
+	// A filemap entry whose object disappeared
+	gone, err := fs.getObfuscatedPath("dir/gone.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	if err := underlying.Remove(gone); err != nil {
+		t.Fatalf("Failed to remove object: %v", err)
+	}
+
+	// ReadDir only shows what it can read
+	infos, err := fs.ReadDir("dir")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Expected ReadDir to show the readable files only, got %d entries", len(infos))
+	}
+
+	// A linked file whose shared content disappeared
+	md, err := fs.lookupMetadata("dir/linked.txt")
+	if err != nil || md.Object == "" {
+		t.Fatalf("Expected linked metadata, got %v: %v", md, err)
+	}
+	if err := underlying.Remove(objectPath(md.Object)); err != nil {
+		t.Fatalf("Failed to remove content object: %v", err)
+	}
+
+	entries, err := fs.ReadDirDetailed("dir")
+	if err != nil {
+		t.Fatalf("ReadDirDetailed failed: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %+v", entries)
+	}
+
+	// Entries are sorted by name, unknown names first
+	if entries[0].Name != "" || entries[0].ObfuscatedName != "orphaned-object" || !errors.Is(entries[0].Err, ErrNotInFilemap) {
+		t.Fatalf("Expected orphaned object, got %+v", entries[0])
+	}
+	if entries[1].Name != "fine.txt" || entries[1].Err != nil || entries[1].Info == nil || entries[1].Info.Name() != "fine.txt" {
+		t.Fatalf("Expected readable fine.txt, got %+v", entries[1])
+	}
+	if entries[2].Name != "gone.txt" || entries[2].ObfuscatedName != filepath.Base(gone) || !errors.Is(entries[2].Err, ErrMissingOnDisk) {
+		t.Fatalf("Expected gone.txt to be missing on disk, got %+v", entries[2])
+	}
+	if entries[3].Name != "linked.txt" || entries[3].Err == nil || entries[3].Info != nil {
+		t.Fatalf("Expected linked.txt to fail, got %+v", entries[3])
+	}
+}
@@ -0,0 +1,169 @@
+package grainfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Locking
+//
+// GrainFS serializes mutations per directory rather than per volume. Every directory
+// has two locks, keyed like the filemap cache by its volume-relative user path:
+//
+//   - entries guards the directory's filemap and metadata records. It is held by
+//     operations adding, changing or removing entries of that directory.
+//   - tree is held shared by every mutation operating at or below the directory, and
+//     exclusively while the directory itself is renamed or removed, so a subtree can't
+//     move out from under an operation in progress.
+//
+// Reads take no locks: filemaps and metadata maps are never modified in place, and
+// their files are replaced atomically on disk, so readers always see a consistent
+// snapshot. Locks are acquired in a single global order to rule out deadlocks, and the
+// link table lock is always innermost.
+
+// dirLock holds the locks of one directory
+type dirLock struct {
+	tree    sync.RWMutex
+	entries sync.Mutex
+	refs    int
+}
+
+// lockManager hands out directory locks. It is shared by all views of a volume.
+type lockManager struct {
+	mutex sync.Mutex
+	locks map[string]*dirLock
+}
+
+// newLockManager creates an empty lock manager
+func newLockManager() *lockManager {
+	return &lockManager{locks: make(map[string]*dirLock)}
+}
+
+// acquire returns the lock of a key, creating it if needed
+func (lm *lockManager) acquire(key string) *dirLock {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	lock, exists := lm.locks[key]
+	if !exists {
+		lock = &dirLock{}
+		lm.locks[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release drops a reference to the lock of a key, forgetting it once unused
+func (lm *lockManager) release(key string) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	lock := lm.locks[key]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(lm.locks, key)
+	}
+}
+
+// lockRequest describes the locks an operation needs on a single key
+type lockRequest struct {
+	entries   bool
+	exclusive bool
+}
+
+// lockPaths acquires the locks needed to change the entries of the user directories in
+// update and to move or delete the user paths in detach. The ancestors of all of them
+// are locked shared. It returns a function releasing the locks.
+func (fs *GrainFS) lockPaths(update, detach []string) (unlock func()) {
+	requests := make(map[string]*lockRequest)
+	request := func(key string) *lockRequest {
+		req, exists := requests[key]
+		if !exists {
+			req = &lockRequest{}
+			requests[key] = req
+		}
+		return req
+	}
+	withAncestors := func(key string) {
+		for key != "." {
+			key = filepath.Dir(key)
+			request(key)
+		}
+	}
+
+	for _, dir := range update {
+		key := fs.cacheKey(dir)
+		request(key).entries = true
+		withAncestors(key)
+	}
+	for _, path := range detach {
+		key := fs.cacheKey(path)
+		request(key).exclusive = true
+		withAncestors(key)
+	}
+
+	keys := make([]string, 0, len(requests))
+	for key := range requests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	held := make([]*dirLock, len(keys))
+	for i, key := range keys {
+		req := requests[key]
+		lock := fs.locks.acquire(key)
+		if req.exclusive {
+			lock.tree.Lock()
+		} else {
+			lock.tree.RLock()
+		}
+		if req.entries {
+			lock.entries.Lock()
+		}
+		held[i] = lock
+	}
+
+	return func() {
+		for i := len(keys) - 1; i >= 0; i-- {
+			req, lock := requests[keys[i]], held[i]
+			if req.entries {
+				lock.entries.Unlock()
+			}
+			if req.exclusive {
+				lock.tree.Unlock()
+			} else {
+				lock.tree.RUnlock()
+			}
+			fs.locks.release(keys[i])
+		}
+	}
+}
+
+// lockDir locks the entries of the user directory dir for an update
+func (fs *GrainFS) lockDir(dir string) (unlock func()) {
+	return fs.lockPaths([]string{dir}, nil)
+}
+
+// lockDirForCreate locks the entries of the user directory dir for adding new entries
+// to it, creating the directory and its parents first if they don't exist.
+func (fs *GrainFS) lockDirForCreate(dir string) (unlock func(), err error) {
+	for {
+		unlock := fs.lockDir(dir)
+		exists, err := fs.dirExists(dir)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		if exists {
+			return unlock, nil
+		}
+		unlock()
+
+		// The directory is missing, or was removed before we got the lock
+		if err := fs.mkdirAllInternal(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+}
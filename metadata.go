@@ -0,0 +1,300 @@
+package grainfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMetadata holds the attributes GrainFS tracks for a single directory entry.
+// It is stored encrypted next to the directory's filemap so that backends which
+// cannot keep (or would leak) modes, timestamps and ownership still report them.
+type FileMetadata struct {
+	Mode       os.FileMode       `json:"mode"`
+	ModTime    time.Time         `json:"mtime"`
+	AccessTime time.Time         `json:"atime"`
+	UID        int               `json:"uid"`
+	GID        int               `json:"gid"`
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"`
+
+	// Object is the ID of the shared content object of a hard-linked file
+	Object string `json:"object,omitempty"`
+	// Links is the number of names referencing the entry's content
+	Links int `json:"-"`
+}
+
+// MetadataMap maps obfuscated names within a directory to their metadata
+type MetadataMap map[string]*FileMetadata
+
+// newFileMetadata returns a metadata record for a freshly created entry
+func newFileMetadata(mode os.FileMode) *FileMetadata {
+	now := time.Now()
+	return &FileMetadata{
+		Mode:       mode,
+		ModTime:    now,
+		AccessTime: now,
+		UID:        os.Getuid(),
+		GID:        os.Getgid(),
+	}
+}
+
+// metadataFromInfo builds a metadata record from what the underlying filesystem reports,
+// used for entries created before metadata tracking existed.
+func metadataFromInfo(info os.FileInfo) *FileMetadata {
+	md := newFileMetadata(info.Mode())
+	md.ModTime = info.ModTime()
+	md.AccessTime = info.ModTime()
+	return md
+}
+
+// clone returns a deep copy of the metadata record
+func (md *FileMetadata) clone() *FileMetadata {
+	c := *md
+	if md.Xattrs != nil {
+		c.Xattrs = make(map[string][]byte, len(md.Xattrs))
+		for k, v := range md.Xattrs {
+			c.Xattrs[k] = append([]byte(nil), v...)
+		}
+	}
+	return &c
+}
+
+// loadMetadata loads the metadata records for a directory
+func (fs *GrainFS) loadMetadata(dir string) (MetadataMap, error) {
+	key := fs.cacheKey(dir)
+	fm := fs.filemapManager
+
+	// Check cache first
+	fm.cacheMutex.RLock()
+	cached, exists := fm.metadataCache[key]
+	token := fm.token(key)
+	fm.cacheMutex.RUnlock()
+	if exists {
+		return cached, nil
+	}
+
+	obfuscatedDir, err := fs.getObfuscatedPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get obfuscated directory path: %w", err)
+	}
+
+	metadata, _, err := fs.readMetadata(obfuscatedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	fm.storeLoaded(key, token, func() { fm.cacheMetadata(key, metadata) })
+
+	return metadata, nil
+}
+
+// readMetadata reads and decrypts the metadata records of an obfuscated directory,
+// returning them with their generation
+func (fs *GrainFS) readMetadata(obfuscatedDir string) (MetadataMap, uint64, error) {
+	metadataPath := filepath.Join(obfuscatedDir, GrainFSDir, MetadataFile)
+
+	data, generation, err := fs.readStoreFile(fs.underlying, metadataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(MetadataMap), 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	var metadata MetadataMap
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+	if metadata == nil {
+		metadata = make(MetadataMap)
+	}
+
+	return metadata, generation, nil
+}
+
+// modifyMetadata applies update to the metadata records of a directory under its store
+// lock, reading them back from disk first so that changes made by other processes are
+// kept. The records are saved if update reports a change.
+func (fs *GrainFS) modifyMetadata(dir string, update func(metadata MetadataMap) bool) error {
+	obfuscatedDir, err := fs.getObfuscatedPath(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get obfuscated directory path: %w", err)
+	}
+
+	unlock, err := lockStore(fs.underlying, filepath.Join(obfuscatedDir, GrainFSDir))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	metadata, generation, err := fs.readMetadata(obfuscatedDir)
+	if err != nil {
+		return err
+	}
+
+	if update(metadata) {
+		metadataPath := filepath.Join(obfuscatedDir, GrainFSDir, MetadataFile)
+		if err := fs.writeStoreFile(fs.underlying, metadataPath, metadata, generation); err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
+
+	key := fs.cacheKey(dir)
+	fm := fs.filemapManager
+	fm.storeSaved(key, func() { fm.cacheMetadata(key, metadata) })
+
+	return nil
+}
+
+// cacheMetadata caches the metadata records for a cache key, with the index of the
+// symbolic links among them. The caller must hold cacheMutex.
+func (fm *FilemapManager) cacheMetadata(key string, metadata MetadataMap) {
+	fm.metadataCache[key] = metadata
+	fm.symlinkCache[key] = newSymlinkIndex(metadata)
+}
+
+// getMetadata returns the metadata record for an obfuscated name in a directory, or nil
+// if none has been recorded.
+func (fs *GrainFS) getMetadata(dir, obfuscated string) (*FileMetadata, error) {
+	metadata, err := fs.loadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	return metadata[obfuscated], nil
+}
+
+// setMetadata stores the metadata record for an obfuscated name in a directory
+func (fs *GrainFS) setMetadata(dir, obfuscated string, md *FileMetadata) error {
+	return fs.modifyMetadata(dir, func(metadata MetadataMap) bool {
+		metadata[obfuscated] = md
+		return true
+	})
+}
+
+// removeMetadata drops the metadata record for an obfuscated name in a directory
+func (fs *GrainFS) removeMetadata(dir, obfuscated string) error {
+	return fs.modifyMetadata(dir, func(metadata MetadataMap) bool {
+		if _, exists := metadata[obfuscated]; !exists {
+			return false
+		}
+		delete(metadata, obfuscated)
+		return true
+	})
+}
+
+// splitUserPath returns the user directory and base name of a user path
+func splitUserPath(name string) (dir, base string) {
+	name = filepath.Clean(name)
+	dir = filepath.Dir(name)
+	if dir == name {
+		dir = "."
+	}
+	return dir, filepath.Base(name)
+}
+
+// lookupMetadata returns the metadata record of an existing entry, synthesizing an empty
+// one from the underlying filesystem when none has been recorded.
+func (fs *GrainFS) lookupMetadata(name string) (*FileMetadata, error) {
+	if name == "" {
+		return nil, fmt.Errorf("filename cannot be empty")
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+
+	info, err := fs.underlying.Stat(obfuscatedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, _ := splitUserPath(name)
+	md, err := fs.getMetadata(dir, filepath.Base(obfuscatedPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	if md == nil {
+		md = metadataFromInfo(info)
+		md.Links = 1
+		return md, nil
+	}
+
+	return fs.resolveMetadata(md)
+}
+
+// updateMetadata applies fn to the metadata record of the named entry, synthesizing a
+// record from the underlying filesystem when none exists yet.
+func (fs *GrainFS) updateMetadata(name string, fn func(md *FileMetadata)) error {
+	md, err := fs.lookupMetadata(name)
+	if err != nil {
+		return err
+	}
+
+	// Linked files share the metadata stored with their content object
+	if md.Object != "" {
+		return fs.updateObjectMetadata(md.Object, fn)
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPath(name)
+	if err != nil {
+		return fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+	dir, _ := splitUserPath(name)
+	obfuscatedBase := filepath.Base(obfuscatedPath)
+
+	// Apply fn to the record as currently stored, which another process may have changed
+	return fs.modifyMetadata(dir, func(metadata MetadataMap) bool {
+		record := md
+		if stored := metadata[obfuscatedBase]; stored != nil {
+			record = stored.clone()
+		}
+		fn(record)
+		metadata[obfuscatedBase] = record
+		return true
+	})
+}
+
+// touch sets the modification time of the named entry to now. Entries that have
+// disappeared in the meantime (removed or renamed while open) are ignored.
+func (fs *GrainFS) touch(name string) error {
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	now := time.Now()
+	err := fs.updateMetadata(name, func(md *FileMetadata) {
+		md.ModTime = now
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// wrapFileInfo wraps an underlying FileInfo with the original name and any recorded
+// metadata for the entry. Linked files report the FileInfo of their content object.
+func (fs *GrainFS) wrapFileInfo(info os.FileInfo, originalName, dir, obfuscatedBase string) (*FileInfoWrapper, error) {
+	record, err := fs.getMetadata(dir, obfuscatedBase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	md, err := fs.resolveMetadata(record)
+	if err != nil {
+		return nil, err
+	}
+
+	if md != nil && md.Object != "" {
+		if info, err = fs.volume.Stat(objectPath(md.Object)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileInfoWrapper{
+		FileInfo:     info,
+		originalName: originalName,
+		metadata:     md,
+	}, nil
+}
@@ -0,0 +1,299 @@
+package grainfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestGrainFSMetadataOnCreate(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	file, err := fs.OpenFile("script.sh", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0750)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	file.Write([]byte("#!/bin/sh\n"))
+	file.Close()
+
+	if err := fs.MkdirAll("docs/private", 0700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	info, err := fs.Stat("script.sh")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode() != 0750 {
+		t.Fatalf("Expected mode %v, got %v", os.FileMode(0750), info.Mode())
+	}
+
+	md, ok := info.Sys().(*FileMetadata)
+	if !ok {
+		t.Fatalf("Expected Sys() to return *FileMetadata, got %T", info.Sys())
+	}
+	if md.UID != os.Getuid() || md.GID != os.Getgid() {
+		t.Fatalf("Expected ownership %d:%d, got %d:%d", os.Getuid(), os.Getgid(), md.UID, md.GID)
+	}
+
+	info, err = fs.Stat("docs/private")
+	if err != nil {
+		t.Fatalf("Failed to stat directory: %v", err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected directory with mode 0700, got %v", info.Mode())
+	}
+
+	// Intermediate directories are registered and listed as well
+	infos, err := fs.ReadDir(".")
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	names := make(map[string]os.FileMode)
+	for _, info := range infos {
+		names[info.Name()] = info.Mode()
+	}
+	if mode, ok := names["docs"]; !ok || !mode.IsDir() {
+		t.Fatalf("Expected docs directory in listing, got %v", names)
+	}
+	if names["script.sh"] != 0750 {
+		t.Fatalf("Expected script.sh with mode 0750 in listing, got %v", names)
+	}
+}
+
+func TestGrainFSChmodChtimesLchown(t *testing.T) {
+	underlying := memfs.New()
+	password := "test-password-123"
+
+	fs, err := New(underlying, password)
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("data.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := fs.Chmod("data.txt", 0600); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+
+	atime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := fs.Chtimes("data.txt", atime, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	if err := fs.Lchown("data.txt", 1234, -1); err != nil {
+		t.Fatalf("Lchown failed: %v", err)
+	}
+
+	// Metadata must survive a fresh instance reading it from disk
+	fs2, err := New(underlying, password)
+	if err != nil {
+		t.Fatalf("Failed to reopen GrainFS: %v", err)
+	}
+
+	info, err := fs2.Stat("data.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode() != 0600 {
+		t.Fatalf("Expected mode 0600, got %v", info.Mode())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mtime %v, got %v", mtime, info.ModTime())
+	}
+
+	md := info.Sys().(*FileMetadata)
+	if !md.AccessTime.Equal(atime) {
+		t.Fatalf("Expected atime %v, got %v", atime, md.AccessTime)
+	}
+	if md.UID != 1234 || md.GID != os.Getgid() {
+		t.Fatalf("Expected ownership 1234:%d, got %d:%d", os.Getgid(), md.UID, md.GID)
+	}
+
+	// Opening for writing without writing leaves the modification time alone
+	for _, flag := range []int{os.O_WRONLY, os.O_RDWR} {
+		f, err := fs2.OpenFile("data.txt", flag, 0)
+		if err != nil {
+			t.Fatalf("Failed to open file: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Failed to close file: %v", err)
+		}
+		if info, err := fs2.Stat("data.txt"); err != nil || !info.ModTime().Equal(mtime) {
+			t.Fatalf("Expected mtime %v after an unwritten open, got %v: %v", mtime, info, err)
+		}
+	}
+
+	// Rewriting the file bumps the modification time but keeps the rest
+	if _, err := fs2.Write("data.txt", []byte("new data")); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	info, err = fs2.Stat("data.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !info.ModTime().After(mtime) {
+		t.Fatalf("Expected mtime to advance past %v, got %v", mtime, info.ModTime())
+	}
+	if info.Mode() != 0600 {
+		t.Fatalf("Expected mode 0600 after rewrite, got %v", info.Mode())
+	}
+
+	// Changing a missing file reports not-exist
+	if err := fs2.Chmod("missing.txt", 0644); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestGrainFSMetadataRenameAndRemove(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("old.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Chmod("old.txt", 0640); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.MkdirAll("dest", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	if err := fs.Rename("old.txt", "dest/new.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	info, err := fs.Stat("dest/new.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat renamed file: %v", err)
+	}
+	if info.Mode() != 0640 {
+		t.Fatalf("Expected mode 0640 after rename, got %v", info.Mode())
+	}
+
+	if err := fs.Remove("dest/new.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	// A new file under the same name starts with fresh metadata
+	file, err := fs.OpenFile("dest/new.txt", os.O_WRONLY|os.O_CREATE, 0604)
+	if err != nil {
+		t.Fatalf("Failed to recreate file: %v", err)
+	}
+	file.Close()
+
+	info, err = fs.Stat("dest/new.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat recreated file: %v", err)
+	}
+	if info.Mode() != 0604 {
+		t.Fatalf("Expected mode 0604 for recreated file, got %v", info.Mode())
+	}
+}
+
+func TestGrainFSMetadataEncrypted(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("tagged.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Lchown("tagged.txt", 424242, 434343); err != nil {
+		t.Fatalf("Lchown failed: %v", err)
+	}
+
+	file, err := underlying.Open(GrainFSDir + "/" + MetadataFile)
+	if err != nil {
+		t.Fatalf("Failed to open raw metadata: %v", err)
+	}
+	raw, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Failed to read raw metadata: %v", err)
+	}
+
+	for _, plain := range []string{"mtime", "424242", "434343"} {
+		if bytes.Contains(raw, []byte(plain)) {
+			t.Fatalf("Raw metadata should be encrypted, found %q", plain)
+		}
+	}
+}
+
+func TestFileInfoWrapperSize(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	for name, content := range map[string]string{"empty.txt": "", "hello.txt": "hello"} {
+		if err := util.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if info.Size() != int64(len(content)) {
+			t.Fatalf("Expected %s to be %d bytes, got %d", name, len(content), info.Size())
+		}
+
+		// Open files report the same size before and after they are read
+		opened, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", name, err)
+		}
+		f := opened.(*EncryptedFile)
+		if info, err := f.Stat(); err != nil || info.Size() != int64(len(content)) {
+			t.Fatalf("Expected open %s to be %d bytes, got %v: %v", name, len(content), info, err)
+		}
+		if _, err := io.ReadAll(f); err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if info, err := f.Stat(); err != nil || info.Size() != int64(len(content)) {
+			t.Fatalf("Expected read %s to be %d bytes, got %v: %v", name, len(content), info, err)
+		}
+		f.Close()
+	}
+	infos, err := fs.ReadDir(".")
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	for _, info := range infos {
+		if info.Name() == "hello.txt" && info.Size() != 5 {
+			t.Fatalf("Expected hello.txt to be listed with 5 bytes, got %d", info.Size())
+		}
+	}
+
+	// Content shorter than a nonce and a tag, as while it is written, is empty
+	underlying := memfs.New()
+	for _, size := range []int{0, NonceSize, NonceSize + TagSize - 1} {
+		if err := util.WriteFile(underlying, "partial", make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to write underlying file: %v", err)
+		}
+		info, err := underlying.Stat("partial")
+		if err != nil {
+			t.Fatalf("Failed to stat underlying file: %v", err)
+		}
+		wrapped := &FileInfoWrapper{FileInfo: info, originalName: "partial"}
+		if wrapped.Size() != 0 {
+			t.Fatalf("Expected %d stored bytes to report 0, got %d", size, wrapped.Size())
+		}
+	}
+}
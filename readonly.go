@@ -0,0 +1,35 @@
+package grainfs
+
+import (
+	"fmt"
+	"os"
+)
+
+// ErrReadOnly is returned by mutating operations of read-only filesystems. It wraps
+// os.ErrPermission.
+var ErrReadOnly = fmt.Errorf("grainfs is read-only: %w", os.ErrPermission)
+
+// writeFlags are the OpenFile flags that may change a file
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// ReadOnly returns a view of the filesystem in which all mutating operations fail
+// with ErrReadOnly
+func (fs *GrainFS) ReadOnly() *GrainFS {
+	view := *fs
+	view.readOnly = true
+	return &view
+}
+
+// IsReadOnly reports whether the filesystem rejects mutating operations
+func (fs *GrainFS) IsReadOnly() bool {
+	return fs.readOnly
+}
+
+// checkWritable returns ErrReadOnly for operation op on path if the filesystem is
+// read-only
+func (fs *GrainFS) checkWritable(op, path string) error {
+	if fs.readOnly {
+		return &os.PathError{Op: op, Path: path, Err: ErrReadOnly}
+	}
+	return nil
+}
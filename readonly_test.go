@@ -0,0 +1,194 @@
+package grainfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// errBackendWrite is returned by writeFailingFS for every write
+var errBackendWrite = errors.New("write to read-only backend")
+
+// writeFailingFS is a backend failing every write, counting the attempts
+type writeFailingFS struct {
+	billy.Filesystem
+	writes *int
+}
+
+func (w writeFailingFS) fail() error {
+	*w.writes++
+	return errBackendWrite
+}
+
+func (w writeFailingFS) Create(filename string) (billy.File, error) {
+	return nil, w.fail()
+}
+
+func (w writeFailingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&writeFlags != 0 {
+		return nil, w.fail()
+	}
+	return w.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (w writeFailingFS) Rename(oldpath, newpath string) error {
+	return w.fail()
+}
+
+func (w writeFailingFS) Remove(filename string) error {
+	return w.fail()
+}
+
+func (w writeFailingFS) MkdirAll(filename string, perm os.FileMode) error {
+	return w.fail()
+}
+
+func (w writeFailingFS) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, w.fail()
+}
+
+func (w writeFailingFS) Symlink(target, link string) error {
+	return w.fail()
+}
+
+func (w writeFailingFS) Chroot(path string) (billy.Filesystem, error) {
+	sub, err := w.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return writeFailingFS{sub, w.writes}, nil
+}
+
+func TestGrainFSReadOnly(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("docs/report.txt", []byte("quarterly")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := fs.SetXattr("docs/report.txt", "user.tag", []byte("q3")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+	if err := fs.Symlink("docs/report.txt", "latest"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	// A fresh instance has nothing cached, so every read goes to the backend
+	writes := 0
+	backend := writeFailingFS{underlying, &writes}
+	ro, err := NewWithOptions(backend, "test-password-123", Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open read-only GrainFS: %v", err)
+	}
+	if !ro.IsReadOnly() {
+		t.Fatalf("Expected filesystem to be read-only")
+	}
+
+	// Reads work
+	if data := readAll(t, ro, "latest"); string(data) != "quarterly" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+	if _, err := ro.Stat("docs/report.txt"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if infos, err := ro.ReadDir("docs"); err != nil || len(infos) != 1 {
+		t.Fatalf("Expected 1 entry, got %d: %v", len(infos), err)
+	}
+	if value, err := ro.GetXattr("docs/report.txt", "user.tag"); err != nil || string(value) != "q3" {
+		t.Fatalf("Unexpected xattr %q: %v", value, err)
+	}
+	if target, err := ro.Readlink("latest"); err != nil || target != "docs/report.txt" {
+		t.Fatalf("Unexpected link target %q: %v", target, err)
+	}
+	sub, err := ro.Chroot("docs")
+	if err != nil {
+		t.Fatalf("Chroot failed: %v", err)
+	}
+	if data := readAll(t, sub, "report.txt"); string(data) != "quarterly" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+
+	// Mutations fail with a permission error
+	file, err := ro.Open("docs/report.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer file.Close()
+
+	mutations := map[string]func() error{
+		"Create": func() error { _, err := ro.Create("new.txt"); return err },
+		"OpenFile": func() error {
+			_, err := ro.OpenFile("docs/report.txt", os.O_WRONLY|os.O_TRUNC, 0)
+			return err
+		},
+		"Write":       func() error { _, err := ro.Write("new.txt", []byte("x")); return err },
+		"Rename":      func() error { return ro.Rename("docs/report.txt", "moved.txt") },
+		"Remove":      func() error { return ro.Remove("docs/report.txt") },
+		"MkdirAll":    func() error { return ro.MkdirAll("newdir", 0755) },
+		"Symlink":     func() error { return ro.Symlink("docs", "link") },
+		"Link":        func() error { return ro.Link("docs/report.txt", "hard.txt") },
+		"TempFile":    func() error { _, err := ro.TempFile("docs", "tmp"); return err },
+		"Chmod":       func() error { return ro.Chmod("docs/report.txt", 0600) },
+		"Chown":       func() error { return ro.Chown("docs/report.txt", 1, 1) },
+		"Lchown":      func() error { return ro.Lchown("latest", 1, 1) },
+		"Chtimes":     func() error { return ro.Chtimes("docs/report.txt", time.Now(), time.Now()) },
+		"SetXattr":    func() error { return ro.SetXattr("docs/report.txt", "user.tag", nil) },
+		"RemoveXattr": func() error { return ro.RemoveXattr("docs/report.txt", "user.tag") },
+		"Lock":        func() error { return file.Lock() },
+		"ChrootWrite": func() error { _, err := sub.Create("new.txt"); return err },
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, os.ErrPermission) || !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("Expected %s to fail with ErrReadOnly, got %v", name, err)
+		}
+	}
+
+	if writes != 0 {
+		t.Fatalf("Expected no writes to the backend, got %d", writes)
+	}
+}
+
+func TestGrainFSReadOnlyView(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if _, err := fs.Write("file.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	view := fs.ReadOnly()
+	if data := readAll(t, view, "file.txt"); string(data) != "content" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+	if err := view.Remove("file.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Expected Remove to fail with os.ErrPermission, got %v", err)
+	}
+
+	// The filesystem itself stays writable
+	if fs.IsReadOnly() {
+		t.Fatalf("Expected filesystem to stay writable")
+	}
+	if err := fs.Remove("file.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+}
+
+func TestGrainFSReadOnlyRequiresStore(t *testing.T) {
+	writes := 0
+	backend := writeFailingFS{memfs.New(), &writes}
+
+	_, err := NewWithOptions(backend, "test-password-123", Options{ReadOnly: true})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected missing config to be reported, got %v", err)
+	}
+	if writes != 0 {
+		t.Fatalf("Expected no writes to the backend, got %d", writes)
+	}
+}
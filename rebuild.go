@@ -0,0 +1,167 @@
+package grainfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DamagedFileSuffix is appended to the name of a filemap that cannot be decrypted when
+// RebuildFilemaps replaces it, so that it is kept for inspection
+const DamagedFileSuffix = ".damaged"
+
+// RebuildReport is the result of RebuildFilemaps
+type RebuildReport struct {
+	Directories int
+	// Recovered lists the user paths of the entries added back to their filemap
+	Recovered []string
+	// Replaced lists the user paths of the directories whose filemap could not be
+	// decrypted and was replaced
+	Replaced []string
+	// Unrecoverable lists the obfuscated paths of the entries whose name could not be
+	// recovered, either because it does not decrypt or because another entry of the
+	// directory already has the name
+	Unrecoverable []string
+}
+
+// RebuildFilemaps regenerates the filemaps of the directory path and the directories
+// below it from the names on disk, which decrypt on their own. Entries missing from a
+// filemap are added back under their decrypted name, and a filemap that cannot be
+// decrypted is replaced, keeping the damaged file next to it with DamagedFileSuffix.
+// Entries already in a filemap are kept as they are.
+//
+// The filemaps of the ancestors of path must be intact; rebuild from "." if they are
+// not.
+func (fs *GrainFS) RebuildFilemaps(path string) (*RebuildReport, error) {
+	if path == "" {
+		path = "."
+	}
+	path = filepath.Clean(path)
+	if err := fs.checkWritable("rebuild", path); err != nil {
+		return nil, err
+	}
+
+	unlock := fs.lockPaths(nil, []string{path})
+	defer unlock()
+
+	obfuscatedPath, err := fs.getObfuscatedPathCtx(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+
+	report := &RebuildReport{}
+	if err := fs.rebuildFilemap(report, path, obfuscatedPath); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// rebuildFilemap rebuilds the filemap of the user directory dir, stored at
+// obfuscatedDir, and of the directories below it
+func (fs *GrainFS) rebuildFilemap(report *RebuildReport, dir, obfuscatedDir string) error {
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+	report.Directories++
+
+	infos, err := fs.readUnderlyingDir(obfuscatedDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	// Names sort before their collision-suffixed variants, which keeps the name for
+	// the entry obfuscated without a suffix
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	grainfsDir := filepath.Join(obfuscatedDir, GrainFSDir)
+	unlockStore, err := lockStore(fs.underlying, grainfsDir)
+	if err != nil {
+		return err
+	}
+
+	filemap, generation, err := fs.readFilemap(obfuscatedDir)
+	if err != nil {
+		filemapPath := filepath.Join(grainfsDir, FilemapFile)
+		if err := fs.underlying.Rename(filemapPath, filemapPath+DamagedFileSuffix); err != nil {
+			unlockStore()
+			return fmt.Errorf("failed to move damaged filemap aside: %w", err)
+		}
+		filemap, generation = make(FilenameMap), 0
+		report.Replaced = append(report.Replaced, dir)
+	}
+
+	taken := make(map[string]bool, len(filemap))
+	for _, original := range filemap {
+		taken[original] = true
+	}
+
+	changed := false
+	for _, info := range infos {
+		obfuscated := info.Name()
+		if obfuscated == GrainFSDir {
+			continue
+		}
+		if _, exists := filemap[obfuscated]; exists {
+			continue
+		}
+
+		original, err := fs.recoverFilename(obfuscated)
+		if err != nil || taken[original] {
+			report.Unrecoverable = append(report.Unrecoverable, filepath.Join(obfuscatedDir, obfuscated))
+			continue
+		}
+		filemap[obfuscated] = original
+		taken[original] = true
+		changed = true
+		report.Recovered = append(report.Recovered, filepath.Join(dir, original))
+	}
+
+	if changed {
+		err = fs.writeStoreFile(fs.underlying, filepath.Join(grainfsDir, FilemapFile), filemap, generation)
+	}
+	unlockStore()
+	fs.filemapManager.invalidate(fs.cacheKey(dir))
+	if err != nil {
+		return fmt.Errorf("failed to write filemap: %w", err)
+	}
+
+	for _, info := range infos {
+		original, exists := filemap[info.Name()]
+		if !exists || !info.IsDir() {
+			continue
+		}
+		if err := fs.rebuildFilemap(report, filepath.Join(dir, original), filepath.Join(obfuscatedDir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverFilename decrypts the obfuscated name of an entry on disk, ignoring the
+// ".N" suffix added when registering a name collided with another one
+func (fs *GrainFS) recoverFilename(obfuscated string) (string, error) {
+	original, err := deobfuscateFilename(fs.filenameKey, obfuscated)
+	if err == nil {
+		return original, validRecoveredName(original)
+	}
+
+	if i := strings.LastIndexByte(obfuscated, '.'); i > 0 {
+		if _, convErr := strconv.ParseUint(obfuscated[i+1:], 10, 64); convErr == nil {
+			if original, baseErr := deobfuscateFilename(fs.filenameKey, obfuscated[:i]); baseErr == nil {
+				return original, validRecoveredName(original)
+			}
+		}
+	}
+	return "", err
+}
+
+// validRecoveredName checks that a decrypted name can name a directory entry
+func validRecoveredName(name string) error {
+	if name == "" || name == "." || name == ".." || name == GrainFSDir || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("invalid recovered name %q", name)
+	}
+	return nil
+}
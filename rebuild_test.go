@@ -0,0 +1,131 @@
+package grainfs
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestGrainFSRebuildFilemaps(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	for _, name := range []string{"top.txt", "dir/a.txt", "dir/sub/b.txt", "dir/c.txt"} {
+		if _, err := fs.Write(name, []byte("content of "+name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	dir, err := fs.getObfuscatedPath("dir")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+
+	// c.txt was stored under a collision-suffixed name
+	c, err := fs.getObfuscatedPath("dir/c.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+	if err := underlying.Rename(c, c+".1"); err != nil {
+		t.Fatalf("Failed to rename object: %v", err)
+	}
+
+	// Lose the root filemap and damage the one of dir
+	if err := underlying.Remove(filepath.Join(GrainFSDir, FilemapFile)); err != nil {
+		t.Fatalf("Failed to remove filemap: %v", err)
+	}
+	damaged := filepath.Join(dir, GrainFSDir, FilemapFile)
+	if err := util.WriteFile(underlying, damaged, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed to damage filemap: %v", err)
+	}
+	fs.filemapManager.invalidate(fs.cacheKey("."))
+	fs.filemapManager.invalidate(fs.cacheKey("dir"))
+
+	if infos, err := fs.ReadDir("."); err != nil || len(infos) != 0 {
+		t.Fatalf("Expected the root to look empty, got %d entries: %v", len(infos), err)
+	}
+
+	report, err := fs.RebuildFilemaps(".")
+	if err != nil {
+		t.Fatalf("RebuildFilemaps failed: %v", err)
+	}
+	if report.Directories != 3 {
+		t.Fatalf("Expected 3 directories, got %d", report.Directories)
+	}
+	sort.Strings(report.Recovered)
+	expected := []string{"dir", "dir/a.txt", "dir/c.txt", "dir/sub", "top.txt"}
+	if len(report.Recovered) != len(expected) {
+		t.Fatalf("Expected %v to be recovered, got %v", expected, report.Recovered)
+	}
+	for i := range expected {
+		if report.Recovered[i] != expected[i] {
+			t.Fatalf("Expected %v to be recovered, got %v", expected, report.Recovered)
+		}
+	}
+	if len(report.Replaced) != 1 || report.Replaced[0] != "dir" {
+		t.Fatalf("Expected the filemap of dir to be replaced, got %v", report.Replaced)
+	}
+	if _, err := underlying.Stat(damaged + DamagedFileSuffix); err != nil {
+		t.Fatalf("Expected the damaged filemap to be kept: %v", err)
+	}
+
+	for _, name := range []string{"top.txt", "dir/a.txt", "dir/sub/b.txt", "dir/c.txt"} {
+		content, err := util.ReadFile(fs, name)
+		if err != nil || string(content) != "content of "+name {
+			t.Fatalf("Expected %s to be readable, got %q: %v", name, content, err)
+		}
+	}
+
+	// Rebuilding again finds nothing to do
+	report, err = fs.RebuildFilemaps("dir")
+	if err != nil {
+		t.Fatalf("RebuildFilemaps failed: %v", err)
+	}
+	if len(report.Recovered) != 0 || len(report.Replaced) != 0 || len(report.Unrecoverable) != 0 {
+		t.Fatalf("Expected nothing to rebuild, got %+v", report)
+	}
+}
+
+func TestGrainFSRebuildFilemapsUnrecoverable(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("a.txt", []byte("a")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	a, err := fs.getObfuscatedPath("a.txt")
+	if err != nil {
+		t.Fatalf("Failed to get obfuscated path: %v", err)
+	}
+
+	// A stray object and a copy claiming the name of a.txt
+	for _, name := range []string{"not-a-name", a + ".1"} {
+		if err := util.WriteFile(underlying, name, nil, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	report, err := fs.RebuildFilemaps("")
+	if err != nil {
+		t.Fatalf("RebuildFilemaps failed: %v", err)
+	}
+	unrecoverable := make(map[string]bool)
+	for _, path := range report.Unrecoverable {
+		unrecoverable[path] = true
+	}
+	if len(report.Recovered) != 0 || len(unrecoverable) != 2 || !unrecoverable[a+".1"] || !unrecoverable["not-a-name"] {
+		t.Fatalf("Expected 2 unrecoverable entries, got %+v", report)
+	}
+
+	if _, err := fs.ReadOnly().RebuildFilemaps("."); err == nil {
+		t.Fatalf("Expected read-only rebuild to fail")
+	}
+}
@@ -0,0 +1,140 @@
+package grainfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// RemoveAll removes path and everything below it, including the hidden GrainFS state of
+// the removed directories. It returns nil if path does not exist. Entries are removed
+// children first, and every directory keeps its .grainfs state until it is empty, so
+// the tree stays readable after each step: if RemoveAll fails or its context is
+// cancelled part of the way through, calling it again finishes the job.
+func (fs *GrainFS) RemoveAll(path string) error {
+	if err := fs.checkWritable("removeall", path); err != nil {
+		return err
+	}
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	if path == "." || path == string(filepath.Separator) {
+		return &os.PathError{Op: "removeall", Path: path, Err: fmt.Errorf("cannot remove root directory")}
+	}
+
+	// Remove links themselves, not what they point to
+	path, err := fs.resolveSymlinks(path, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	dir, _ := splitUserPath(path)
+	unlock := fs.lockPaths([]string{dir}, []string{path})
+	defer unlock()
+
+	if exists, err := fs.dirExists(dir); err != nil || !exists {
+		return err
+	}
+
+	obfuscatedPath, err := fs.getObfuscatedPath(path)
+	if err != nil {
+		return fmt.Errorf("failed to get obfuscated path: %w", err)
+	}
+
+	info, err := fs.lstatUnderlying(obfuscatedPath)
+	switch {
+	case os.IsNotExist(err):
+		// Already gone, possibly by an earlier attempt that failed before updating the
+		// parent directory
+	case err != nil:
+		return err
+	case info.IsDir():
+		if err := fs.removeTree(obfuscatedPath); err != nil {
+			return err
+		}
+	default:
+		if err := fs.underlying.Remove(obfuscatedPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Update filemap and metadata of the parent, and forget the removed subtree
+	obfuscatedBase := filepath.Base(obfuscatedPath)
+
+	if err := fs.removeFromFilemap(dir, obfuscatedBase); err != nil {
+		return err
+	}
+	fs.filemapManager.invalidate(fs.cacheKey(path))
+
+	return fs.dropMetadata(dir, obfuscatedBase)
+}
+
+// removeTree removes the obfuscated directory obfuscatedDir and everything below it.
+// Linked files release their content object once their entry is gone, so a failure
+// may leak an object but never drops content still linked elsewhere.
+func (fs *GrainFS) removeTree(obfuscatedDir string) error {
+	infos, err := fs.readUnderlyingDir(obfuscatedDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	metadata, _, err := fs.readMetadata(obfuscatedDir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if err := fs.contextErr(); err != nil {
+			return err
+		}
+		if info.Name() == GrainFSDir {
+			continue
+		}
+
+		child := filepath.Join(obfuscatedDir, info.Name())
+		if info.IsDir() {
+			if err := fs.removeTree(child); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fs.underlying.Remove(child); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if md := metadata[info.Name()]; md != nil && md.Object != "" {
+			if err := fs.unlinkObject(md.Object); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The hidden state goes last, once nothing is left that needs it
+	if err := util.RemoveAll(fs.underlying, filepath.Join(obfuscatedDir, GrainFSDir)); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", GrainFSDir, err)
+	}
+	if err := fs.underlying.Remove(obfuscatedDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// lstatUnderlying returns information about an obfuscated path without following
+// symbolic links on backends that support them
+func (fs *GrainFS) lstatUnderlying(obfuscatedPath string) (os.FileInfo, error) {
+	if symlinkFS, ok := fs.underlying.(billy.Symlink); ok {
+		return symlinkFS.Lstat(obfuscatedPath)
+	}
+	return fs.underlying.Stat(obfuscatedPath)
+}
@@ -0,0 +1,185 @@
+package grainfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// failingRemoveFS is a backend whose removals fail once a budget is used up
+type failingRemoveFS struct {
+	billy.Filesystem
+	budget *int
+}
+
+func (f failingRemoveFS) Remove(filename string) error {
+	if *f.budget == 0 {
+		return errors.New("remove failed")
+	}
+	*f.budget--
+	return f.Filesystem.Remove(filename)
+}
+
+// underlyingEntries lists the entries of the underlying root besides .grainfs
+func underlyingEntries(t *testing.T, underlying billy.Filesystem) []string {
+	infos, err := underlying.ReadDir(".")
+	if err != nil {
+		t.Fatalf("Failed to read underlying root: %v", err)
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Name() != GrainFSDir {
+			names = append(names, info.Name())
+		}
+	}
+	return names
+}
+
+func TestGrainFSRemoveAll(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	for _, name := range []string{"tree/a.txt", "tree/sub/b.txt", "tree/sub/deeper/c.txt", "keep.txt"} {
+		if _, err := fs.Write(name, []byte(name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	if err := fs.SetXattr("tree/a.txt", "user.tag", []byte("x")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+	if err := fs.Symlink("../keep.txt", "tree/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.Link("keep.txt", "tree/sub/hard.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	keepEntries := underlyingEntries(t, underlying)
+
+	if err := fs.RemoveAll("tree"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+
+	if _, err := fs.Stat("tree"); !os.IsNotExist(err) {
+		t.Fatalf("Expected tree to be removed, got %v", err)
+	}
+	if entries := underlyingEntries(t, underlying); len(entries) != len(keepEntries)-1 {
+		t.Fatalf("Expected removed tree to leave nothing behind, found %v", entries)
+	}
+
+	// Content linked from outside the tree survives
+	if data := readAll(t, fs, "keep.txt"); string(data) != "keep.txt" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+	if links := linkCount(t, fs, "keep.txt"); links != 1 {
+		t.Fatalf("Expected 1 link, got %d", links)
+	}
+
+	// Nothing stale is cached for the removed tree
+	if _, err := fs.Write("tree/a.txt", []byte("new")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if infos, err := fs.ReadDir("tree"); err != nil || len(infos) != 1 {
+		t.Fatalf("Expected 1 entry in recreated tree, got %d: %v", len(infos), err)
+	}
+	if attrs, err := fs.ListXattr("tree/a.txt"); err != nil || len(attrs) != 0 {
+		t.Fatalf("Expected no xattrs on recreated file, got %v: %v", attrs, err)
+	}
+
+	// Files, missing paths and the root
+	if err := fs.RemoveAll("keep.txt"); err != nil {
+		t.Fatalf("RemoveAll of a file failed: %v", err)
+	}
+	if _, err := fs.Stat("keep.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Expected file to be removed, got %v", err)
+	}
+	if err := fs.RemoveAll("missing/deeper"); err != nil {
+		t.Fatalf("Expected RemoveAll of a missing path to succeed, got %v", err)
+	}
+	if err := fs.RemoveAll("."); err == nil {
+		t.Fatalf("Expected RemoveAll of the root to fail")
+	}
+}
+
+func TestGrainFSRemoveAllResumes(t *testing.T) {
+	budget := -1
+	underlying := failingRemoveFS{memfs.New(), &budget}
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	for _, name := range []string{"tree/a.txt", "tree/b.txt", "tree/sub/c.txt", "tree/sub/d.txt"} {
+		if _, err := fs.Write(name, []byte(name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	if err := fs.Link("tree/a.txt", "outside.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	// Fail part of the way through, at every possible step
+	for failAfter := 0; ; failAfter++ {
+		budget = failAfter
+		err := fs.RemoveAll("tree")
+		budget = -1
+		if err == nil {
+			break
+		}
+
+		// What is left is still a readable tree
+		err = fs.Walk("tree", func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			if data := readAll(t, fs, path); len(data) == 0 {
+				t.Fatalf("Expected content for %s", path)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Walk of partially removed tree failed: %v", err)
+		}
+	}
+
+	// Retrying finishes the job
+	if err := fs.RemoveAll("tree"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if _, err := fs.Stat("tree"); !os.IsNotExist(err) {
+		t.Fatalf("Expected tree to be removed, got %v", err)
+	}
+	if data := readAll(t, fs, "outside.txt"); string(data) != "tree/a.txt" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+}
+
+func TestGrainFSRemoveEmptyDirectory(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("dir/file.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := fs.Remove("dir"); err == nil {
+		t.Fatalf("Expected removing a non-empty directory to fail")
+	}
+	if err := fs.Remove("dir/file.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	// Only the hidden state is left, whatever the backend reports for the first attempt
+	if err := fs.Remove("dir"); err != nil {
+		t.Fatalf("Remove of empty directory failed: %v", err)
+	}
+	if _, err := fs.Stat("dir"); !os.IsNotExist(err) {
+		t.Fatalf("Expected directory to be removed, got %v", err)
+	}
+}
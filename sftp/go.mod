@@ -0,0 +1,17 @@
+module github.com/NovaCove/grainfs/sftp
+
+go 1.24.3
+
+require (
+	github.com/NovaCove/grainfs v0.0.0
+	github.com/go-git/go-billy/v5 v5.6.2
+	github.com/pkg/sftp v1.13.9
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/crypto v0.32.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+)
+
+replace github.com/NovaCove/grainfs => ..
@@ -0,0 +1,453 @@
+// Package sftp serves a GrainFS to SFTP clients, through a github.com/pkg/sftp request
+// server. It is a module of its own, so that the core grainfs module does not depend on
+// the SFTP packages.
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/pkg/sftp"
+)
+
+// sftpHandler serves a GrainFS to a github.com/pkg/sftp request server, see Handlers
+type sftpHandler struct {
+	fs *grainfs.GrainFS
+
+	// writers are the files open for writing, by user path, so that attributes set
+	// while a file is open apply to what is stored when it is closed
+	mutex   sync.Mutex
+	writers map[string]*sftpWriter
+}
+
+// Ensure sftpHandler implements the optional handler interfaces
+var (
+	_ sftp.OpenFileWriter       = (*sftpHandler)(nil)
+	_ sftp.PosixRenameFileCmder = (*sftpHandler)(nil)
+	_ sftp.LstatFileLister      = (*sftpHandler)(nil)
+	_ sftp.ReadlinkFileLister   = (*sftpHandler)(nil)
+)
+
+// Handlers returns the handlers of a github.com/pkg/sftp request server serving fs. Files are read with random access, and files opened for writing are
+// buffered and stored, encrypted, when they are closed. Setstat, rename, POSIX rename,
+// links, mkdir, rmdir, remove, listing, stat, lstat and readlink map to the matching
+// GrainFS operations. Operations honor the context of the request, and errors never
+// reveal obfuscated names.
+func Handlers(fs *grainfs.GrainFS) sftp.Handlers {
+	h := &sftpHandler{fs: fs, writers: make(map[string]*sftpWriter)}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+// rootedUserPath converts a slash-separated rooted name, as SFTP clients send them, to
+// a user path, with "." for the root
+func rootedUserPath(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "."
+	}
+	return filepath.FromSlash(name)
+}
+
+// sftpError converts an error of a GrainFS operation into an error for the client,
+// dropping obfuscated paths
+func sftpError(op, name string, err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Fileread opens a file for reading
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	fs := h.fs.WithContext(r.Context())
+	userPath := rootedUserPath(r.Filepath)
+
+	info, err := fs.Stat(userPath)
+	if err != nil {
+		return nil, sftpError("open", r.Filepath, err)
+	}
+	if info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: errors.New("is a directory")}
+	}
+
+	file, err := fs.Open(userPath)
+	if err != nil {
+		return nil, sftpError("open", r.Filepath, err)
+	}
+	return &sftpReader{file: file, name: r.Filepath}, nil
+}
+
+// Filewrite opens a file for writing
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.OpenFile(r)
+}
+
+// OpenFile opens a file for writing, and reading what is written. The file is created
+// right away if it does not exist, in an existing directory, and its content is
+// replaced when it is closed.
+func (h *sftpHandler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	fs := h.fs.WithContext(r.Context())
+	userPath := rootedUserPath(r.Filepath)
+	flags := r.Pflags()
+	if fs.IsReadOnly() {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	writer := &sftpWriter{handler: h, fs: fs, name: r.Filepath, userPath: userPath, append: flags.Append}
+	info, err := fs.Stat(userPath)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: errors.New("is a directory")}
+	case err == nil && flags.Creat && flags.Excl:
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: os.ErrExist}
+	case err == nil && flags.Trunc:
+		writer.dirty = true
+	case err == nil:
+		if writer.data, err = util.ReadFile(fs, userPath); err != nil {
+			return nil, sftpError("open", r.Filepath, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, sftpError("open", r.Filepath, err)
+	case !flags.Creat:
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: os.ErrNotExist}
+	default:
+		if parent, err := fs.Stat(filepath.Dir(userPath)); err != nil || !parent.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: os.ErrNotExist}
+		}
+		perm := os.FileMode(0644)
+		if r.AttrFlags().Permissions {
+			perm = r.Attributes().FileMode().Perm()
+		}
+		file, err := fs.OpenFile(userPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return nil, sftpError("open", r.Filepath, err)
+		}
+		if err := file.Close(); err != nil {
+			return nil, sftpError("open", r.Filepath, err)
+		}
+	}
+
+	h.mutex.Lock()
+	h.writers[userPath] = writer
+	h.mutex.Unlock()
+	return writer, nil
+}
+
+// Filecmd runs a command changing the filesystem
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	fs := h.fs.WithContext(r.Context())
+	userPath := rootedUserPath(r.Filepath)
+
+	switch r.Method {
+	case "Setstat":
+		h.mutex.Lock()
+		writer := h.writers[userPath]
+		h.mutex.Unlock()
+		if writer != nil {
+			return writer.setstat(r.AttrFlags(), r.Attributes())
+		}
+		return sftpSetstat(fs, r.Filepath, userPath, r.AttrFlags(), r.Attributes())
+
+	case "Rename":
+		// SFTP renames do not replace their target, unlike POSIX ones
+		if _, err := fs.Lstat(rootedUserPath(r.Target)); err == nil {
+			return &os.LinkError{Op: "rename", Old: r.Filepath, New: r.Target, Err: os.ErrExist}
+		}
+		return h.PosixRename(r)
+
+	case "Mkdir":
+		if _, err := fs.Lstat(userPath); err == nil {
+			return &os.PathError{Op: "mkdir", Path: r.Filepath, Err: os.ErrExist}
+		}
+		if parent, err := fs.Stat(filepath.Dir(userPath)); err != nil || !parent.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: r.Filepath, Err: os.ErrNotExist}
+		}
+		perm := os.FileMode(0755)
+		if r.AttrFlags().Permissions {
+			perm = r.Attributes().FileMode().Perm()
+		}
+		if err := fs.MkdirAll(userPath, perm); err != nil {
+			return sftpError("mkdir", r.Filepath, err)
+		}
+		return nil
+
+	case "Rmdir", "Remove":
+		info, err := fs.Lstat(userPath)
+		if err != nil {
+			return sftpError("remove", r.Filepath, err)
+		}
+		if userPath == "." {
+			return &os.PathError{Op: "remove", Path: r.Filepath, Err: os.ErrInvalid}
+		}
+		if r.Method == "Rmdir" && !info.IsDir() {
+			return &os.PathError{Op: "rmdir", Path: r.Filepath, Err: errors.New("not a directory")}
+		}
+		if r.Method == "Remove" && info.IsDir() {
+			return &os.PathError{Op: "remove", Path: r.Filepath, Err: errors.New("is a directory")}
+		}
+		if err := fs.Remove(userPath); err != nil {
+			return sftpError("remove", r.Filepath, err)
+		}
+		return nil
+
+	case "Symlink":
+		// The target is verbatim, and the link is Target
+		if err := fs.Symlink(filepath.FromSlash(r.Filepath), rootedUserPath(r.Target)); err != nil {
+			return sftpError("symlink", r.Target, err)
+		}
+		return nil
+
+	case "Link":
+		if err := fs.Link(userPath, rootedUserPath(r.Target)); err != nil {
+			return sftpError("link", r.Target, err)
+		}
+		return nil
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename moves a file or directory, replacing its target if it is a file
+func (h *sftpHandler) PosixRename(r *sftp.Request) error {
+	oldPath, newPath := rootedUserPath(r.Filepath), rootedUserPath(r.Target)
+	if oldPath == "." || newPath == "." {
+		return &os.LinkError{Op: "rename", Old: r.Filepath, New: r.Target, Err: os.ErrInvalid}
+	}
+
+	if err := h.fs.WithContext(r.Context()).Rename(oldPath, newPath); err != nil {
+		return sftpError("rename", r.Filepath, err)
+	}
+	return nil
+}
+
+// sftpSetstat applies the attributes attrs selected by flags to the file at userPath
+func sftpSetstat(fs *grainfs.GrainFS, name, userPath string, flags sftp.FileAttrFlags, attrs *sftp.FileStat) error {
+	if flags.Size {
+		content, err := util.ReadFile(fs, userPath)
+		if err != nil {
+			return sftpError("truncate", name, err)
+		}
+		if err := util.WriteFile(fs, userPath, resize(content, int64(attrs.Size)), 0644); err != nil {
+			return sftpError("truncate", name, err)
+		}
+	}
+	if flags.Permissions {
+		if err := fs.Chmod(userPath, attrs.FileMode().Perm()); err != nil {
+			return sftpError("chmod", name, err)
+		}
+	}
+	if flags.UidGid {
+		if err := fs.Chown(userPath, int(attrs.UID), int(attrs.GID)); err != nil {
+			return sftpError("chown", name, err)
+		}
+	}
+	if flags.Acmodtime {
+		if err := fs.Chtimes(userPath, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return sftpError("chtimes", name, err)
+		}
+	}
+	return nil
+}
+
+// resize returns data truncated or extended with zeros to size
+func resize(data []byte, size int64) []byte {
+	if size <= int64(len(data)) {
+		return data[:size]
+	}
+	return append(data, make([]byte, size-int64(len(data)))...)
+}
+
+// Filelist lists a directory, or stats a file following symbolic links
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	fs := h.fs.WithContext(r.Context())
+	userPath := rootedUserPath(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		infos, err := fs.ReadDir(userPath)
+		if err != nil {
+			return nil, sftpError("readdir", r.Filepath, err)
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name() < infos[j].Name()
+		})
+		return sftpLister(infos), nil
+
+	case "Stat":
+		info, err := fs.Stat(userPath)
+		if err != nil {
+			return nil, sftpError("stat", r.Filepath, err)
+		}
+		return sftpLister{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat stats a file without following symbolic links
+func (h *sftpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := h.fs.WithContext(r.Context()).Lstat(rootedUserPath(r.Filepath))
+	if err != nil {
+		return nil, sftpError("lstat", r.Filepath, err)
+	}
+	return sftpLister{info}, nil
+}
+
+// Readlink returns the verbatim target of a symbolic link
+func (h *sftpHandler) Readlink(name string) (string, error) {
+	target, err := h.fs.Readlink(rootedUserPath(name))
+	if err != nil {
+		return "", sftpError("readlink", name, err)
+	}
+	return filepath.ToSlash(target), nil
+}
+
+// sftpLister is a listing served to an SFTP client
+type sftpLister []os.FileInfo
+
+// ListAt copies the entries from offset into ls
+func (l sftpLister) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpReader is a file opened for reading by an SFTP client, which may read from several
+// offsets concurrently
+type sftpReader struct {
+	mutex sync.Mutex
+	file  io.ReadCloser
+	name  string
+}
+
+// ReadAt reads decrypted data from offset off
+func (f *sftpReader) ReadAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	n, err := f.file.(io.ReaderAt).ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		return n, sftpError("read", f.name, err)
+	}
+	return n, err
+}
+
+// Close closes the file
+func (f *sftpReader) Close() error {
+	return f.file.Close()
+}
+
+// sftpWriter is a file opened for writing by an SFTP client, which may write at any
+// offset. The content is held in memory and stored when the file is closed.
+type sftpWriter struct {
+	handler  *sftpHandler
+	fs       *grainfs.GrainFS
+	name     string
+	userPath string
+	append   bool
+
+	mutex sync.Mutex
+	data  []byte
+	dirty bool
+	// pending are the attributes set while the file is open, applied once it is stored
+	pending []sftpAttrs
+}
+
+// sftpAttrs are attributes set by an SFTP client
+type sftpAttrs struct {
+	flags sftp.FileAttrFlags
+	attrs *sftp.FileStat
+}
+
+// WriteAt writes p at offset off, or at the end of the file if it was opened for
+// appending
+func (f *sftpWriter) WriteAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.append {
+		off = int64(len(f.data))
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrInvalid}
+	}
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = resize(f.data, end)
+	}
+	copy(f.data[off:], p)
+	f.dirty = true
+	return len(p), nil
+}
+
+// ReadAt reads what the file holds at offset off, written content included
+func (f *sftpWriter) ReadAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// setstat records attributes set while the file is open. Sizes apply to the content
+// right away.
+func (f *sftpWriter) setstat(flags sftp.FileAttrFlags, attrs *sftp.FileStat) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if flags.Size {
+		f.data = resize(f.data, int64(attrs.Size))
+		f.dirty = true
+		flags.Size = false
+	}
+	f.pending = append(f.pending, sftpAttrs{flags: flags, attrs: attrs})
+	return nil
+}
+
+// Close stores the content of the file if it changed, then applies the attributes set
+// while it was open
+func (f *sftpWriter) Close() error {
+	f.handler.mutex.Lock()
+	if f.handler.writers[f.userPath] == f {
+		delete(f.handler.writers, f.userPath)
+	}
+	f.handler.mutex.Unlock()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.dirty {
+		if err := util.WriteFile(f.fs, f.userPath, f.data, 0644); err != nil {
+			return sftpError("write", f.name, err)
+		}
+	}
+	for _, pending := range f.pending {
+		if err := sftpSetstat(f.fs, f.name, f.userPath, pending.flags, pending.attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
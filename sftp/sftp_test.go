@@ -0,0 +1,183 @@
+package sftp
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/pkg/sftp"
+)
+
+// newSFTPClient serves fs to an SFTP client over an in-process connection
+func newSFTPClient(t *testing.T, fs *grainfs.GrainFS) *sftp.Client {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, Handlers(fs))
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatalf("Failed to start SFTP client: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestSFTP(t *testing.T) {
+	fs, err := grainfs.New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "docs/hello.txt", []byte("hello, world"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	client := newSFTPClient(t, fs)
+
+	infos, err := client.ReadDir("/docs")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "hello.txt" || infos[0].Size() != 12 {
+		t.Fatalf("Unexpected listing %+v", infos)
+	}
+
+	file, err := client.Open("/docs/hello.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	if n, err := file.ReadAt(buf, 7); n != 5 || string(buf) != "world" {
+		t.Fatalf("Expected to read world at 7, got %q: %v", buf[:n], err)
+	}
+	file.Close()
+
+	// Uploads are stored when closed, with the attributes set meanwhile
+	if err := client.Mkdir("/up"); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	file, err = client.OpenFile("/up/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("hello,"), 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := file.Chmod(0600); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := client.Chtimes("/up/a.txt", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "up/a.txt"); err != nil || string(content) != "hello,world" {
+		t.Fatalf("Expected the uploaded content, got %q: %v", content, err)
+	}
+	info, err := fs.Stat("up/a.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mode 0600 and time %v, got %v %v", mtime, info.Mode(), info.ModTime())
+	}
+	if _, err := client.OpenFile("/up/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL); err == nil {
+		t.Fatalf("Expected an exclusive create of an existing file to fail")
+	}
+
+	file, err = client.OpenFile("/up/a.txt", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := file.Write([]byte("!")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	file.Close()
+	if err := client.Truncate("/up/a.txt", 5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "up/a.txt"); err != nil || string(content) != "hello" {
+		t.Fatalf("Expected the truncated content, got %q: %v", content, err)
+	}
+
+	// Plain renames do not replace their target, POSIX ones do
+	if err := client.Rename("/docs/hello.txt", "/up/a.txt"); err == nil {
+		t.Fatalf("Expected a rename onto an existing file to fail")
+	}
+	if err := client.PosixRename("/docs/hello.txt", "/up/a.txt"); err != nil {
+		t.Fatalf("PosixRename failed: %v", err)
+	}
+	if err := client.Rename("/up/a.txt", "/up/b.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "up/b.txt"); err != nil || string(content) != "hello, world" {
+		t.Fatalf("Expected the renamed content, got %q: %v", content, err)
+	}
+
+	if err := client.Symlink("b.txt", "/up/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if target, err := client.ReadLink("/up/link"); err != nil || target != "b.txt" {
+		t.Fatalf("Expected the link to point at b.txt, got %q: %v", target, err)
+	}
+	if info, err := client.Lstat("/up/link"); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected Lstat to show a link, got %+v: %v", info, err)
+	}
+	if info, err := client.Stat("/up/link"); err != nil || info.Size() != 12 {
+		t.Fatalf("Expected Stat to follow the link, got %+v: %v", info, err)
+	}
+
+	if err := client.RemoveDirectory("/up"); err == nil {
+		t.Fatalf("Expected removing a non-empty directory to fail")
+	}
+	for _, name := range []string{"/up/link", "/up/b.txt"} {
+		if err := client.Remove(name); err != nil {
+			t.Fatalf("Remove %s failed: %v", name, err)
+		}
+	}
+	if err := client.RemoveDirectory("/up"); err != nil {
+		t.Fatalf("RemoveDirectory failed: %v", err)
+	}
+	if _, err := client.Stat("/up"); !os.IsNotExist(err) {
+		t.Fatalf("Expected /up to be removed, got %v", err)
+	}
+}
+
+func TestSFTPReadOnly(t *testing.T) {
+	fs, err := grainfs.New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	client := newSFTPClient(t, fs.ReadOnly())
+
+	file, err := client.Open("/a.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if content, err := io.ReadAll(file); err != nil || string(content) != "alpha" {
+		t.Fatalf("Expected to read alpha, got %q: %v", content, err)
+	}
+	file.Close()
+
+	if _, err := client.Create("/b.txt"); !os.IsPermission(err) {
+		t.Fatalf("Expected creating a file to be denied, got %v", err)
+	}
+	if err := client.Remove("/a.txt"); !os.IsPermission(err) {
+		t.Fatalf("Expected removing a file to be denied, got %v", err)
+	}
+}
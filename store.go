@@ -0,0 +1,240 @@
+package grainfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// Cross-process safety
+//
+// Several processes may open the same backing store. Every update of a directory's
+// filemap or metadata, and of the link table, runs under an advisory lock on the lock
+// file in the .grainfs directory holding it. The lock is taken with billy.File.Lock
+// where the backend supports it; otherwise an exclusively created lock file is used.
+// While holding the lock the state is read back from disk, so updates made by other
+// processes are never overwritten. Each process still serves reads from its own cache:
+// entries added elsewhere are picked up when a lookup misses it, other changes once the
+// cached state is reloaded.
+//
+// State files also carry a generation number, incremented by every write. A write
+// fails with ErrConflict if the generation on disk is not the one the update was based
+// on, which can only happen when a writer did not follow the locking protocol.
+
+var (
+	// ErrConflict is returned when GrainFS state was changed concurrently by another
+	// writer in a way that conflicts with an update
+	ErrConflict = errors.New("grainfs state modified concurrently")
+
+	// ErrLockTimeout is returned when a lock held by another process could not be
+	// acquired in time
+	ErrLockTimeout = errors.New("timed out waiting for grainfs lock")
+)
+
+const (
+	// storeFormatVersion is the format version of GrainFS state files
+	storeFormatVersion = 2
+
+	// staleLockAge is the age after which an exclusively created lock file is considered
+	// abandoned by a crashed process
+	staleLockAge = time.Minute
+)
+
+// storeLockTimeout bounds how long to wait for an exclusively created lock file
+var storeLockTimeout = 10 * time.Second
+
+// storeFile is the on-disk format of GrainFS state files. Files written by earlier
+// versions hold the entries alone.
+type storeFile struct {
+	Version    int             `json:"version"`
+	Generation uint64          `json:"generation"`
+	Entries    json.RawMessage `json:"entries"`
+}
+
+// readStoreFile reads and decrypts the state file at path, returning its encoded entries
+// and generation. Files without a generation are reported as generation 0.
+func (fs *GrainFS) readStoreFile(bfs billy.Basic, path string) ([]byte, uint64, error) {
+	file, err := bfs.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	encryptedData, err := io.ReadAll(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+
+	decryptedData, err := decryptData(fs.aead, encryptedData)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decrypt %s: %w", filepath.Base(path), err)
+	}
+
+	var stored storeFile
+	if err := json.Unmarshal(decryptedData, &stored); err != nil || stored.Version < storeFormatVersion {
+		return decryptedData, 0, nil
+	}
+
+	return stored.Entries, stored.Generation, nil
+}
+
+// writeStoreFile encrypts entries and atomically replaces the state file at path with
+// them, as the generation following base. The caller must hold the store lock.
+func (fs *GrainFS) writeStoreFile(bfs billy.Basic, path string, entries interface{}, base uint64) error {
+	// Make sure nobody bypassed the lock since the update read the file
+	_, current, err := fs.readStoreFile(bfs, path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if current != base {
+		return fmt.Errorf("%s changed from generation %d to %d: %w", filepath.Base(path), base, current, ErrConflict)
+	}
+
+	encodedEntries, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+
+	jsonData, err := json.Marshal(storeFile{
+		Version:    storeFormatVersion,
+		Generation: base + 1,
+		Entries:    encodedEntries,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+
+	encryptedData, err := encryptData(fs.aead, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(path), err)
+	}
+
+	return writeFileAtomic(bfs, path, encryptedData)
+}
+
+// lockStore takes the advisory lock of the .grainfs directory grainfsDir, returning a
+// function releasing it.
+func lockStore(bfs billy.Filesystem, grainfsDir string) (unlock func(), err error) {
+	if err := bfs.MkdirAll(grainfsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", grainfsDir, err)
+	}
+
+	lockPath := filepath.Join(grainfsDir, LockFile)
+	file, err := bfs.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := file.Lock(); err == nil {
+		return func() {
+			file.Unlock()
+			file.Close()
+		}, nil
+	}
+	file.Close()
+
+	// The backend has no file locking, fall back to a lock file created exclusively
+	return lockStoreExclusive(bfs, lockPath+".excl")
+}
+
+// lockStoreExclusive takes a lock by exclusively creating lockPath, which holds the
+// time it was taken. Lock files older than staleLockAge are broken.
+func lockStoreExclusive(bfs billy.Filesystem, lockPath string) (unlock func(), err error) {
+	deadline := time.Now().Add(storeLockTimeout)
+	backoff := time.Millisecond
+
+	for {
+		err := createLockFile(bfs, lockPath)
+		if err == nil {
+			return func() {
+				bfs.Remove(lockPath)
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		if lockFileStale(bfs, lockPath) {
+			breakStaleLock(bfs, lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s: %w", lockPath, ErrLockTimeout)
+		}
+		time.Sleep(backoff)
+		if backoff < 100*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+// createLockFile exclusively creates the lock file at lockPath, holding the time it was
+// taken
+func createLockFile(bfs billy.Filesystem, lockPath string) error {
+	file, err := bfs.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		bfs.Remove(lockPath)
+	}
+	return err
+}
+
+// breakStaleLock removes the stale lock file at lockPath. Another process may have broken
+// it first and taken the lock afresh since it was found stale, so breakers take turns
+// through a guard file and check the lock again while holding it. The lock cannot be
+// taken while the stale file exists, and no other breaker can remove it meanwhile, so a
+// fresh lock is never removed. A guard left behind by a crashed breaker goes stale too.
+func breakStaleLock(bfs billy.Filesystem, lockPath string) {
+	guardPath := lockPath + ".break"
+	if err := createLockFile(bfs, guardPath); err != nil {
+		// Another process is breaking the lock, unless it crashed doing so
+		if os.IsExist(err) && lockFileStale(bfs, guardPath) {
+			bfs.Remove(guardPath)
+		}
+		return
+	}
+	defer bfs.Remove(guardPath)
+
+	if lockFileStale(bfs, lockPath) {
+		bfs.Remove(lockPath)
+	}
+}
+
+// lockFileStale reports whether the exclusively created lock file at lockPath was left
+// behind by a process that crashed while holding it
+func lockFileStale(bfs billy.Filesystem, lockPath string) bool {
+	file, err := bfs.Open(lockPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return false
+	}
+
+	taken, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		// Either still being written by its owner, or its owner crashed before writing it
+		info, err := bfs.Stat(lockPath)
+		return err == nil && time.Since(info.ModTime()) > staleLockAge
+	}
+
+	return time.Since(time.Unix(0, taken)) > staleLockAge
+}
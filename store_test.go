@@ -0,0 +1,284 @@
+package grainfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// noLockFS is a backend whose files do not support locking
+type noLockFS struct {
+	billy.Filesystem
+}
+
+type noLockFile struct {
+	billy.File
+}
+
+func (f noLockFile) Lock() error {
+	return errors.New("locking not supported")
+}
+
+func (n noLockFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := n.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return noLockFile{file}, nil
+}
+
+// writeFromProcesses writes files into shared directories from several GrainFS
+// instances, each standing in for a separate process with its own caches, and checks
+// that none of the entries is lost.
+func writeFromProcesses(t *testing.T, newBackend func() billy.Filesystem) {
+	const processes = 4
+	const filesPerProcess = 40
+
+	instances := make([]*GrainFS, processes)
+	for i := range instances {
+		fs, err := New(newBackend(), "test-password-123")
+		if err != nil {
+			t.Fatalf("Failed to create GrainFS: %v", err)
+		}
+		instances[i] = fs
+	}
+
+	var wg sync.WaitGroup
+	for p, fs := range instances {
+		for j := 0; j < filesPerProcess; j++ {
+			wg.Add(1)
+			go func(fs *GrainFS, p, j int) {
+				defer wg.Done()
+				name := fmt.Sprintf("shared-%d/p%d-%d", j%3, p, j)
+				if _, err := fs.Write(name, []byte(name)); err != nil {
+					t.Errorf("Write %s failed: %v", name, err)
+					return
+				}
+				if err := fs.Chmod(name, 0600); err != nil {
+					t.Errorf("Chmod %s failed: %v", name, err)
+				}
+			}(fs, p, j)
+		}
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	fresh, err := New(newBackend(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	for _, fs := range append(instances, fresh) {
+		total := 0
+		for d := 0; d < 3; d++ {
+			infos, err := fs.ReadDir(fmt.Sprintf("shared-%d", d))
+			if err != nil {
+				t.Fatalf("ReadDir failed: %v", err)
+			}
+			for _, info := range infos {
+				// Other instances may have cached metadata before the last change
+				if fs == fresh && info.Mode().Perm() != 0600 {
+					t.Fatalf("Expected mode 0600 for %s, got %v", info.Name(), info.Mode())
+				}
+			}
+			total += len(infos)
+		}
+		if total != processes*filesPerProcess {
+			t.Fatalf("Expected %d files, found %d", processes*filesPerProcess, total)
+		}
+	}
+}
+
+func TestGrainFSMultipleProcesses(t *testing.T) {
+	dir := t.TempDir()
+	writeFromProcesses(t, func() billy.Filesystem {
+		return osfs.New(dir)
+	})
+}
+
+func TestGrainFSMultipleProcessesWithoutFileLocking(t *testing.T) {
+	dir := t.TempDir()
+	writeFromProcesses(t, func() billy.Filesystem {
+		return noLockFS{osfs.New(dir)}
+	})
+
+	// Exclusive lock files are released
+	matches, err := filepath.Glob(filepath.Join(dir, "*", GrainFSDir, LockFile+".excl"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Fatalf("Expected lock files to be removed, found %v", matches)
+	}
+}
+
+func TestGrainFSExclusiveLockFile(t *testing.T) {
+	underlying := memfs.New()
+	lockPath := filepath.Join(GrainFSDir, LockFile+".excl")
+
+	writeLockFile := func(taken time.Time) {
+		file, err := underlying.Create(lockPath)
+		if err != nil {
+			t.Fatalf("Failed to create lock file: %v", err)
+		}
+		file.Write([]byte(strconv.FormatInt(taken.UnixNano(), 10)))
+		file.Close()
+	}
+
+	// A lock held by another process times out
+	previous := storeLockTimeout
+	storeLockTimeout = 50 * time.Millisecond
+	defer func() { storeLockTimeout = previous }()
+
+	writeLockFile(time.Now())
+	if _, err := lockStoreExclusive(underlying, lockPath); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("Expected ErrLockTimeout, got %v", err)
+	}
+
+	// A lock abandoned by a crashed process is broken
+	writeLockFile(time.Now().Add(-2 * staleLockAge))
+	unlock, err := lockStoreExclusive(underlying, lockPath)
+	if err != nil {
+		t.Fatalf("Failed to break stale lock: %v", err)
+	}
+	unlock()
+
+	if _, err := underlying.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected lock file to be removed on unlock, got %v", err)
+	}
+
+	// Two waiters find the same lock stale: the first breaks it and takes a fresh lock,
+	// which the second must leave in place
+	writeLockFile(time.Now().Add(-2 * staleLockAge))
+	breakStaleLock(underlying, lockPath)
+	writeLockFile(time.Now())
+	breakStaleLock(underlying, lockPath)
+	if lockFileStale(underlying, lockPath) {
+		t.Fatalf("Expected the fresh lock to be held")
+	}
+	matches, err := util.Glob(underlying, lockPath+"*")
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected only the fresh lock file, found %v", matches)
+	}
+
+	// A stale lock is left alone while another process is breaking it, and the guard of
+	// a breaker that crashed is broken in turn
+	guardPath := lockPath + ".break"
+	for _, guardTaken := range []time.Time{time.Now(), time.Now().Add(-2 * staleLockAge)} {
+		writeLockFile(time.Now().Add(-2 * staleLockAge))
+		guard, err := underlying.Create(guardPath)
+		if err != nil {
+			t.Fatalf("Failed to create guard file: %v", err)
+		}
+		guard.Write([]byte(strconv.FormatInt(guardTaken.UnixNano(), 10)))
+		guard.Close()
+
+		breakStaleLock(underlying, lockPath)
+		if _, err := underlying.Stat(lockPath); err != nil {
+			t.Fatalf("Expected the lock to be left to the guard holder, got %v", err)
+		}
+		_, err = underlying.Stat(guardPath)
+		if stale := guardTaken.Before(time.Now().Add(-staleLockAge)); stale != os.IsNotExist(err) {
+			t.Fatalf("Expected the guard to be removed only when stale, got %v", err)
+		}
+	}
+	breakStaleLock(underlying, lockPath)
+	if _, err := underlying.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected the stale lock to be broken, got %v", err)
+	}
+}
+
+func TestGrainFSGenerationConflict(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("file.txt", []byte("content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	filemapPath := filepath.Join(GrainFSDir, FilemapFile)
+	filemap, generation, err := fs.readFilemap(".")
+	if err != nil {
+		t.Fatalf("Failed to read filemap: %v", err)
+	}
+	if generation == 0 {
+		t.Fatalf("Expected filemap to carry a generation")
+	}
+
+	// A writer ignoring the lock bumps the generation behind our back
+	if err := fs.writeStoreFile(underlying, filemapPath, filemap, generation); err != nil {
+		t.Fatalf("Failed to write filemap: %v", err)
+	}
+
+	err = fs.writeStoreFile(underlying, filemapPath, filemap, generation)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict, got %v", err)
+	}
+}
+
+func TestGrainFSLegacyFilemapFormat(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("old.txt", []byte("from an older version")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Rewrite the filemap as a bare map, the way earlier versions stored it
+	filemap, _, err := fs.readFilemap(".")
+	if err != nil {
+		t.Fatalf("Failed to read filemap: %v", err)
+	}
+	jsonData, err := json.Marshal(filemap)
+	if err != nil {
+		t.Fatalf("Failed to marshal filemap: %v", err)
+	}
+	encryptedData, err := encryptData(fs.aead, jsonData)
+	if err != nil {
+		t.Fatalf("Failed to encrypt filemap: %v", err)
+	}
+	if err := writeFileAtomic(underlying, filepath.Join(GrainFSDir, FilemapFile), encryptedData); err != nil {
+		t.Fatalf("Failed to write legacy filemap: %v", err)
+	}
+
+	reopened, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to reopen GrainFS: %v", err)
+	}
+	if data := readAll(t, reopened, "old.txt"); string(data) != "from an older version" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+
+	// The first update upgrades the filemap to the versioned format
+	if _, err := reopened.Write("new.txt", []byte("new")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	upgraded, generation, err := reopened.readFilemap(".")
+	if err != nil {
+		t.Fatalf("Failed to read filemap: %v", err)
+	}
+	if generation != 1 || len(upgraded) != 2 {
+		t.Fatalf("Expected 2 entries at generation 1, got %d at %d", len(upgraded), generation)
+	}
+}
@@ -0,0 +1,249 @@
+package grainfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// maxSymlinkHops bounds the number of symbolic links followed while resolving a path
+const maxSymlinkHops = 40
+
+// resolveSymlinks resolves the symbolic links in a user path, returning the path of the
+// entry it designates. Absolute targets are interpreted relative to the root of this
+// GrainFS, relative targets relative to the link's directory, and ".." never climbs
+// above the root, so links keep working in chrooted views and after the volume moves.
+// The last component is only followed when followLast is set. Components that do not
+// exist are passed through unchanged.
+func (fs *GrainFS) resolveSymlinks(name string, followLast bool) (string, error) {
+	remaining := splitPathComponents(name)
+	resolved := "."
+	hops := 0
+
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		if len(remaining) == 0 && !followLast {
+			resolved = filepath.Join(resolved, part)
+			break
+		}
+
+		target, isLink, err := fs.symlinkTarget(resolved, part)
+		if err != nil {
+			return "", err
+		}
+		if !isLink {
+			resolved = filepath.Join(resolved, part)
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", &os.PathError{Op: "resolve", Path: name, Err: fmt.Errorf("too many levels of symbolic links")}
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = "."
+		}
+		remaining = append(splitPathComponents(target), remaining...)
+	}
+
+	return resolved, nil
+}
+
+// splitPathComponents splits a path into its non-empty components, dropping "."
+func splitPathComponents(path string) []string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	components := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" && part != "." {
+			components = append(components, part)
+		}
+	}
+	return components
+}
+
+// symlinkIndex holds the obfuscated names of the entries of a directory that may be
+// symbolic links: the links themselves, and names of shared objects, which may have
+// been linked from a symbolic link
+type symlinkIndex map[string]bool
+
+// newSymlinkIndex indexes the symbolic links among the metadata records of a directory
+func newSymlinkIndex(metadata MetadataMap) symlinkIndex {
+	index := make(symlinkIndex)
+	for obfuscated, md := range metadata {
+		if md != nil && (md.Mode&os.ModeSymlink != 0 || md.Object != "") {
+			index[obfuscated] = true
+		}
+	}
+	return index
+}
+
+// loadSymlinkIndex returns the index of the symbolic links in a user directory
+func (fs *GrainFS) loadSymlinkIndex(dir string) (symlinkIndex, error) {
+	key := fs.cacheKey(dir)
+	fm := fs.filemapManager
+
+	fm.cacheMutex.RLock()
+	index, exists := fm.symlinkCache[key]
+	fm.cacheMutex.RUnlock()
+	if exists {
+		return index, nil
+	}
+
+	// Loading the metadata caches its index, unless it changed meanwhile
+	metadata, err := fs.loadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	return newSymlinkIndex(metadata), nil
+}
+
+// symlinkTarget reports whether the entry base in the user directory dir is a symbolic
+// link and, if so, returns its target. Only the entries of the directory's symlink
+// index are looked at, so directories without links cost a cached lookup.
+func (fs *GrainFS) symlinkTarget(dir, base string) (string, bool, error) {
+	if base == GrainFSDir {
+		return "", false, nil
+	}
+
+	index, err := fs.loadSymlinkIndex(dir)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	if len(index) == 0 {
+		return "", false, nil
+	}
+
+	filemap, err := fs.loadFilemap(dir)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load filemap: %w", err)
+	}
+
+	obfuscated := ""
+	for candidate := range index {
+		if filemap[candidate] == base {
+			obfuscated = candidate
+			break
+		}
+	}
+	if obfuscated == "" {
+		return "", false, nil
+	}
+
+	record, err := fs.getMetadata(dir, obfuscated)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	md, err := fs.resolveMetadata(record)
+	if err != nil {
+		return "", false, err
+	}
+	if md == nil || md.Mode&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+
+	storage, path, err := fs.contentLocation(filepath.Join(dir, base))
+	if err != nil {
+		return "", false, err
+	}
+
+	file, err := storage.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	encryptedData, err := io.ReadAll(file)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read symlink: %w", err)
+	}
+
+	target, err := decryptData(fs.aead, encryptedData)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decrypt symlink: %w", err)
+	}
+
+	return string(target), true, nil
+}
+
+// createSymlink stores a symbolic link at the resolved user path link
+func (fs *GrainFS) createSymlink(target, link string) error {
+	dir, base := splitUserPath(link)
+
+	// Parent directories of link are created as necessary
+	unlock, err := fs.lockDirForCreate(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := fs.getObfuscatedPath(link)
+	if err != nil {
+		return fmt.Errorf("failed to get obfuscated link path: %w", err)
+	}
+	if _, err := fs.underlying.Stat(existing); err == nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: os.ErrExist}
+	}
+
+	obfuscatedDir, err := fs.getObfuscatedPath(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get obfuscated directory path: %w", err)
+	}
+
+	obfuscatedBase, err := fs.obfuscateFilename(dir, base)
+	if err != nil {
+		return fmt.Errorf("failed to obfuscate link name: %w", err)
+	}
+
+	encryptedData, err := encryptData(fs.aead, []byte(target))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt symlink: %w", err)
+	}
+
+	file, err := fs.underlying.OpenFile(filepath.Join(obfuscatedDir, obfuscatedBase), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(encryptedData); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write symlink: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return fs.setMetadata(dir, obfuscatedBase, newFileMetadata(os.ModeSymlink|os.ModePerm))
+}
+
+// readLegacySymlink reads a link created by earlier versions of GrainFS, which were
+// native symlinks on the underlying filesystem pointing at obfuscated paths.
+func (fs *GrainFS) readLegacySymlink(link, resolved string) (string, error) {
+	symlinkFS, ok := fs.underlying.(billy.Symlink)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: fmt.Errorf("not a symbolic link")}
+	}
+
+	obfuscatedLink, err := fs.getObfuscatedPath(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to get obfuscated link path: %w", err)
+	}
+
+	obfuscatedTarget, err := symlinkFS.Readlink(obfuscatedLink)
+	if err != nil {
+		return "", err
+	}
+
+	// Convert back to user path
+	return fs.getUserPath(obfuscatedTarget)
+}
@@ -0,0 +1,255 @@
+package grainfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestGrainFSSymlinkTargets(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	content := []byte("pointed at")
+	if _, err := fs.Write("data/real.txt", content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	links := map[string]string{
+		"data/relative":     "real.txt",
+		"links/up":          "../data/real.txt",
+		"links/absolute":    "/data/real.txt",
+		"links/dir":         "../data",
+		"links/dangling":    "does/not/exist-yet",
+		"links/chained":     "up",
+		"links/with spaces": "./../data/./real.txt",
+	}
+	for link, target := range links {
+		if err := fs.Symlink(target, link); err != nil {
+			t.Fatalf("Symlink %s -> %s failed: %v", link, target, err)
+		}
+	}
+
+	// Readlink returns the exact original target string
+	for link, target := range links {
+		got, err := fs.Readlink(link)
+		if err != nil {
+			t.Fatalf("Readlink %s failed: %v", link, err)
+		}
+		if got != target {
+			t.Fatalf("Readlink %s: expected %q, got %q", link, target, got)
+		}
+	}
+
+	// Links are resolved by GrainFS for Open and Stat
+	for _, link := range []string{"data/relative", "links/up", "links/absolute", "links/chained", "links/with spaces", "links/dir/real.txt"} {
+		file, err := fs.Open(link)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", link, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", link, err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Fatalf("Expected %q through %s, got %q", content, link, data)
+		}
+	}
+
+	info, err := fs.Stat("links/dir")
+	if err != nil {
+		t.Fatalf("Failed to stat directory link: %v", err)
+	}
+	if !info.IsDir() || info.Name() != "dir" {
+		t.Fatalf("Expected directory named dir, got %s (%v)", info.Name(), info.Mode())
+	}
+
+	info, err = fs.Lstat("links/dir")
+	if err != nil {
+		t.Fatalf("Failed to lstat link: %v", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected Lstat to report a symlink, got %v", info.Mode())
+	}
+
+	// Dangling links can be created and report not-exist when followed
+	if _, err := fs.Stat("links/dangling"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist for dangling link, got %v", err)
+	}
+
+	// Symlinking onto an existing name fails
+	if err := fs.Symlink("elsewhere", "data/relative"); !os.IsExist(err) {
+		t.Fatalf("Expected exist error, got %v", err)
+	}
+
+	// Readlink on a regular file fails
+	if _, err := fs.Readlink("data/real.txt"); err == nil {
+		t.Fatalf("Expected Readlink on a regular file to fail")
+	}
+
+	// The target never appears in plaintext on the underlying filesystem
+	var walk func(path string)
+	walk = func(path string) {
+		infos, err := underlying.ReadDir(path)
+		if err != nil {
+			t.Fatalf("Failed to read underlying directory: %v", err)
+		}
+		for _, info := range infos {
+			full := underlying.Join(path, info.Name())
+			if info.IsDir() {
+				walk(full)
+				continue
+			}
+			file, err := underlying.Open(full)
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", full, err)
+			}
+			raw, _ := io.ReadAll(file)
+			file.Close()
+			if bytes.Contains(raw, []byte("does/not/exist-yet")) {
+				t.Fatalf("Found plaintext symlink target in %s", full)
+			}
+		}
+	}
+	walk(".")
+}
+
+func TestGrainFSSymlinkLoopsAndRemoval(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if err := fs.Symlink("b", "a"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.Symlink("a", "b"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if _, err := fs.Stat("a"); err == nil {
+		t.Fatalf("Expected Stat on a symlink loop to fail")
+	}
+
+	// Removing and renaming operate on the link itself
+	if _, err := fs.Write("target.txt", []byte("keep me")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Symlink("target.txt", "link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.Rename("link", "moved-link"); err != nil {
+		t.Fatalf("Rename of link failed: %v", err)
+	}
+	if target, err := fs.Readlink("moved-link"); err != nil || target != "target.txt" {
+		t.Fatalf("Expected moved link to keep target, got %q (%v)", target, err)
+	}
+	if err := fs.Remove("moved-link"); err != nil {
+		t.Fatalf("Remove of link failed: %v", err)
+	}
+	if _, err := fs.Stat("target.txt"); err != nil {
+		t.Fatalf("Removing a link must not remove its target: %v", err)
+	}
+}
+
+func TestGrainFSSymlinkInChroot(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	content := []byte("inside the jail")
+	if _, err := fs.Write("jail/etc/config", content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.Symlink("/etc/config", "jail/absolute"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.Symlink("../../etc/config", "jail/sub/escape"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	chrooted, err := fs.Chroot("jail")
+	if err != nil {
+		t.Fatalf("Chroot failed: %v", err)
+	}
+
+	// Absolute targets resolve against the chroot and ".." stops at its root
+	for _, link := range []string{"absolute", "sub/escape"} {
+		if data := readAll(t, chrooted, link); !bytes.Equal(data, content) {
+			t.Fatalf("Expected %q through %s in chroot, got %q", content, link, data)
+		}
+	}
+
+	target, err := chrooted.Readlink("absolute")
+	if err != nil || target != "/etc/config" {
+		t.Fatalf("Expected verbatim target /etc/config, got %q (%v)", target, err)
+	}
+}
+
+func TestGrainFSSymlinkIndex(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	indexSize := func(fs *GrainFS, dir string) int {
+		index, err := fs.loadSymlinkIndex(dir)
+		if err != nil {
+			t.Fatalf("Failed to load symlink index of %s: %v", dir, err)
+		}
+		return len(index)
+	}
+
+	// Directories of regular files have nothing to resolve
+	for _, name := range []string{"docs/a.txt", "docs/b.txt"} {
+		if _, err := fs.Write(name, []byte(name)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if n := indexSize(fs, "docs"); n != 0 {
+		t.Fatalf("Expected no symlinks in docs, got %d", n)
+	}
+
+	// The index follows links as they are created, linked and removed
+	if err := fs.Symlink("a.txt", "docs/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.MkdirAll("other", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := fs.Link("docs/link", "other/link"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if n := indexSize(fs, "docs"); n != 1 {
+		t.Fatalf("Expected one symlink in docs, got %d", n)
+	}
+
+	// Other instances build the index from the metadata on disk
+	fs2, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to reopen GrainFS: %v", err)
+	}
+	if n := indexSize(fs2, "other"); n != 1 {
+		t.Fatalf("Expected one symlink in other, got %d", n)
+	}
+	if target, err := fs2.Readlink("other/link"); err != nil || target != "a.txt" {
+		t.Fatalf("Expected the linked symlink to keep its target, got %q (%v)", target, err)
+	}
+
+	if err := fs.Remove("docs/link"); err != nil {
+		t.Fatalf("Remove of link failed: %v", err)
+	}
+	if n := indexSize(fs, "docs"); n != 0 {
+		t.Fatalf("Expected no symlinks in docs after removal, got %d", n)
+	}
+	if data := readAll(t, fs, "docs/a.txt"); !bytes.Equal(data, []byte("docs/a.txt")) {
+		t.Fatalf("Expected docs/a.txt to be readable, got %q", data)
+	}
+}
@@ -0,0 +1,161 @@
+package grainfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// TreeOptions configures ImportTree and ExportTree
+type TreeOptions struct {
+	// Exclude skips the entries whose slash-separated path relative to the copied tree,
+	// or whose name, matches one of these path.Match patterns. The contents of skipped
+	// directories are skipped too.
+	Exclude []string
+
+	// Progress, if set, is called after each entry is copied, with its path relative to
+	// the copied tree and its information on the source
+	Progress func(path string, info os.FileInfo)
+}
+
+// ImportTree copies the file or directory tree at srcPath of src into the filesystem at
+// dstPath, streaming file contents. Modes and modification times are preserved, and
+// symbolic links are copied as links. Other special files are skipped.
+func (fs *GrainFS) ImportTree(src billy.Filesystem, srcPath, dstPath string, opts TreeOptions) error {
+	return copyTree(src, srcPath, fs, dstPath, opts)
+}
+
+// ExportTree copies the file or directory tree at srcPath of the filesystem to dstPath
+// of dst, the reverse of ImportTree. Modes and modification times are only preserved if
+// dst implements billy.Change, which osfs does not.
+func (fs *GrainFS) ExportTree(srcPath string, dst billy.Filesystem, dstPath string, opts TreeOptions) error {
+	return copyTree(fs, srcPath, dst, dstPath, opts)
+}
+
+// copyTree copies the tree at srcPath of src to dstPath of dst
+func copyTree(src billy.Filesystem, srcPath string, dst billy.Filesystem, dstPath string, opts TreeOptions) error {
+	for _, pattern := range opts.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	return copyEntry(src, srcPath, dst, dstPath, ".", opts)
+}
+
+// copyEntry copies the entry at srcPath of src, at rel in the copied tree, to dstPath
+// of dst
+func copyEntry(src billy.Filesystem, srcPath string, dst billy.Filesystem, dstPath, rel string, opts TreeOptions) error {
+	info, err := src.Lstat(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", rel, err)
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := src.Readlink(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to read link %s: %w", rel, err)
+		}
+		if err := dst.Symlink(target, dstPath); err != nil {
+			return fmt.Errorf("failed to create link %s: %w", rel, err)
+		}
+
+	case info.IsDir():
+		if err := dst.MkdirAll(dstPath, info.Mode().Perm()|0700); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", rel, err)
+		}
+
+		infos, err := src.ReadDir(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", rel, err)
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name() < infos[j].Name()
+		})
+
+		for _, child := range infos {
+			childRel := path.Join(filepath.ToSlash(rel), child.Name())
+			if excluded(opts.Exclude, childRel, child.Name()) {
+				continue
+			}
+			if err := copyEntry(src, src.Join(srcPath, child.Name()), dst, dst.Join(dstPath, child.Name()), childRel, opts); err != nil {
+				return err
+			}
+		}
+
+		// Copying the children changed the times, and may need write permission
+		if err := copyAttributes(dst, dstPath, info); err != nil {
+			return fmt.Errorf("failed to set attributes of %s: %w", rel, err)
+		}
+
+	case info.Mode().IsRegular():
+		if err := copyFile(src, srcPath, dst, dstPath, info); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		if err := copyAttributes(dst, dstPath, info); err != nil {
+			return fmt.Errorf("failed to set attributes of %s: %w", rel, err)
+		}
+
+	default:
+		// Devices, sockets and pipes have no content to copy
+		return nil
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(rel, info)
+	}
+	return nil
+}
+
+// copyFile streams the content of the regular file at srcPath of src to dstPath of dst
+func copyFile(src billy.Filesystem, srcPath string, dst billy.Filesystem, dstPath string, info os.FileInfo) error {
+	in, err := src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := dst.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyAttributes gives the entry at path of dst the mode and modification time in info,
+// if dst supports changing them
+func copyAttributes(dst billy.Filesystem, path string, info os.FileInfo) error {
+	change, ok := dst.(billy.Change)
+	if !ok {
+		return nil
+	}
+
+	if err := change.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return change.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// excluded reports whether the entry at the slash-separated path rel named name matches
+// one of the exclude patterns
+func excluded(patterns []string, rel, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
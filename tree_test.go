@@ -0,0 +1,142 @@
+package grainfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// hostFS is an osfs filesystem supporting billy.Change
+type hostFS struct {
+	billy.Filesystem
+	root string
+}
+
+func (h *hostFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(filepath.Join(h.root, name), mode)
+}
+
+func (h *hostFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(filepath.Join(h.root, name), uid, gid)
+}
+
+func (h *hostFS) Chown(name string, uid, gid int) error {
+	return os.Chown(filepath.Join(h.root, name), uid, gid)
+}
+
+func (h *hostFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(filepath.Join(h.root, name), atime, mtime)
+}
+
+func TestGrainFSImportExportTree(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	root := t.TempDir()
+	host := &hostFS{Filesystem: osfs.New(root), root: root}
+	files := map[string]string{
+		"src/a.txt":         "alpha",
+		"src/sub/b.txt":     "beta",
+		"src/sub/skip.log":  "excluded by name",
+		"src/cache/c.txt":   "excluded with its directory",
+		"src/sub/deep/d.md": "delta",
+	}
+	for name, content := range files {
+		if err := util.WriteFile(host, name, []byte(content), 0640); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := host.Symlink("a.txt", "src/link"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := host.Chmod("src/a.txt", 0600); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := host.Chtimes("src/sub/b.txt", mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	var imported []string
+	err = fs.ImportTree(host, "src", "data", TreeOptions{
+		Exclude:  []string{"*.log", "cache"},
+		Progress: func(path string, info os.FileInfo) { imported = append(imported, path) },
+	})
+	if err != nil {
+		t.Fatalf("ImportTree failed: %v", err)
+	}
+	sort.Strings(imported)
+	expected := []string{".", "a.txt", "link", "sub", "sub/b.txt", "sub/deep", "sub/deep/d.md"}
+	if len(imported) != len(expected) {
+		t.Fatalf("Expected progress for %v, got %v", expected, imported)
+	}
+	for i := range expected {
+		if imported[i] != expected[i] {
+			t.Fatalf("Expected progress for %v, got %v", expected, imported)
+		}
+	}
+
+	content, err := util.ReadFile(fs, "data/sub/deep/d.md")
+	if err != nil || string(content) != "delta" {
+		t.Fatalf("Expected imported content, got %q: %v", content, err)
+	}
+	for _, name := range []string{"data/sub/skip.log", "data/cache"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be excluded, got %v", name, err)
+		}
+	}
+	info, err := fs.Stat("data/a.txt")
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected mode 0600, got %v: %v", info, err)
+	}
+	info, err = fs.Stat("data/sub/b.txt")
+	if err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mtime %v, got %v: %v", mtime, info, err)
+	}
+	if target, err := fs.Readlink("data/link"); err != nil || target != "a.txt" {
+		t.Fatalf("Expected link to a.txt, got %q: %v", target, err)
+	}
+
+	// Export it back and compare
+	if err := fs.ExportTree("data", host, "out", TreeOptions{}); err != nil {
+		t.Fatalf("ExportTree failed: %v", err)
+	}
+	for name, content := range map[string]string{"out/a.txt": "alpha", "out/sub/b.txt": "beta", "out/sub/deep/d.md": "delta"} {
+		data, err := util.ReadFile(host, name)
+		if err != nil || string(data) != content {
+			t.Fatalf("Expected %s to hold %q, got %q: %v", name, content, data, err)
+		}
+	}
+	info, err = host.Stat("out/sub/b.txt")
+	if err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected exported mtime %v, got %v: %v", mtime, info, err)
+	}
+	info, err = host.Stat("out/a.txt")
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected exported mode 0600, got %v: %v", info, err)
+	}
+	if target, err := host.Readlink("out/link"); err != nil || target != "a.txt" {
+		t.Fatalf("Expected exported link to a.txt, got %q: %v", target, err)
+	}
+
+	// A single file can be copied too
+	if err := fs.ImportTree(host, "src/a.txt", "single.txt", TreeOptions{}); err != nil {
+		t.Fatalf("ImportTree of a file failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "single.txt"); err != nil || string(content) != "alpha" {
+		t.Fatalf("Expected imported file, got %q: %v", content, err)
+	}
+
+	if err := fs.ImportTree(host, "src", "bad", TreeOptions{Exclude: []string{"["}}); err == nil {
+		t.Fatalf("Expected an invalid pattern to fail")
+	}
+}
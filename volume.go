@@ -0,0 +1,318 @@
+package grainfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// Init creates a new volume on underlying, configured by opts, and opens it. Unlike New,
+// it fails with an error satisfying os.IsExist if underlying already holds a volume.
+func Init(underlying billy.Filesystem, password string, opts VolumeOptions) (*GrainFS, error) {
+	if underlying == nil {
+		return nil, fmt.Errorf("underlying filesystem cannot be nil")
+	}
+	if password == "" {
+		return nil, fmt.Errorf("password cannot be empty")
+	}
+
+	configPath := filepath.Join(GrainFSDir, ConfigFile)
+	if _, err := underlying.Stat(configPath); err == nil {
+		return nil, &os.PathError{Op: "init", Path: configPath, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to check for an existing volume: %w", err)
+	}
+
+	config, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	fs := &GrainFS{underlying: underlying}
+	if err := fs.saveConfig(config); err != nil {
+		return nil, fmt.Errorf("failed to initialize config: %w", err)
+	}
+
+	return New(underlying, password)
+}
+
+// VolumeInfo describes a volume, as returned by Info
+type VolumeInfo struct {
+	Version    string
+	KDF        string
+	Iterations int
+	// Memory in KiB and Threads are only set for argon2id
+	Memory  uint32
+	Threads uint8
+	Cipher  string
+	Layout  string
+	// NeedsUpgrade reports whether the volume predates ConfigVersion, see Upgrade
+	NeedsUpgrade bool
+
+	Directories int
+	Files       int
+	Symlinks    int
+	// PlaintextSize is the total size of the files, and CiphertextSize the total size
+	// of everything stored on the underlying filesystem, GrainFS state included
+	PlaintextSize  int64
+	CiphertextSize int64
+}
+
+// Info describes the volume and the tree the filesystem shows: the format and
+// encryption parameters, and the number and sizes of the entries. Entries that cannot
+// be read are skipped; Check reports them.
+func (fs *GrainFS) Info() (*VolumeInfo, error) {
+	config := fs.config
+	info := &VolumeInfo{
+		Version:      config.Version,
+		KDF:          config.kdf(),
+		Iterations:   config.Iterations,
+		Memory:       config.Memory,
+		Threads:      config.Threads,
+		Cipher:       config.cipher(),
+		Layout:       config.layout(),
+		NeedsUpgrade: config.needsUpgrade(),
+	}
+
+	err := fs.Walk(".", func(walked string, entry os.FileInfo, err error) error {
+		if err != nil {
+			if walked == "." {
+				return err
+			}
+			return nil
+		}
+		switch {
+		case entry.Mode()&os.ModeSymlink != 0:
+			info.Symlinks++
+		case entry.IsDir():
+			info.Directories++
+		default:
+			info.Files++
+			info.PlaintextSize += entry.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if info.CiphertextSize, err = fs.underlyingSize("."); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// underlyingSize returns the total size of the files under the obfuscated path
+func (fs *GrainFS) underlyingSize(obfuscatedPath string) (int64, error) {
+	if err := fs.contextErr(); err != nil {
+		return 0, err
+	}
+
+	infos, err := fs.readUnderlyingDir(obfuscatedPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() {
+			dirSize, err := fs.underlyingSize(filepath.Join(obfuscatedPath, info.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += dirSize
+		} else {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// needsUpgrade reports whether the volume predates ConfigVersion
+func (config *Config) needsUpgrade() bool {
+	return major(config.Version) < major(ConfigVersion)
+}
+
+// UpgradeReport is the result of Upgrade
+type UpgradeReport struct {
+	FromVersion string
+	ToVersion   string
+	Directories int
+	// StateFiles is the number of filemaps, metadata files and link tables rewritten in
+	// the versioned format
+	StateFiles int
+	// Symlinks is the number of native symbolic links converted, and Unconverted the
+	// user paths of those whose target could not be resolved, which are left as they are
+	Symlinks    int
+	Unconverted []string
+}
+
+// Upgrade migrates a volume created by an earlier version of GrainFS to the current
+// format in place. State files are rewritten in the versioned format, symbolic links
+// stored as native links on the underlying filesystem become GrainFS links, with their
+// target made absolute so that it resolves as before, and the configuration records the
+// key derivation, cipher and layout the volume uses. The version is only updated once
+// everything else is done: the volume stays usable during the upgrade, and an
+// interrupted upgrade is resumed by running it again. Upgrading a current volume does
+// nothing.
+//
+// Upgrade locks the whole filesystem while it runs, and must be called on the root of
+// the volume.
+func (fs *GrainFS) Upgrade() (*UpgradeReport, error) {
+	if err := fs.checkWritable("upgrade", "."); err != nil {
+		return nil, err
+	}
+	if fs.rootPath != "." {
+		return nil, fmt.Errorf("upgrade must run on the root of the volume, not %s", fs.rootPath)
+	}
+
+	unlock := fs.lockPaths(nil, []string{"."})
+	defer unlock()
+
+	report := &UpgradeReport{FromVersion: fs.config.Version, ToVersion: ConfigVersion}
+	if !fs.config.needsUpgrade() {
+		report.ToVersion = fs.config.Version
+		return report, nil
+	}
+
+	if err := fs.upgradeDir(".", ".", report); err != nil {
+		return nil, err
+	}
+	upgraded, err := fs.upgradeStoreFile(fs.volume, filepath.Join(GrainFSDir, LinksFile))
+	if err != nil {
+		return nil, err
+	}
+	if upgraded {
+		report.StateFiles++
+	}
+
+	config := *fs.config
+	config.KDF, config.Cipher, config.Layout = config.kdf(), config.cipher(), config.layout()
+	config.Version = ConfigVersion
+	if err := fs.saveConfig(&config); err != nil {
+		return nil, err
+	}
+	// Views share the configuration
+	*fs.config = config
+
+	return report, nil
+}
+
+// upgradeDir upgrades the state files and symbolic links of the directory at the user
+// path dir and its subdirectories
+func (fs *GrainFS) upgradeDir(dir, obfuscatedDir string, report *UpgradeReport) error {
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+	report.Directories++
+
+	for _, name := range []string{FilemapFile, MetadataFile} {
+		upgraded, err := fs.upgradeStoreFile(fs.underlying, filepath.Join(obfuscatedDir, GrainFSDir, name))
+		if err != nil {
+			return err
+		}
+		if upgraded {
+			report.StateFiles++
+		}
+	}
+
+	filemap, _, err := fs.readFilemap(obfuscatedDir)
+	if err != nil {
+		return err
+	}
+
+	for _, obfuscated := range sortedKeys(filemap) {
+		userPath := filepath.Join(dir, filemap[obfuscated])
+		obfuscatedPath := filepath.Join(obfuscatedDir, obfuscated)
+
+		info, err := fs.lstatUnderlying(obfuscatedPath)
+		if os.IsNotExist(err) {
+			// Dangling entries are left to Check
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", userPath, err)
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			converted, err := fs.upgradeSymlink(dir, obfuscated, obfuscatedPath)
+			if err != nil {
+				return fmt.Errorf("failed to convert symbolic link %s: %w", userPath, err)
+			}
+			if converted {
+				report.Symlinks++
+			} else {
+				report.Unconverted = append(report.Unconverted, userPath)
+			}
+		case info.IsDir():
+			if err := fs.upgradeDir(userPath, obfuscatedPath, report); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// upgradeStoreFile rewrites the state file at path of bfs in the versioned format if it
+// is in the legacy one, reporting whether it did
+func (fs *GrainFS) upgradeStoreFile(bfs billy.Filesystem, path string) (bool, error) {
+	unlock, err := lockStore(bfs, filepath.Dir(path))
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	data, generation, err := fs.readStoreFile(bfs, path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if generation > 0 {
+		return false, nil
+	}
+
+	if err := fs.writeStoreFile(bfs, path, json.RawMessage(data), generation); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// upgradeSymlink converts the native symbolic link obfuscated in the directory at the
+// user path dir, whose target is an obfuscated path, to a GrainFS link. It reports false
+// if the target cannot be resolved.
+func (fs *GrainFS) upgradeSymlink(dir, obfuscated, obfuscatedPath string) (bool, error) {
+	symlinkFS, ok := fs.underlying.(billy.Symlink)
+	if !ok {
+		return false, nil
+	}
+
+	obfuscatedTarget, err := symlinkFS.Readlink(obfuscatedPath)
+	if err != nil {
+		return false, err
+	}
+	target, err := fs.getUserPath(obfuscatedTarget)
+	if err != nil {
+		return false, nil
+	}
+
+	encryptedData, err := encryptData(fs.aead, []byte(path.Join("/", filepath.ToSlash(target))))
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt symlink: %w", err)
+	}
+
+	// The record comes first: until the link is replaced it cannot be read, and a
+	// resumed upgrade still finds the native link to convert
+	if err := fs.setMetadata(dir, obfuscated, newFileMetadata(os.ModeSymlink|os.ModePerm)); err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(fs.underlying, obfuscatedPath, encryptedData); err != nil {
+		return false, fmt.Errorf("failed to write symlink: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,204 @@
+package grainfs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestGrainFSInit(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := Init(underlying, "test-password-123", VolumeOptions{
+		KDF:        KDFArgon2id,
+		Iterations: 1,
+		Memory:     1024,
+		Cipher:     CipherChaCha20Poly1305,
+	})
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if err := util.WriteFile(fs, "docs/a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// The settings are recorded, and used when the volume is opened again
+	reopened, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to reopen volume: %v", err)
+	}
+	if content, err := util.ReadFile(reopened, "docs/a.txt"); err != nil || string(content) != "alpha" {
+		t.Fatalf("Expected content to round trip, got %q: %v", content, err)
+	}
+	config := reopened.config
+	if config.KDF != KDFArgon2id || config.Iterations != 1 || config.Memory != 1024 || config.Threads != DefaultArgon2Threads ||
+		config.Cipher != CipherChaCha20Poly1305 || config.Layout != LayoutNested || config.Version != ConfigVersion {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+
+	// Another cipher cannot read the content
+	aead, err := newAEAD(CipherAES256GCM, make([]byte, KeySize))
+	if err != nil {
+		t.Fatalf("newAEAD failed: %v", err)
+	}
+	if _, err := decryptData(aead, mustEncrypt(t, reopened, "data")); err == nil {
+		t.Fatalf("Expected data encrypted with ChaCha20-Poly1305 not to decrypt with AES")
+	}
+
+	if _, err := Init(underlying, "test-password-123", VolumeOptions{}); !os.IsExist(err) {
+		t.Fatalf("Expected Init of an existing volume to fail with exist, got %v", err)
+	}
+	for _, opts := range []VolumeOptions{{KDF: "md5"}, {Cipher: "rot13"}, {Layout: "flat"}, {Iterations: -1}} {
+		if _, err := Init(memfs.New(), "test-password-123", opts); err == nil {
+			t.Fatalf("Expected Init with %+v to fail", opts)
+		}
+	}
+
+	// Volumes from newer versions are refused
+	newer := *config
+	newer.Version = "99.0.0"
+	if err := reopened.saveConfig(&newer); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	if _, err := New(underlying, "test-password-123"); err == nil {
+		t.Fatalf("Expected a newer volume version to be refused")
+	}
+}
+
+// mustEncrypt encrypts plaintext with the cipher of fs
+func mustEncrypt(t *testing.T, fs *GrainFS, plaintext string) []byte {
+	data, err := encryptData(fs.aead, []byte(plaintext))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	return data
+}
+
+func TestGrainFSInfo(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := Init(underlying, "test-password-123", VolumeOptions{})
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	for name, content := range map[string]string{"a.txt": "alpha", "docs/b.txt": "beta", "docs/sub/c.txt": "gamma"} {
+		if err := util.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := fs.Symlink("a.txt", "link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	info, err := fs.Info()
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.Version != ConfigVersion || info.KDF != KDFPBKDF2 || info.Iterations != DefaultIterations ||
+		info.Cipher != CipherAES256GCM || info.Layout != LayoutNested || info.NeedsUpgrade {
+		t.Fatalf("Unexpected format %+v", info)
+	}
+	if info.Directories != 3 || info.Files != 3 || info.Symlinks != 1 {
+		t.Fatalf("Expected 3 directories, 3 files and 1 link, got %+v", info)
+	}
+	if info.PlaintextSize != 14 {
+		t.Fatalf("Expected 14 bytes of plaintext, got %d", info.PlaintextSize)
+	}
+	// Every file carries a nonce and a tag, and the state is stored too
+	if info.CiphertextSize <= info.PlaintextSize+3*(NonceSize+TagSize) {
+		t.Fatalf("Expected more ciphertext than plaintext, got %d", info.CiphertextSize)
+	}
+}
+
+func TestGrainFSUpgrade(t *testing.T) {
+	underlying := memfs.New()
+	fs, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "docs/a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Make it look like a volume of the first version: a bare configuration, filemaps
+	// without a generation, and symbolic links stored as native links
+	obfuscatedDocs, err := fs.ObfuscatedPath("docs")
+	if err != nil {
+		t.Fatalf("ObfuscatedPath failed: %v", err)
+	}
+	obfuscatedTarget, err := fs.ObfuscatedPath("docs/a.txt")
+	if err != nil {
+		t.Fatalf("ObfuscatedPath failed: %v", err)
+	}
+	obfuscatedLink, err := fs.obfuscateFilename("docs", "link")
+	if err != nil {
+		t.Fatalf("Failed to obfuscate link name: %v", err)
+	}
+	if err := underlying.Symlink(obfuscatedTarget, filepath.Join(obfuscatedDocs, obfuscatedLink)); err != nil {
+		t.Fatalf("Failed to create native link: %v", err)
+	}
+	filemap, _, err := fs.readFilemap(obfuscatedDocs)
+	if err != nil {
+		t.Fatalf("Failed to read filemap: %v", err)
+	}
+	jsonData, err := json.Marshal(filemap)
+	if err != nil {
+		t.Fatalf("Failed to marshal filemap: %v", err)
+	}
+	if err := writeFileAtomic(underlying, filepath.Join(obfuscatedDocs, GrainFSDir, FilemapFile), mustEncrypt(t, fs, string(jsonData))); err != nil {
+		t.Fatalf("Failed to write legacy filemap: %v", err)
+	}
+	legacy := &Config{Salt: fs.config.Salt, Iterations: fs.config.Iterations, Version: LegacyConfigVersion}
+	if err := fs.saveConfig(legacy); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	old, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to open legacy volume: %v", err)
+	}
+	if info, err := old.Info(); err != nil || !info.NeedsUpgrade || info.KDF != KDFPBKDF2 || info.Cipher != CipherAES256GCM {
+		t.Fatalf("Expected a legacy volume needing an upgrade, got %+v: %v", info, err)
+	}
+	if target, err := old.Readlink("docs/link"); err != nil || target != "docs/a.txt" {
+		t.Fatalf("Expected the legacy link to read, got %q: %v", target, err)
+	}
+
+	report, err := old.Upgrade()
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	if report.FromVersion != LegacyConfigVersion || report.ToVersion != ConfigVersion || report.Directories != 2 ||
+		report.StateFiles != 1 || report.Symlinks != 1 || len(report.Unconverted) != 0 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	// The link keeps pointing at the same file, now as a GrainFS link
+	if target, err := old.Readlink("docs/link"); err != nil || target != "/docs/a.txt" {
+		t.Fatalf("Expected the converted link to point at /docs/a.txt, got %q: %v", target, err)
+	}
+	if content, err := util.ReadFile(old, "docs/link"); err != nil || string(content) != "alpha" {
+		t.Fatalf("Expected to read through the converted link, got %q: %v", content, err)
+	}
+	if _, generation, err := old.readFilemap(obfuscatedDocs); err != nil || generation == 0 {
+		t.Fatalf("Expected the filemap in the versioned format, got generation %d: %v", generation, err)
+	}
+
+	upgraded, err := New(underlying, "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to reopen upgraded volume: %v", err)
+	}
+	config := upgraded.config
+	if config.Version != ConfigVersion || config.KDF != KDFPBKDF2 || config.Cipher != CipherAES256GCM || config.Layout != LayoutNested {
+		t.Fatalf("Unexpected upgraded config %+v", config)
+	}
+	if report, err := upgraded.Upgrade(); err != nil || report.Directories != 0 || report.FromVersion != ConfigVersion {
+		t.Fatalf("Expected upgrading a current volume to do nothing, got %+v: %v", report, err)
+	}
+	if report, err := upgraded.Check(CheckOptions{}); err != nil || !report.OK() {
+		t.Fatalf("Expected a consistent volume, got %+v: %v", report, err)
+	}
+}
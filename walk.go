@@ -0,0 +1,218 @@
+package grainfs
+
+import (
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Walk walks the file tree rooted at root, calling fn for each file or directory in
+// the tree, including root, in lexical order of their original names. It follows the
+// semantics of filepath.Walk: symbolic links are not followed, and fn may return
+// filepath.SkipDir to skip a directory. Walking stops with the error of the view's
+// context once it is done.
+func (fs *GrainFS) Walk(root string, fn filepath.WalkFunc) error {
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fs.walk(root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk recursively descends path, calling fn
+func (fs *GrainFS) walk(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	infos, err := fs.ReadDir(path)
+	err1 := fn(path, info, err)
+	// If err != nil, walk can't descend into this directory. If err1 != nil, fn asked to
+	// skip it or stop walking, so stop here either way.
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	for _, child := range infos {
+		childPath := filepath.Join(path, child.Name())
+		if err := fs.walk(childPath, child, fn); err != nil {
+			if !child.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkDir walks the file tree rooted at root like Walk, but following the semantics of
+// filepath.WalkDir: fn is passed an fs.DirEntry, and directories are listed only
+// after fn has been called for them.
+func (fs *GrainFS) WalkDir(root string, fn iofs.WalkDirFunc) error {
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fs.walkDir(root, iofs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkDir recursively descends path, calling fn
+func (fs *GrainFS) walkDir(path string, d iofs.DirEntry, fn iofs.WalkDirFunc) error {
+	if err := fs.contextErr(); err != nil {
+		return err
+	}
+
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			// Successfully skipped directory
+			err = nil
+		}
+		return err
+	}
+
+	infos, err := fs.ReadDir(path)
+	if err != nil {
+		// Second call, to report the ReadDir error
+		if err = fn(path, d, err); err != nil {
+			if err == filepath.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	for _, child := range infos {
+		childPath := filepath.Join(path, child.Name())
+		if err := fs.walkDir(childPath, iofs.FileInfoToDirEntry(child), fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Glob returns the names of all files matching pattern, like filepath.Glob. Patterns
+// are matched against the original, plaintext names. The only possible returned error
+// is filepath.ErrBadPattern, or the error of the view's context once it is done.
+func (fs *GrainFS) Glob(pattern string) ([]string, error) {
+	// Check pattern is well-formed
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
+
+	if !hasGlobMeta(pattern) {
+		if _, err := fs.Lstat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	dir, file := filepath.Split(pattern)
+	dir = cleanGlobPath(dir)
+
+	if !hasGlobMeta(dir) {
+		return fs.glob(dir, file, nil)
+	}
+
+	// Prevent infinite recursion
+	if dir == pattern {
+		return nil, filepath.ErrBadPattern
+	}
+
+	dirMatches, err := fs.Glob(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, d := range dirMatches {
+		if matches, err = fs.glob(d, file, matches); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+// glob appends the entries of dir matching pattern to matches. I/O errors are
+// ignored, like in filepath.Glob.
+func (fs *GrainFS) glob(dir, pattern string, matches []string) ([]string, error) {
+	if err := fs.contextErr(); err != nil {
+		return nil, err
+	}
+
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return matches, nil
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		matched, err := filepath.Match(pattern, name)
+		if err != nil {
+			return matches, err
+		}
+		if matched {
+			matches = append(matches, filepath.Join(dir, name))
+		}
+	}
+	return matches, nil
+}
+
+// cleanGlobPath prepares a directory of a glob pattern for listing
+func cleanGlobPath(path string) string {
+	switch path {
+	case "":
+		return "."
+	case string(filepath.Separator):
+		return path
+	default:
+		return path[:len(path)-1] // chop off trailing separator
+	}
+}
+
+// hasGlobMeta reports whether path contains any of the magic characters recognized by
+// filepath.Match
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
@@ -0,0 +1,165 @@
+package grainfs
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// newWalkFS returns a filesystem holding a small tree to walk
+func newWalkFS(t *testing.T) *GrainFS {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	for _, name := range []string{"b/two.txt", "b/one.txt", "a.txt", "c/skipped/deep.txt", "d.txt"} {
+		if _, err := fs.Write(name, []byte(name)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	if err := fs.Symlink("b", "link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	return fs
+}
+
+func TestGrainFSWalk(t *testing.T) {
+	fs := newWalkFS(t)
+
+	var visited []string
+	err := fs.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path)
+		if path == "c" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+
+	expected := ". a.txt b b/one.txt b/two.txt c d.txt link"
+	if got := strings.Join(visited, " "); got != expected {
+		t.Fatalf("Expected walk order %q, got %q", expected, got)
+	}
+
+	// A missing root is reported to fn
+	walkErr := errors.New("stop")
+	err = fs.Walk("missing", func(path string, info os.FileInfo, err error) error {
+		if !os.IsNotExist(err) {
+			t.Fatalf("Expected not-exist error for missing root, got %v", err)
+		}
+		return walkErr
+	})
+	if err != walkErr {
+		t.Fatalf("Expected error from fn, got %v", err)
+	}
+
+	// Cancelling the context stops the walk
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	visited = nil
+	err = fs.WithContext(ctx).Walk(".", func(path string, info os.FileInfo, err error) error {
+		visited = append(visited, path)
+		if path == "b" {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Walk to fail with context.Canceled, got %v", err)
+	}
+	if len(visited) != 3 {
+		t.Fatalf("Expected walk to stop after b, visited %v", visited)
+	}
+}
+
+func TestGrainFSWalkDir(t *testing.T) {
+	fs := newWalkFS(t)
+
+	var visited []string
+	err := fs.WalkDir(".", func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path)
+		if path == "c" {
+			return filepath.SkipDir
+		}
+		if path == "link" && d.Type() != iofs.ModeSymlink {
+			t.Fatalf("Expected link to be reported as a symbolic link, got %v", d.Type())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir failed: %v", err)
+	}
+
+	expected := ". a.txt b b/one.txt b/two.txt c d.txt link"
+	if got := strings.Join(visited, " "); got != expected {
+		t.Fatalf("Expected walk order %q, got %q", expected, got)
+	}
+
+	// SkipAll stops the walk without an error
+	visited = nil
+	err = fs.WalkDir(".", func(path string, d iofs.DirEntry, err error) error {
+		visited = append(visited, path)
+		if path == "b/one.txt" {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil || len(visited) != 4 {
+		t.Fatalf("Expected walk to stop at b/one.txt, visited %v: %v", visited, err)
+	}
+}
+
+func TestGrainFSGlob(t *testing.T) {
+	fs := newWalkFS(t)
+
+	tests := map[string][]string{
+		"*.txt":         {"a.txt", "d.txt"},
+		"b/*":           {"b/one.txt", "b/two.txt"},
+		"*/*.txt":       {"b/one.txt", "b/two.txt", "link/one.txt", "link/two.txt"},
+		"?/*/deep.txt":  {"c/skipped/deep.txt"},
+		"[ab]*":         {"a.txt", "b"},
+		"d.txt":         {"d.txt"},
+		"missing":       nil,
+		"missing/*.txt": nil,
+	}
+	for pattern, expected := range tests {
+		matches, err := fs.Glob(pattern)
+		if err != nil {
+			t.Fatalf("Glob %q failed: %v", pattern, err)
+		}
+		if !reflect.DeepEqual(matches, expected) {
+			t.Fatalf("Expected %q to match %v, got %v", pattern, expected, matches)
+		}
+	}
+
+	if _, err := fs.Glob("[a"); err != filepath.ErrBadPattern {
+		t.Fatalf("Expected ErrBadPattern, got %v", err)
+	}
+
+	// Patterns never match the hidden state
+	if matches, _ := fs.Glob(".*"); len(matches) != 0 {
+		t.Fatalf("Expected no hidden matches, got %v", matches)
+	}
+
+	// Cancelled views stop globbing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fs.WithContext(ctx).Glob("*"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Glob to fail with context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/NovaCove/grainfs/webdav
+
+go 1.24.3
+
+require (
+	github.com/NovaCove/grainfs v0.0.0
+	github.com/go-git/go-billy/v5 v5.6.2
+	github.com/studio-b12/gowebdav v0.9.0
+	golang.org/x/net v0.34.0
+)
+
+require (
+	golang.org/x/crypto v0.32.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+)
+
+replace github.com/NovaCove/grainfs => ..
@@ -0,0 +1,289 @@
+// Package webdav serves a GrainFS over WebDAV, through golang.org/x/net/webdav, so that
+// it can be mounted as a network drive. It is a module of its own, so that the core
+// grainfs module does not depend on the WebDAV packages.
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/net/webdav"
+)
+
+// writeFlags are the OpenFile flags that may change a file
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// FileSystem adapts a GrainFS to webdav.FileSystem, so that it can be served with a
+// webdav.Handler. Names are slash-separated and rooted, as WebDAV sends them.
+// Operations honor the context of the request, and errors never reveal obfuscated
+// names.
+type FileSystem struct {
+	fs *grainfs.GrainFS
+}
+
+// Ensure FileSystem implements webdav.FileSystem
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+// New returns a WebDAV view of fs
+func New(fs *grainfs.GrainFS) *FileSystem {
+	return &FileSystem{fs: fs}
+}
+
+// rootedUserPath converts a slash-separated rooted name, as WebDAV sends them, to a
+// user path, with "." for the root
+func rootedUserPath(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "."
+	}
+	return filepath.FromSlash(name)
+}
+
+// pathError reports the error err of op on name, dropping the path of err, which may
+// be obfuscated
+func pathError(op, name string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Mkdir creates the directory name. Its parent must exist.
+func (w *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fs := w.fs.WithContext(ctx)
+	userPath := rootedUserPath(name)
+
+	if _, err := fs.Stat(userPath); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return pathError("mkdir", name, err)
+	}
+	if parent, err := fs.Stat(filepath.Dir(userPath)); err != nil || !parent.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	if err := fs.MkdirAll(userPath, perm); err != nil {
+		return pathError("mkdir", name, err)
+	}
+	return nil
+}
+
+// OpenFile opens the file or directory name. Directories and files opened read-only
+// support seeking, and files opened for writing are replaced by what is written when
+// they are closed. Files are only created in existing directories.
+func (w *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fs := w.fs.WithContext(ctx)
+	userPath := rootedUserPath(name)
+
+	if flag&writeFlags == 0 {
+		fsys, ioName := fs.IOFS(), filepath.ToSlash(userPath)
+		file, err := fsys.Open(ioName)
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, pathError("open", name, err)
+		}
+		if info.IsDir() {
+			return &webDAVDir{ReadDirFile: file.(iofs.ReadDirFile), fsys: fsys, ioName: ioName, name: name}, nil
+		}
+		return &webDAVFile{File: file, name: name}, nil
+	}
+
+	if flag&os.O_CREATE != 0 {
+		if parent, err := fs.Stat(filepath.Dir(userPath)); err != nil || !parent.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+	}
+	file, err := fs.OpenFile(userPath, flag, perm)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &webDAVWriter{fs: fs, file: file, name: name, userPath: userPath}, nil
+}
+
+// RemoveAll removes name and, for a directory, everything below it
+func (w *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	userPath := rootedUserPath(name)
+	if userPath == "." {
+		return &os.PathError{Op: "removeall", Path: name, Err: os.ErrInvalid}
+	}
+
+	if err := w.fs.WithContext(ctx).RemoveAll(userPath); err != nil {
+		return pathError("removeall", name, err)
+	}
+	return nil
+}
+
+// Rename moves oldName to newName, replacing newName if it is a file
+func (w *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, newPath := rootedUserPath(oldName), rootedUserPath(newName)
+	if oldPath == "." || newPath == "." {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrInvalid}
+	}
+
+	if err := w.fs.WithContext(ctx).Rename(oldPath, newPath); err != nil {
+		// Drop the paths of the error, which may be obfuscated
+		var pathErr *os.PathError
+		var linkErr *os.LinkError
+		switch {
+		case errors.As(err, &pathErr):
+			err = pathErr.Err
+		case errors.As(err, &linkErr):
+			err = linkErr.Err
+		}
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	return nil
+}
+
+// Stat returns information about name, following symbolic links
+func (w *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := w.fs.WithContext(ctx).Stat(rootedUserPath(name))
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// webDAVFile is a file opened read-only through a FileSystem
+type webDAVFile struct {
+	iofs.File
+	name string
+}
+
+// Seek sets the offset for the next Read
+func (f *webDAVFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+// Readdir fails, as files have no entries
+func (f *webDAVFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+}
+
+// Write fails, as the file is opened read-only
+func (f *webDAVFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+// webDAVDir is a directory opened through a FileSystem
+type webDAVDir struct {
+	iofs.ReadDirFile
+	fsys   iofs.FS
+	ioName string
+	name   string
+}
+
+// Readdir returns the next count entries of the directory, or all remaining ones if
+// count <= 0
+func (d *webDAVDir) Readdir(count int) ([]os.FileInfo, error) {
+	entries, err := d.ReadDir(count)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, pathError("readdir", d.name, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Seek only rewinds the listing to the start
+func (d *webDAVDir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, &os.PathError{Op: "seek", Path: d.name, Err: os.ErrInvalid}
+	}
+	// Reopen the directory, which lists it anew
+	file, err := d.fsys.Open(d.ioName)
+	if err != nil {
+		return 0, pathError("seek", d.name, err)
+	}
+	d.ReadDirFile.Close()
+	d.ReadDirFile = file.(iofs.ReadDirFile)
+	return 0, nil
+}
+
+// Write fails, as directories have no content
+func (d *webDAVDir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// webDAVWriter is a file opened for writing through a FileSystem
+type webDAVWriter struct {
+	fs       *grainfs.GrainFS
+	file     billy.File
+	name     string
+	userPath string
+	written  int64
+}
+
+// Write encrypts and writes data to the file
+func (f *webDAVWriter) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.written += int64(n)
+	if err != nil {
+		return n, pathError("write", f.name, err)
+	}
+	return n, nil
+}
+
+// Read fails, as the file is opened for writing
+func (f *webDAVWriter) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+}
+
+// Seek fails, as written content is encrypted as a stream
+func (f *webDAVWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+}
+
+// Readdir fails, as files have no entries
+func (f *webDAVWriter) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+}
+
+// Stat returns information about the file, with the size of what was written so far,
+// as the content is only stored when the file is closed
+func (f *webDAVWriter) Stat() (os.FileInfo, error) {
+	info, err := f.fs.Stat(f.userPath)
+	if err != nil {
+		return nil, pathError("stat", f.name, err)
+	}
+	return &writtenFileInfo{FileInfo: info, size: f.written}, nil
+}
+
+// writtenFileInfo is the information about a file being written, with the size of what
+// was written so far
+type writtenFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+// Size returns the size of what was written so far
+func (i *writtenFileInfo) Size() int64 {
+	return i.size
+}
+
+// Close stores the content of the file
+func (f *webDAVWriter) Close() error {
+	if err := f.file.Close(); err != nil {
+		return pathError("close", f.name, err)
+	}
+	return nil
+}
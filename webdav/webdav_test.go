@@ -0,0 +1,180 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/NovaCove/grainfs"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/studio-b12/gowebdav"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV(t *testing.T) {
+	fs, err := grainfs.New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	if err := util.WriteFile(fs, "docs/hello.txt", []byte("hello, world"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	server := httptest.NewServer(&webdav.Handler{FileSystem: New(fs), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+	client := gowebdav.NewClient(server.URL, "", "")
+
+	infos, err := client.ReadDir("/docs")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "hello.txt" || infos[0].Size() != 12 {
+		t.Fatalf("Unexpected listing %+v", infos)
+	}
+
+	stream, err := client.ReadStreamRange("/docs/hello.txt", 7, 5)
+	if err != nil {
+		t.Fatalf("ReadStreamRange failed: %v", err)
+	}
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil || string(data) != "world" {
+		t.Fatalf("Expected the range to read world, got %q: %v", data, err)
+	}
+
+	// Writes land encrypted in the filesystem
+	if err := client.Mkdir("/new", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := client.Write("/new/a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if content, err := util.ReadFile(fs, "new/a.txt"); err != nil || string(content) != "alpha" {
+		t.Fatalf("Expected the written content, got %q: %v", content, err)
+	}
+	if err := client.Write("/new/a.txt", []byte("replaced"), 0644); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if info, err := client.Stat("/new/a.txt"); err != nil || info.Size() != 8 {
+		t.Fatalf("Expected the replaced file to be 8 bytes, got %+v: %v", info, err)
+	}
+
+	if err := client.Copy("/new", "/copy", false); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if err := client.Rename("/docs/hello.txt", "/copy/hello.txt", false); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := client.Rename("/copy/a.txt", "/copy/hello.txt", false); err == nil {
+		t.Fatalf("Expected Rename without overwrite onto a file to fail")
+	}
+	infos, err = fs.ReadDir("copy")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "hello.txt" {
+		t.Fatalf("Expected a.txt and hello.txt in copy, got %v", names)
+	}
+
+	if err := client.RemoveAll("/new"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if _, err := fs.Stat("new"); !os.IsNotExist(err) {
+		t.Fatalf("Expected new to be removed, got %v", err)
+	}
+	if _, err := client.Read("/new/a.txt"); err == nil {
+		t.Fatalf("Expected reading a removed file to fail")
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	fs, err := grainfs.New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+	dav := New(fs)
+	ctx := context.Background()
+
+	// Creating needs an existing parent, as WebDAV answers 409 Conflict otherwise
+	if err := dav.Mkdir(ctx, "/a/b", 0755); !os.IsNotExist(err) {
+		t.Fatalf("Expected Mkdir without a parent to fail with not exist, got %v", err)
+	}
+	if _, err := dav.OpenFile(ctx, "/a/b.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); !os.IsNotExist(err) {
+		t.Fatalf("Expected creating a file without a parent to fail with not exist, got %v", err)
+	}
+	if err := dav.Mkdir(ctx, "/a", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := dav.Mkdir(ctx, "/a", 0755); !os.IsExist(err) {
+		t.Fatalf("Expected Mkdir of an existing directory to fail with exist, got %v", err)
+	}
+
+	file, err := dav.OpenFile(ctx, "/a/b.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := file.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// The size is known before the content is stored, for the ETag of the response
+	if info, err := file.Stat(); err != nil || info.Size() != 10 || info.Name() != "b.txt" {
+		t.Fatalf("Expected 10 bytes written to b.txt, got %+v: %v", info, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	file, err = dav.OpenFile(ctx, "/a/b.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := file.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if data, err := io.ReadAll(file); err != nil || string(data) != "6789" {
+		t.Fatalf("Expected to read 6789 after seeking, got %q: %v", data, err)
+	}
+	if _, err := file.Write([]byte("x")); err == nil {
+		t.Fatalf("Expected writing a file opened read-only to fail")
+	}
+	file.Close()
+
+	dir, err := dav.OpenFile(ctx, "/a", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer dir.Close()
+	for i := 0; i < 2; i++ {
+		if infos, err := dir.Readdir(0); err != nil || len(infos) != 1 || infos[0].Name() != "b.txt" {
+			t.Fatalf("Expected b.txt in the listing, got %+v: %v", infos, err)
+		}
+		if _, err := dir.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+	}
+	if _, err := dir.Readdir(1); err != nil {
+		t.Fatalf("Readdir failed: %v", err)
+	}
+	if _, err := dir.Readdir(1); err != io.EOF {
+		t.Fatalf("Expected io.EOF at the end of the listing, got %v", err)
+	}
+
+	if err := dav.RemoveAll(ctx, "/"); err == nil {
+		t.Fatalf("Expected removing the root to fail")
+	}
+	if err := dav.Rename(ctx, "/a", "/"); err == nil {
+		t.Fatalf("Expected renaming onto the root to fail")
+	}
+	if _, err := dav.Stat(ctx, "/missing"); !os.IsNotExist(err) {
+		t.Fatalf("Expected Stat of a missing path to fail with not exist, got %v", err)
+	}
+}
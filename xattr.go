@@ -0,0 +1,112 @@
+package grainfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// ErrXattrNotFound is returned when a requested extended attribute does not exist
+var ErrXattrNotFound = errors.New("extended attribute not found")
+
+// Extended attributes are stored inside the entry's encrypted metadata record, so
+// neither their names nor their values are visible on the underlying filesystem.
+// They follow the entry across Rename and are dropped by Remove.
+
+// SetXattr sets the extended attribute attr of the named file to value
+func (fs *GrainFS) SetXattr(name, attr string, value []byte) error {
+	if err := fs.checkWritable("setxattr", name); err != nil {
+		return err
+	}
+
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return err
+	}
+
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	if attr == "" {
+		return fmt.Errorf("attribute name cannot be empty")
+	}
+
+	return fs.updateMetadata(name, func(md *FileMetadata) {
+		if md.Xattrs == nil {
+			md.Xattrs = make(map[string][]byte)
+		}
+		md.Xattrs[attr] = append([]byte(nil), value...)
+	})
+}
+
+// GetXattr returns the value of the extended attribute attr of the named file
+func (fs *GrainFS) GetXattr(name, attr string) ([]byte, error) {
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := fs.lookupMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, exists := md.Xattrs[attr]
+	if !exists {
+		return nil, fmt.Errorf("%s: %s: %w", name, attr, ErrXattrNotFound)
+	}
+
+	return append([]byte(nil), value...), nil
+}
+
+// ListXattr returns the sorted names of the extended attributes of the named file
+func (fs *GrainFS) ListXattr(name string) ([]string, error) {
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := fs.lookupMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make([]string, 0, len(md.Xattrs))
+	for attr := range md.Xattrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	return attrs, nil
+}
+
+// RemoveXattr removes the extended attribute attr from the named file
+func (fs *GrainFS) RemoveXattr(name, attr string) error {
+	if err := fs.checkWritable("removexattr", name); err != nil {
+		return err
+	}
+
+	name, err := fs.resolveSymlinks(name, true)
+	if err != nil {
+		return err
+	}
+
+	unlock := fs.lockDir(filepath.Dir(name))
+	defer unlock()
+
+	md, err := fs.lookupMetadata(name)
+	if err != nil {
+		return err
+	}
+	if _, exists := md.Xattrs[attr]; !exists {
+		return fmt.Errorf("%s: %s: %w", name, attr, ErrXattrNotFound)
+	}
+
+	return fs.updateMetadata(name, func(md *FileMetadata) {
+		delete(md.Xattrs, attr)
+		if len(md.Xattrs) == 0 {
+			md.Xattrs = nil
+		}
+	})
+}
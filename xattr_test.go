@@ -0,0 +1,133 @@
+package grainfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestGrainFSXattrs(t *testing.T) {
+	underlying := memfs.New()
+	password := "test-password-123"
+
+	fs, err := New(underlying, password)
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("report.pdf", []byte("report")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := fs.SetXattr("report.pdf", "user.sha256", []byte("deadbeef")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+	if err := fs.SetXattr("report.pdf", "user.classification", []byte("confidential")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+
+	value, err := fs.GetXattr("report.pdf", "user.sha256")
+	if err != nil {
+		t.Fatalf("GetXattr failed: %v", err)
+	}
+	if string(value) != "deadbeef" {
+		t.Fatalf("Expected xattr value deadbeef, got %s", value)
+	}
+
+	attrs, err := fs.ListXattr("report.pdf")
+	if err != nil {
+		t.Fatalf("ListXattr failed: %v", err)
+	}
+	if !reflect.DeepEqual(attrs, []string{"user.classification", "user.sha256"}) {
+		t.Fatalf("Unexpected xattr list: %v", attrs)
+	}
+
+	if err := fs.RemoveXattr("report.pdf", "user.sha256"); err != nil {
+		t.Fatalf("RemoveXattr failed: %v", err)
+	}
+	if _, err := fs.GetXattr("report.pdf", "user.sha256"); !errors.Is(err, ErrXattrNotFound) {
+		t.Fatalf("Expected ErrXattrNotFound, got %v", err)
+	}
+	if err := fs.RemoveXattr("report.pdf", "user.sha256"); !errors.Is(err, ErrXattrNotFound) {
+		t.Fatalf("Expected ErrXattrNotFound removing twice, got %v", err)
+	}
+
+	// Attribute names and values never appear in plaintext on the underlying filesystem
+	file, err := underlying.Open(GrainFSDir + "/" + MetadataFile)
+	if err != nil {
+		t.Fatalf("Failed to open raw metadata: %v", err)
+	}
+	raw, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Failed to read raw metadata: %v", err)
+	}
+	if bytes.Contains(raw, []byte("classification")) || bytes.Contains(raw, []byte("confidential")) {
+		t.Fatalf("Raw metadata should not contain plaintext xattrs")
+	}
+
+	// Attributes persist across instances
+	fs2, err := New(underlying, password)
+	if err != nil {
+		t.Fatalf("Failed to reopen GrainFS: %v", err)
+	}
+	value, err = fs2.GetXattr("report.pdf", "user.classification")
+	if err != nil || string(value) != "confidential" {
+		t.Fatalf("Expected persisted xattr, got %q (%v)", value, err)
+	}
+}
+
+func TestGrainFSXattrsRenameAndRemove(t *testing.T) {
+	fs, err := New(memfs.New(), "test-password-123")
+	if err != nil {
+		t.Fatalf("Failed to create GrainFS: %v", err)
+	}
+
+	if _, err := fs.Write("a/file.txt", []byte("data")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fs.SetXattr("a/file.txt", "user.tag", []byte("blue")); err != nil {
+		t.Fatalf("SetXattr failed: %v", err)
+	}
+	if err := fs.SetXattr("a", "user.owner", []byte("team")); err != nil {
+		t.Fatalf("SetXattr on directory failed: %v", err)
+	}
+
+	if err := fs.MkdirAll("b", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := fs.Rename("a/file.txt", "b/moved.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	value, err := fs.GetXattr("b/moved.txt", "user.tag")
+	if err != nil || string(value) != "blue" {
+		t.Fatalf("Expected xattr to follow rename, got %q (%v)", value, err)
+	}
+
+	if err := fs.Rename("a", "c"); err != nil {
+		t.Fatalf("Rename of directory failed: %v", err)
+	}
+	value, err = fs.GetXattr("c", "user.owner")
+	if err != nil || string(value) != "team" {
+		t.Fatalf("Expected directory xattr to follow rename, got %q (%v)", value, err)
+	}
+
+	if err := fs.Remove("b/moved.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := fs.Write("b/moved.txt", []byte("new")); err != nil {
+		t.Fatalf("Failed to recreate file: %v", err)
+	}
+	attrs, err := fs.ListXattr("b/moved.txt")
+	if err != nil {
+		t.Fatalf("ListXattr failed: %v", err)
+	}
+	if len(attrs) != 0 {
+		t.Fatalf("Expected no xattrs on recreated file, got %v", attrs)
+	}
+}